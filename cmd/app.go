@@ -1,15 +1,321 @@
 package cmd
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/skamranahmed/estimatex-server/internal/admin"
+	"github.com/skamranahmed/estimatex-server/internal/cluster"
+	"github.com/skamranahmed/estimatex-server/internal/config"
 	"github.com/skamranahmed/estimatex-server/internal/controller"
+	"github.com/skamranahmed/estimatex-server/internal/entity"
+	"github.com/skamranahmed/estimatex-server/internal/health"
+	"github.com/skamranahmed/estimatex-server/internal/invite"
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+	"github.com/skamranahmed/estimatex-server/internal/metrics"
+	"github.com/skamranahmed/estimatex-server/internal/ratelimit"
+	"github.com/skamranahmed/estimatex-server/internal/schema"
+	"github.com/skamranahmed/estimatex-server/internal/session"
+	"github.com/skamranahmed/estimatex-server/internal/snapshot"
+	"github.com/skamranahmed/estimatex-server/internal/tlscert"
+	"github.com/skamranahmed/estimatex-server/internal/tracing"
+	"github.com/skamranahmed/estimatex-server/internal/tracker"
+	"github.com/skamranahmed/estimatex-server/internal/webhook"
+)
+
+const (
+	// drainPollInterval is how often the number of open rooms is checked while draining
+	drainPollInterval = 500 * time.Millisecond
+
+	// shutdownTimeout is the time given to the in-flight HTTP requests to complete once draining is over
+	shutdownTimeout = 5 * time.Second
 )
 
 func Run() error {
-	http.HandleFunc("/ws", controller.ServeWS)
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	err = logger.Setup(cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TracingExporter)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
+	roomIDGenerator, err := session.NewRoomIDGenerator(cfg.RoomIDStyle, cfg.RoomIDLength, cfg.RoomIDAlphabet)
+	if err != nil {
+		return err
+	}
+
+	memberEventLimiter := ratelimit.PerMinute(cfg.MemberEventsPerMinute, cfg.MemberEventsBurst)
+	roomBroadcastLimiter := ratelimit.PerMinute(cfg.RoomBroadcastsPerMinute, cfg.RoomBroadcastsBurst)
+
+	webhooks := newWebhookDispatcher(cfg)
+
+	issueTracker, err := tracker.New(tracker.Config{
+		Name: cfg.Tracker,
+		Jira: tracker.JiraConfig{
+			BaseURL:          cfg.JiraBaseURL,
+			Email:            cfg.JiraEmail,
+			APIToken:         cfg.JiraAPIToken,
+			StoryPointsField: cfg.JiraStoryPointsField,
+		},
+		GitHub: tracker.GitHubConfig{
+			APIURL:              cfg.GitHubAPIURL,
+			Repository:          cfg.GitHubRepository,
+			Token:               cfg.GitHubToken,
+			EstimateLabelPrefix: cfg.GitHubEstimateLabelPrefix,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	clusterBackend, err := cluster.NewBackend(cluster.BackendConfig{
+		Name:     cfg.ClusterBackend,
+		RedisURL: cfg.RedisURL,
+	})
+	if err != nil {
+		return err
+	}
+	defer clusterBackend.Close()
+
+	clusterNode := cluster.NewNode(cluster.Config{
+		NodeID:   cfg.NodeID,
+		LeaseTTL: cfg.ClusterLeaseTTL,
+	}, clusterBackend)
+
+	// the interface is only set when the snapshots are enabled, so that it is nil otherwise. The instances of a cluster
+	// keep the snapshots in the backend, so that the rooms of an instance which is gone are taken over by the others.
+	var snapshotStore snapshot.Store
+	if cfg.ClusterBackend == "redis" {
+		snapshotStore = cluster.NewSnapshotStore(clusterNode)
+	} else if cfg.SnapshotDir != "" {
+		fileStore, err := snapshot.NewFileStore(cfg.SnapshotDir)
+		if err != nil {
+			return err
+		}
+		snapshotStore = fileStore
+	}
+
+	sessionManager := session.NewManager(session.ManagerConfig{
+		RoomIDGenerator:      roomIDGenerator,
+		InviteSigner:         invite.NewSigner(cfg.InviteTokenSecret),
+		MemberEventLimiter:   memberEventLimiter,
+		RoomBroadcastLimiter: roomBroadcastLimiter,
+		MaxRooms:             cfg.MaxRooms,
+		FieldLimits: entity.FieldLimits{
+			TicketID: cfg.MaxTicketIDLength,
+			Vote:     cfg.MaxVoteLength,
+		},
+		Webhooks:         webhooks,
+		JoinURLTemplate:  cfg.JoinURLTemplate,
+		Cluster:          clusterNode,
+		Tracker:          issueTracker,
+		Snapshots:        snapshotStore,
+		SnapshotInterval: cfg.SnapshotInterval,
+		ResumeWindow:     cfg.ResumeWindow,
+		ReplayBufferSize: cfg.ReplayBufferSize,
+	})
+	wsController := controller.New(cfg, sessionManager, clusterNode)
+
+	// the node starts receiving the relayed clients once the controller handles them
+	err = clusterNode.Start(context.Background())
+	if err != nil {
+		return err
+	}
+
+	// the rooms of the previous process are restored before the server accepts connections
+	restoredRooms, err := sessionManager.RestoreRooms(context.Background())
+	if err != nil {
+		return err
+	}
+	if restoredRooms > 0 {
+		slog.Info("Rooms restored from the snapshots", "rooms", restoredRooms, "resume_window", cfg.ResumeWindow.String())
+	}
+	sessionManager.StartSnapshots()
+
+	metrics.RegisterRejectedCounter("upgrades", wsController.RejectedUpgrades)
+	metrics.RegisterRejectedCounter("join_attempts", wsController.RejectedJoinAttempts)
+	metrics.RegisterRejectedCounter("member_events", memberEventLimiter.Rejected)
+	metrics.RegisterRejectedCounter("room_broadcasts", roomBroadcastLimiter.Rejected)
+
+	healthChecker := health.NewChecker(func() health.Stats {
+		return health.Stats{
+			ActiveRooms:       sessionManager.RoomsCount(),
+			ActiveConnections: wsController.ActiveConnections(),
+		}
+	})
+	healthChecker.AddReadinessCheck("cluster", clusterBackend.Ping)
+	if snapshotStore != nil {
+		healthChecker.AddReadinessCheck("storage", snapshotStore.Ping)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", wsController.ServeWS)
+
+	// the clients whose proxies do not let websocket upgrades through connect with HTTP requests instead
+	mux.HandleFunc("GET /sse", wsController.ServeSSE)
+	mux.HandleFunc("POST /connections", wsController.OpenPolledConnection)
+	mux.HandleFunc("GET /connections/{connection_id}/messages", wsController.PollMessages)
+	mux.HandleFunc("POST /connections/{connection_id}/events", wsController.PostEvent)
+	mux.HandleFunc("DELETE /connections/{connection_id}", wsController.CloseHTTPConnection)
+	mux.HandleFunc("OPTIONS /connections", wsController.PreflightHTTPConnection)
+	mux.HandleFunc("OPTIONS /connections/", wsController.PreflightHTTPConnection)
+
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", healthChecker.HealthzHandler)
+	mux.HandleFunc("/readyz", healthChecker.ReadyzHandler)
+	mux.HandleFunc("/version", healthChecker.VersionHandler)
+	mux.Handle("/schema", schema.Protocol())
+
+	if cfg.AdminToken != "" {
+		mux.Handle("/admin/", admin.NewHandler(cfg.AdminToken, sessionManager))
+	} else {
+		slog.Info("The admin API is disabled, set ESTIMATEX_ADMIN_TOKEN to enable it")
+	}
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
+		Handler: mux,
+	}
+
+	serverErrors := make(chan error, 2)
+
+	if cfg.TLSEnabled() {
+		certificateReloader, err := tlscert.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		// the reloader lives as long as the server, hence it is never stopped
+		go certificateReloader.Watch(cfg.TLSReloadInterval, nil)
+
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certificateReloader.GetCertificate,
+		}
+
+		if cfg.HTTPRedirectPort != 0 {
+			go func() {
+				slog.Info("Redirecting HTTP requests to HTTPS", "port", cfg.HTTPRedirectPort)
+				serverErrors <- http.ListenAndServe(fmt.Sprintf(":%d", cfg.HTTPRedirectPort), httpsRedirectHandler(cfg.Port))
+			}()
+		}
+
+		go func() {
+			slog.Info("Server is running with TLS", "port", cfg.Port)
+			// the certificate and key files are empty because the certificate is served by the TLSConfig
+			serverErrors <- server.ListenAndServeTLS("", "")
+		}()
+	} else {
+		go func() {
+			slog.Info("Server is running", "port", cfg.Port)
+			serverErrors <- server.ListenAndServe()
+		}()
+	}
+
+	shutdownSignal, stopListeningForSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopListeningForSignals()
+
+	select {
+	case err := <-serverErrors:
+		return err
+	case <-shutdownSignal.Done():
+	}
+
+	/*
+		Graceful shutdown: the readiness endpoint starts failing and the new connections are refused, so that
+		the load balancer moves the new clients to another instance. The rooms which are already open are given
+		some time to finish their estimation before the server stops.
+	*/
+	slog.Info("Received a shutdown signal, draining the server", "drain_timeout", cfg.DrainTimeout.String())
+	healthChecker.StartDraining()
+	wsController.Drain()
+
+	waitForRoomsToClose(sessionManager, cfg.DrainTimeout)
+
+	shutdownContext, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(shutdownContext)
+	if err != nil {
+		return err
+	}
+
+	// the events of the rooms closed while draining are still delivered
+	err = webhooks.Close(shutdownContext)
+	if err != nil {
+		slog.Warn("Some webhooks could not be delivered before the shutdown", logger.KeyError, err)
+	}
+
+	// the rooms which are still open are restored by the next process
+	sessionManager.StopSnapshots()
+
+	// the leases are released, so that the other instances do not wait for them to expire
+	clusterNode.Close(shutdownContext)
+
+	slog.Info("Server stopped", "active_rooms", sessionManager.RoomsCount())
+	return nil
+}
+
+// newWebhookDispatcher: returns nil, which disables the webhooks, when neither the server wide
+// nor the room webhooks, nor the chat webhooks are configured
+func newWebhookDispatcher(cfg *config.Config) *webhook.Dispatcher {
+	if len(cfg.WebhookURLs) == 0 && len(cfg.RoomWebhookAllowedHosts) == 0 && len(cfg.ChatWebhookAllowedHosts) == 0 {
+		return nil
+	}
+
+	subscriptions := make([]webhook.Subscription, 0, len(cfg.WebhookURLs))
+	for _, webhookURL := range cfg.WebhookURLs {
+		subscriptions = append(subscriptions, webhook.Subscription{URL: webhookURL, Secret: cfg.WebhookSecret})
+	}
+
+	return webhook.NewDispatcher(webhook.Config{
+		Subscriptions:  subscriptions,
+		MaxAttempts:    cfg.WebhookMaxAttempts,
+		DeadLetterFile: cfg.WebhookDeadLetterFile,
+	})
+}
+
+// waitForRoomsToClose: blocks until all the rooms are closed or the timeout expires
+func waitForRoomsToClose(sessionManager *session.SessionManager, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for sessionManager.RoomsCount() > 0 && time.Now().Before(deadline) {
+		<-ticker.C
+	}
+}
+
+// httpsRedirectHandler: permanently redirects every request to the same host and path over HTTPS
+func httpsRedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			// the Host header does not have a port
+			host = r.Host
+		}
+
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
 
-	log.Println("Server is running on port 8080")
-	return http.ListenAndServe(":8080", nil)
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
 }
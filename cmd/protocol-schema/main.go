@@ -0,0 +1,35 @@
+// protocol-schema prints the JSON Schema of the event protocol, or its documentation in markdown,
+// so that they can be generated without running the server.
+//
+//	go run ./cmd/protocol-schema -format markdown > PROTOCOL.md
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/skamranahmed/estimatex-server/internal/schema"
+)
+
+func main() {
+	format := flag.String("format", "json", "output format, either json or markdown")
+	flag.Parse()
+
+	protocol := schema.Protocol()
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(protocol.Root)
+
+	case "markdown":
+		fmt.Print(protocol.Markdown())
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q, expected json or markdown\n", *format)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,70 @@
+// webhook-receiver is a development server which receives the webhooks of estimatex-server, checks their
+// signature and prints them. It can fail the first deliveries to exercise the retries of the server.
+//
+//	go run ./cmd/webhook-receiver -port 9090 -secret my-secret -fail-first 2
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+	"github.com/skamranahmed/estimatex-server/internal/webhook"
+)
+
+// signatureTolerance is how old a delivery can be before it is rejected
+const signatureTolerance = 5 * time.Minute
+
+func main() {
+	port := flag.Int("port", 9090, "port on which the receiver listens")
+	secret := flag.String("secret", os.Getenv("ESTIMATEX_WEBHOOK_SECRET"), "secret used to verify the signatures, defaults to ESTIMATEX_WEBHOOK_SECRET")
+	failFirst := flag.Int64("fail-first", 0, "number of deliveries answered with a 500 before accepting them")
+	flag.Parse()
+
+	var received atomic.Int64
+
+	http.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "unable to read the body", http.StatusBadRequest)
+			return
+		}
+
+		requestLogger := slog.With("webhook_event", r.Header.Get(webhook.HeaderEvent), "webhook_delivery", r.Header.Get(webhook.HeaderDelivery))
+
+		err = webhook.Verify(*secret, r.Header.Get(webhook.HeaderTimestamp), body, r.Header.Get(webhook.HeaderSignature), signatureTolerance)
+		if err != nil {
+			requestLogger.Warn("Rejecting a webhook", logger.KeyError, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		if received.Add(1) <= *failFirst {
+			requestLogger.Info("Failing a webhook on purpose")
+			http.Error(w, "failing on purpose", http.StatusInternalServerError)
+			return
+		}
+
+		var indentedBody bytes.Buffer
+		json.Indent(&indentedBody, body, "", "  ")
+		requestLogger.Info("Received a webhook")
+		fmt.Println(indentedBody.String())
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	slog.Info("Webhook receiver is running", "port", *port)
+	err := http.ListenAndServe(fmt.Sprintf(":%d", *port), nil)
+	if err != nil {
+		slog.Error("Webhook receiver stopped", logger.KeyError, err)
+		os.Exit(1)
+	}
+}
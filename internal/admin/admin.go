@@ -0,0 +1,229 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skamranahmed/estimatex-server/internal/entity"
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+	"github.com/skamranahmed/estimatex-server/internal/session"
+	"github.com/skamranahmed/estimatex-server/internal/tracing"
+)
+
+// RoomSummary: the fields of a room reported by the admin API
+type RoomSummary struct {
+	ID                  string           `json:"id"`
+	MaxCapacity         int              `json:"max_capacity"`
+	MembersCount        int              `json:"members_count"`
+	Phase               entity.RoomPhase `json:"phase"`
+	CurrentTicketID     string           `json:"current_ticket_id,omitempty"`
+	IsPasswordProtected bool             `json:"is_password_protected"`
+	CreatedAt           time.Time        `json:"created_at"`
+	AgeSeconds          int64            `json:"age_seconds"`
+}
+
+// RoomDetails: a room along with its roster
+type RoomDetails struct {
+	RoomSummary
+	Members []MemberSummary `json:"members"`
+}
+
+// MemberSummary: the fields of a member reported by the admin API, HasVoted is only
+// meaningful while a voting is in progress
+type MemberSummary struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	IsRoomAdmin bool      `json:"is_room_admin"`
+	IsMuted     bool      `json:"is_muted"`
+	JoinedAt    time.Time `json:"joined_at"`
+	HasVoted    bool      `json:"has_voted"`
+}
+
+// Handler: serves the admin API, every request must carry the admin token as a bearer token
+type Handler struct {
+	sessionManager *session.SessionManager
+	token          []byte
+	mux            *http.ServeMux
+}
+
+func NewHandler(token string, sessionManager *session.SessionManager) *Handler {
+	h := &Handler{
+		sessionManager: sessionManager,
+		token:          []byte(token),
+		mux:            http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("GET /admin/rooms", h.ListRooms)
+	h.mux.HandleFunc("GET /admin/rooms/{roomID}", h.GetRoom)
+	h.mux.HandleFunc("DELETE /admin/rooms/{roomID}", h.CloseRoom)
+	h.mux.HandleFunc("DELETE /admin/rooms/{roomID}/members/{memberID}", h.KickMember)
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.isAuthorized(r) {
+		slog.Warn("Unauthorized admin API request", "method", r.Method, "path", r.URL.Path, logger.KeyRemoteAddr, r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="estimatex-admin"`)
+		writeError(w, http.StatusUnauthorized, "a valid admin token is required")
+		return
+	}
+
+	h.mux.ServeHTTP(w, r)
+}
+
+// ListRooms: lists all the active rooms, the oldest first
+func (h *Handler) ListRooms(w http.ResponseWriter, r *http.Request) {
+	rooms := h.sessionManager.Rooms()
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].CreatedAt.Before(rooms[j].CreatedAt)
+	})
+
+	roomSummaries := make([]RoomSummary, 0, len(rooms))
+	for _, room := range rooms {
+		roomSummaries = append(roomSummaries, summarizeRoom(room))
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"rooms": roomSummaries})
+}
+
+// GetRoom: returns a room along with its roster
+func (h *Handler) GetRoom(w http.ResponseWriter, r *http.Request) {
+	room := h.findRoom(w, r)
+	if room == nil {
+		return
+	}
+
+	roomDetails := RoomDetails{
+		RoomSummary: summarizeRoom(room),
+		Members:     []MemberSummary{},
+	}
+
+	members := room.GetMembers()
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].JoinedAt.Before(members[j].JoinedAt)
+	})
+
+	for _, member := range members {
+		roomDetails.Members = append(roomDetails.Members, MemberSummary{
+			ID:          member.ID,
+			Name:        member.Name,
+			IsRoomAdmin: member.IsRoomAdmin,
+			IsMuted:     member.IsMuted(),
+			JoinedAt:    member.JoinedAt,
+			HasVoted:    roomDetails.CurrentTicketID != "" && room.HasVoted(roomDetails.CurrentTicketID, member.ID),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, roomDetails)
+}
+
+// CloseRoom: informs the members that the room has been closed and disconnects them,
+// the optional `reason` query parameter is included in the message sent to the members
+func (h *Handler) CloseRoom(w http.ResponseWriter, r *http.Request) {
+	room := h.findRoom(w, r)
+	if room == nil {
+		return
+	}
+
+	ctx, span := tracing.Start(r.Context(), "admin close room", tracing.AttributeRoomID.String(room.ID))
+	defer span.End()
+
+	message := "🚪 The room has been closed by an operator."
+	reason := strings.TrimSpace(r.URL.Query().Get("reason"))
+	if reason != "" {
+		message = fmt.Sprintf("🚪 The room has been closed by an operator: %s", reason)
+	}
+
+	room.Close(ctx, message)
+
+	// the room is also removed once its admin is disconnected, it is removed here so that it cannot be joined meanwhile
+	h.sessionManager.RemoveRoom(room)
+
+	slog.Info("Room closed by an operator", logger.KeyRoomID, room.ID, "reason", reason)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// KickMember: removes a member from a room and closes their connection, the member is also
+// banned from the room when the `ban` query parameter is true
+func (h *Handler) KickMember(w http.ResponseWriter, r *http.Request) {
+	room := h.findRoom(w, r)
+	if room == nil {
+		return
+	}
+
+	memberID := r.PathValue("memberID")
+
+	ctx, span := tracing.Start(r.Context(), "admin kick member",
+		tracing.AttributeRoomID.String(room.ID),
+		tracing.AttributeMemberID.String(memberID),
+	)
+	defer span.End()
+
+	ban, _ := strconv.ParseBool(r.URL.Query().Get("ban"))
+
+	kickedMember, err := room.Kick(ctx, memberID, ban, "👢 A member has been removed from the room by an operator.")
+	if errors.Is(err, entity.ErrMemberNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, entity.ErrCannotKickAdmin) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	kickedMember.Logger.Info("Member kicked by an operator", "banned", ban)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isAuthorized: checks the bearer token in constant time
+func (h *Handler) isAuthorized(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), h.token) == 1
+}
+
+// findRoom: returns the room of the request path, or responds with a 404 when it does not exist
+func (h *Handler) findRoom(w http.ResponseWriter, r *http.Request) *entity.Room {
+	room := h.sessionManager.FindRoom(r.PathValue("roomID"))
+	if room == nil {
+		writeError(w, http.StatusNotFound, "room not found")
+	}
+	return room
+}
+
+func summarizeRoom(room *entity.Room) RoomSummary {
+	phase, currentTicketID := room.State()
+
+	return RoomSummary{
+		ID:                  room.ID,
+		MaxCapacity:         room.MaxCapacity,
+		MembersCount:        room.GetRoomMembersCount(),
+		Phase:               phase,
+		CurrentTicketID:     currentTicketID,
+		IsPasswordProtected: room.IsPasswordProtected(),
+		CreatedAt:           room.CreatedAt.UTC(),
+		AgeSeconds:          int64(time.Since(room.CreatedAt).Seconds()),
+	}
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
@@ -1,12 +1,29 @@
 package api
 
 import (
-	"github.com/gorilla/websocket"
+	"github.com/skamranahmed/estimatex-server/internal/metrics"
+	"github.com/skamranahmed/estimatex-server/internal/transport"
 )
 
-// SendErrorResponse: sends an error message via websocket and then closes the websocket connection
-func SendErrorResponse(wsConnection *websocket.Conn, errorDescription string) {
-	wsConnection.WriteMessage(websocket.TextMessage, []byte(errorDescription))
-	wsConnection.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Server closing connection"))
-	wsConnection.Close()
+// ErrorCode identifies the reason why a connection was refused, it is used to count the errors
+type ErrorCode string
+
+const (
+	ErrorCodeBadRequest         ErrorCode = "BAD_REQUEST"
+	ErrorCodeUnsupportedVersion ErrorCode = "UNSUPPORTED_PROTOCOL_VERSION"
+	ErrorCodeRoomNotFound       ErrorCode = "ROOM_NOT_FOUND"
+	ErrorCodeUnauthorized       ErrorCode = "UNAUTHORIZED"
+	ErrorCodeForbidden          ErrorCode = "FORBIDDEN"
+	ErrorCodeRoomFull           ErrorCode = "ROOM_FULL"
+	ErrorCodeTooManyRequests    ErrorCode = "TOO_MANY_REQUESTS"
+	ErrorCodeServiceUnavailable ErrorCode = "SERVICE_UNAVAILABLE"
+	ErrorCodeInternal           ErrorCode = "INTERNAL_ERROR"
+)
+
+// SendErrorResponse: sends an error message to the client and then closes its connection
+func SendErrorResponse(connection transport.Connection, errorCode ErrorCode, errorDescription string) {
+	metrics.Errors.WithLabelValues(string(errorCode)).Inc()
+
+	connection.WriteMessage([]byte(errorDescription))
+	connection.Close(transport.CloseNormalClosure, "Server closing connection")
 }
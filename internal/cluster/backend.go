@@ -0,0 +1,76 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Backend: the state shared by the instances of the server. The leases tell which instance owns a room and
+// which instances are alive, the pub/sub channels relay the messages between the instances, and the states
+// keep the rooms so that another instance takes them over when their owner is gone.
+type Backend interface {
+	// Acquire: takes the lease of the key for the holder, it reports false when another holder has the lease
+	Acquire(ctx context.Context, key string, holder string, ttl time.Duration) (bool, error)
+
+	// Renew: extends the lease of the holder, it reports false when the lease has expired or has been taken by another holder
+	Renew(ctx context.Context, key string, holder string, ttl time.Duration) (bool, error)
+
+	// Release: gives up the lease, it does nothing when the lease is held by another holder
+	Release(ctx context.Context, key string, holder string) error
+
+	// Holder: returns the holder of the lease, it is empty when nobody holds the lease
+	Holder(ctx context.Context, key string) (string, error)
+
+	// StoreState: replaces the state of the key, only while the holder has the lease of leaseKey.
+	// It reports false when the lease is held by another holder or has expired.
+	StoreState(ctx context.Context, key string, state []byte, leaseKey string, holder string) (bool, error)
+
+	// DeleteState: removes the state of the key, it does nothing unless the holder has the lease of leaseKey
+	DeleteState(ctx context.Context, key string, leaseKey string, holder string) error
+
+	// LoadState: returns the state of the key, it is nil when there is no state
+	LoadState(ctx context.Context, key string) ([]byte, error)
+
+	// StateKeys: returns the keys of the states starting with the prefix
+	StateKeys(ctx context.Context, prefix string) ([]string, error)
+
+	Publish(ctx context.Context, channel string, message []byte) error
+
+	// Subscribe: the subscription is active once Subscribe returns, so that the messages published afterwards are not missed
+	Subscribe(ctx context.Context, channel string) (Subscription, error)
+
+	// Ping: reports whether the backend is reachable, it is used by the readiness endpoint
+	Ping(ctx context.Context) error
+
+	Close() error
+}
+
+// Subscription: the messages published on a channel
+type Subscription interface {
+	// Messages: the channel is closed once the subscription is closed
+	Messages() <-chan []byte
+
+	Close() error
+}
+
+// BackendConfig: selects and configures the backend
+type BackendConfig struct {
+	// Name is either memory or redis
+	Name string
+
+	// RedisURL is the URL of the Redis server, like `redis://:password@localhost:6379/0`
+	RedisURL string
+}
+
+// NewBackend: returns the backend selected by the config
+func NewBackend(config BackendConfig) (Backend, error) {
+	switch config.Name {
+	case "", "memory":
+		return NewMemoryBackend(), nil
+	case "redis":
+		return NewRedisBackend(config.RedisURL)
+	default:
+		return nil, fmt.Errorf("unsupported cluster backend: %q, expected either memory or redis", config.Name)
+	}
+}
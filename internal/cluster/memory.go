@@ -0,0 +1,202 @@
+package cluster
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memorySubscriptionBufferSize is the number of messages a subscriber can lag behind before the messages are dropped
+const memorySubscriptionBufferSize = 1024
+
+// MemoryBackend: keeps the leases and the channels in the process. It is the backend of a single instance,
+// and it can also be shared by several nodes running in the same process.
+type MemoryBackend struct {
+	mutex sync.Mutex
+
+	// Key: lease key, Value: memoryLease
+	leases map[string]memoryLease
+
+	// Key: state key, Value: the state
+	states map[string][]byte
+
+	// Key: channel, Value: the subscriptions of the channel
+	subscriptions map[string]map[*memorySubscription]struct{}
+}
+
+type memoryLease struct {
+	holder    string
+	expiresAt time.Time
+}
+
+type memorySubscription struct {
+	backend  *MemoryBackend
+	channel  string
+	messages chan []byte
+	once     sync.Once
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		leases:        make(map[string]memoryLease),
+		states:        make(map[string][]byte),
+		subscriptions: make(map[string]map[*memorySubscription]struct{}),
+	}
+}
+
+func (b *MemoryBackend) Acquire(ctx context.Context, key string, holder string, ttl time.Duration) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	lease, ok := b.leases[key]
+	if ok && time.Now().Before(lease.expiresAt) && lease.holder != holder {
+		return false, nil
+	}
+
+	b.leases[key] = memoryLease{holder: holder, expiresAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (b *MemoryBackend) Renew(ctx context.Context, key string, holder string, ttl time.Duration) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	lease, ok := b.leases[key]
+	if !ok || lease.holder != holder || time.Now().After(lease.expiresAt) {
+		return false, nil
+	}
+
+	b.leases[key] = memoryLease{holder: holder, expiresAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (b *MemoryBackend) Release(ctx context.Context, key string, holder string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if lease, ok := b.leases[key]; ok && lease.holder == holder {
+		delete(b.leases, key)
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Holder(ctx context.Context, key string) (string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	lease, ok := b.leases[key]
+	if !ok || time.Now().After(lease.expiresAt) {
+		return "", nil
+	}
+	return lease.holder, nil
+}
+
+func (b *MemoryBackend) StoreState(ctx context.Context, key string, state []byte, leaseKey string, holder string) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !b.holds(leaseKey, holder) {
+		return false, nil
+	}
+
+	b.states[key] = state
+	return true, nil
+}
+
+func (b *MemoryBackend) DeleteState(ctx context.Context, key string, leaseKey string, holder string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.holds(leaseKey, holder) {
+		delete(b.states, key)
+	}
+	return nil
+}
+
+func (b *MemoryBackend) LoadState(ctx context.Context, key string) ([]byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.states[key], nil
+}
+
+func (b *MemoryBackend) StateKeys(ctx context.Context, prefix string) ([]string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var keys []string
+	for key := range b.states {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// holds: reports whether the holder has the lease of the key, the mutex must be held
+func (b *MemoryBackend) holds(key string, holder string) bool {
+	lease, ok := b.leases[key]
+	return ok && lease.holder == holder && time.Now().Before(lease.expiresAt)
+}
+
+func (b *MemoryBackend) Publish(ctx context.Context, channel string, message []byte) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for subscription := range b.subscriptions[channel] {
+		select {
+		case subscription.messages <- message:
+		default:
+			// like Redis, a subscriber which does not keep up loses the messages
+			slog.Warn("Dropping a cluster message, the subscriber is too slow", "channel", channel)
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	subscription := &memorySubscription{
+		backend:  b,
+		channel:  channel,
+		messages: make(chan []byte, memorySubscriptionBufferSize),
+	}
+
+	if b.subscriptions[channel] == nil {
+		b.subscriptions[channel] = make(map[*memorySubscription]struct{})
+	}
+	b.subscriptions[channel][subscription] = struct{}{}
+
+	return subscription, nil
+}
+
+func (b *MemoryBackend) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (b *MemoryBackend) Close() error {
+	return nil
+}
+
+func (s *memorySubscription) Messages() <-chan []byte {
+	return s.messages
+}
+
+func (s *memorySubscription) Close() error {
+	s.once.Do(func() {
+		s.backend.mutex.Lock()
+		defer s.backend.mutex.Unlock()
+
+		// the messages are only published while holding the mutex, hence no message can be sent on the closed channel
+		delete(s.backend.subscriptions[s.channel], s)
+		if len(s.backend.subscriptions[s.channel]) == 0 {
+			delete(s.backend.subscriptions, s.channel)
+		}
+		close(s.messages)
+	})
+	return nil
+}
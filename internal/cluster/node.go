@@ -0,0 +1,682 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+)
+
+const (
+	// the prefixes of the lease keys and of the channels
+	roomLeasePrefix   = "room:"
+	nodeLeasePrefix   = "node:"
+	roomChannelPrefix = "room:"
+	nodeChannelPrefix = "node:"
+
+	// backendTimeout is the time given to a single call to the backend
+	backendTimeout = 5 * time.Second
+
+	// remoteInboxSize is the number of events of a remote connection which can wait to be handled by the room,
+	// the client is disconnected when it sends more
+	remoteInboxSize = 64
+
+	// relayInboxSize is the number of messages of a relayed client which can wait to be written to its connection,
+	// the client is disconnected when it receives more
+	relayInboxSize = 1024
+)
+
+var (
+	ErrConnectionClosed = errors.New("the remote connection has been closed")
+	ErrNodeIDInUse      = errors.New("another instance is running with the same node id")
+)
+
+// envelopeType: the type of a message exchanged between the nodes
+type envelopeType string
+
+const (
+	// sent by the node of the client to the owner of the room
+	envelopeJoin  envelopeType = "join"
+	envelopeEvent envelopeType = "event"
+	envelopeLeave envelopeType = "leave"
+
+	// sent by the owner of the room to the node of the client
+	envelopeMessage envelopeType = "message"
+	envelopeClose   envelopeType = "close"
+)
+
+// envelope: a message exchanged between the nodes, about the connection of a client
+type envelope struct {
+	Type         envelopeType `json:"type"`
+	ConnectionID string       `json:"connection_id"`
+
+	// NodeID is the node of the client, it is only set on join
+	NodeID string       `json:"node_id,omitempty"`
+	Join   *JoinRequest `json:"join,omitempty"`
+
+	Payload []byte `json:"payload,omitempty"`
+
+	CloseCode   int    `json:"close_code,omitempty"`
+	CloseReason string `json:"close_reason,omitempty"`
+}
+
+// JoinRequest: a client who asks to join a room owned by another node. The credentials of the client are checked
+// by its node against the state of the room, hence they are never sent to the owner.
+type JoinRequest struct {
+	RoomID   string `json:"room_id"`
+	Name     string `json:"name"`
+	RemoteIP string `json:"remote_ip"`
+
+	// Authorized is set once the node of the client has checked its credentials, the requests of the nodes
+	// which sent the credentials to the owner instead are refused
+	Authorized bool `json:"authorized"`
+
+	// InviteID is the id of the invite token the client has joined with, the owner checks that it has not been revoked
+	InviteID string `json:"invite_id,omitempty"`
+
+	// MemberID is set when the client takes back its seat in a restored room, its resume token has been verified
+	MemberID string `json:"member_id,omitempty"`
+
+	// ProtocolVersion is the version of the protocol spoken by the client, it is not set by the instances
+	// which predate the versioning of the protocol
+	ProtocolVersion int `json:"protocol_version,omitempty"`
+
+	// LastSeq is the sequence number of the latest event received by a client who resumes its seat, it is nil
+	// when the client does not ask for the events it has missed
+	LastSeq *uint64 `json:"last_seq,omitempty"`
+}
+
+// JoinHandler: admits the client into the room, or refuses it by closing the connection
+type JoinHandler func(request JoinRequest, connection *RemoteConnection)
+
+// OrphanedRoomHandler: takes over a room whose owner is gone
+type OrphanedRoomHandler func(roomID string)
+
+// LostRoomHandler: stops serving a room whose lease has been lost, since another node may own it now
+type LostRoomHandler func(roomID string)
+
+// Config: the identity of the node and the duration of its leases
+type Config struct {
+	// NodeID must be unique among the instances sharing the backend
+	NodeID string
+
+	// LeaseTTL is how long a room stays owned by a node which has stopped renewing its leases,
+	// the leases are renewed every third of it
+	LeaseTTL time.Duration
+}
+
+/*
+Node: an instance of the server in the cluster.
+
+A room is owned by the node on which it has been created, and it is only served by its owner. A client connected
+to another node is relayed to the owner: its events are published on the channel of the room, and the owner
+publishes the events sent to the client on the channel of the client's node, in batches.
+
+Every node holds a lease on its rooms and on its own id, and writes the state of its rooms to the backend with
+a SnapshotStore. When a node stops renewing its leases, a node relaying clients to its rooms takes them over from
+their state before disconnecting the clients with the 1012 (service restart) close code, so that they reconnect
+and resume their seats. The owners of the rooms remove the members who were connected through the node.
+A node which cannot renew the lease of a room before it expires stops serving the room, so that a room is never
+served by two nodes.
+*/
+type Node struct {
+	id       string
+	backend  Backend
+	leaseTTL time.Duration
+
+	joinHandler         JoinHandler
+	orphanedRoomHandler OrphanedRoomHandler
+	lostRoomHandler     LostRoomHandler
+
+	mutex sync.Mutex
+
+	// Key: RoomID, Value: the rooms owned by this node
+	rooms map[string]*ownedRoom
+
+	// Key: ConnectionID, Value: the clients connected to this node whose rooms are owned by other nodes
+	relays map[string]*Relay
+
+	// subscription receives the messages sent by the owners of the rooms to the clients relayed by this node
+	subscription Subscription
+
+	// Key: NodeID, Value: the messages waiting to be published to the clients relayed by the node
+	outboxes map[string]*nodeOutbox
+
+	stopHeartbeat chan struct{}
+	heartbeatDone chan struct{}
+}
+
+type ownedRoom struct {
+	subscription Subscription
+
+	// renewedAt is when the lease of the room has last been renewed, it is protected by the mutex of the node
+	renewedAt time.Time
+
+	// Key: ConnectionID, Value: the clients relayed by the other nodes, it is protected by the mutex of the node
+	connections map[string]*RemoteConnection
+}
+
+func NewNode(config Config, backend Backend) *Node {
+	return &Node{
+		id:            config.NodeID,
+		backend:       backend,
+		leaseTTL:      config.LeaseTTL,
+		rooms:         make(map[string]*ownedRoom),
+		relays:        make(map[string]*Relay),
+		outboxes:      make(map[string]*nodeOutbox),
+		stopHeartbeat: make(chan struct{}),
+		heartbeatDone: make(chan struct{}),
+	}
+}
+
+// ID: returns the id of the node
+func (n *Node) ID() string {
+	return n.id
+}
+
+// HandleJoins: sets the handler of the clients relayed to the rooms of this node, it must be set before Start
+func (n *Node) HandleJoins(joinHandler JoinHandler) {
+	n.joinHandler = joinHandler
+}
+
+// HandleOrphanedRooms: sets the handler of the rooms whose owner is gone while clients of this node are relayed
+// to them, it must be set before Start
+func (n *Node) HandleOrphanedRooms(orphanedRoomHandler OrphanedRoomHandler) {
+	n.orphanedRoomHandler = orphanedRoomHandler
+}
+
+// HandleLostRooms: sets the handler of the rooms whose lease has been lost, it must be set before Start
+func (n *Node) HandleLostRooms(lostRoomHandler LostRoomHandler) {
+	n.lostRoomHandler = lostRoomHandler
+}
+
+// Start: takes the lease of the node id and starts renewing the leases, they are released by Close
+func (n *Node) Start(ctx context.Context) error {
+	acquired, err := n.backend.Acquire(ctx, nodeLeasePrefix+n.id, n.id, n.leaseTTL)
+	if err != nil {
+		return fmt.Errorf("unable to register the node in the cluster: %w", err)
+	}
+	if !acquired {
+		return fmt.Errorf("%w: %s", ErrNodeIDInUse, n.id)
+	}
+
+	// the subscription is made before relaying any client, so that the first messages of the owners are not missed
+	n.subscription, err = n.backend.Subscribe(ctx, nodeChannelPrefix+n.id)
+	if err != nil {
+		n.backend.Release(ctx, nodeLeasePrefix+n.id, n.id)
+		return fmt.Errorf("unable to subscribe to the messages of the relayed clients: %w", err)
+	}
+	go n.dispatchRelayed()
+
+	go n.heartbeat()
+	return nil
+}
+
+// Close: stops renewing the leases and releases them, so that the other nodes do not wait for them to expire
+func (n *Node) Close(ctx context.Context) {
+	close(n.stopHeartbeat)
+	<-n.heartbeatDone
+
+	n.mutex.Lock()
+	roomIDs := make([]string, 0, len(n.rooms))
+	for roomID := range n.rooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+	relays := make([]*Relay, 0, len(n.relays))
+	for _, relay := range n.relays {
+		relays = append(relays, relay)
+	}
+	n.mutex.Unlock()
+
+	// the states of the rooms are kept, so that the rooms are taken over by the other nodes
+	for _, roomID := range roomIDs {
+		if n.dropRoom(roomID, closeCodeServiceRestart, "the server is restarting, please reconnect") {
+			n.releaseLease(ctx, roomID)
+		}
+	}
+	for _, relay := range relays {
+		relay.Close()
+	}
+	n.subscription.Close()
+
+	// the close messages of the clients relayed to the rooms of this node are published before leaving
+	n.mutex.Lock()
+	outboxes := make([]*nodeOutbox, 0, len(n.outboxes))
+	for _, outbox := range n.outboxes {
+		outboxes = append(outboxes, outbox)
+	}
+	clear(n.outboxes)
+	n.mutex.Unlock()
+
+	for _, outbox := range outboxes {
+		outbox.close()
+	}
+
+	err := n.backend.Release(ctx, nodeLeasePrefix+n.id, n.id)
+	if err != nil {
+		slog.Warn("Unable to release the lease of the node", logger.KeyError, err)
+	}
+}
+
+// ClaimRoom: makes this node the owner of the room, it reports false when the room id is owned by another node
+func (n *Node) ClaimRoom(ctx context.Context, roomID string) (bool, error) {
+	acquired, err := n.backend.Acquire(ctx, roomLeasePrefix+roomID, n.id, n.leaseTTL)
+	if err != nil || !acquired {
+		return false, err
+	}
+
+	subscription, err := n.backend.Subscribe(ctx, roomChannelPrefix+roomID)
+	if err != nil {
+		n.backend.Release(ctx, roomLeasePrefix+roomID, n.id)
+		return false, err
+	}
+
+	room := &ownedRoom{
+		subscription: subscription,
+		renewedAt:    time.Now(),
+		connections:  make(map[string]*RemoteConnection),
+	}
+
+	n.mutex.Lock()
+	n.rooms[roomID] = room
+	n.mutex.Unlock()
+
+	go n.dispatch(roomID, room)
+	return true, nil
+}
+
+// ReleaseRoom: gives up the ownership of a room which has been closed, its state is deleted and the clients still
+// relayed to it are disconnected
+func (n *Node) ReleaseRoom(roomID string) {
+	if !n.dropRoom(roomID, closeCodeGoingAway, "the room has been closed") {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+
+	// the state is deleted under the lease, hence before releasing it
+	err := n.backend.DeleteState(ctx, roomStatePrefix+roomID, roomLeasePrefix+roomID, n.id)
+	if err != nil {
+		slog.Warn("Unable to delete the state of the room", logger.KeyRoomID, roomID, logger.KeyError, err)
+	}
+
+	n.releaseLease(ctx, roomID)
+}
+
+// dropRoom: stops handling the messages of the room and closes the connections relayed to it,
+// it reports false when the room is not owned by this node
+func (n *Node) dropRoom(roomID string, closeCode int, reason string) bool {
+	n.mutex.Lock()
+	room, ok := n.rooms[roomID]
+	delete(n.rooms, roomID)
+	n.mutex.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	room.subscription.Close()
+
+	n.mutex.Lock()
+	connections := make([]*RemoteConnection, 0, len(room.connections))
+	for _, connection := range room.connections {
+		connections = append(connections, connection)
+	}
+	n.mutex.Unlock()
+
+	for _, connection := range connections {
+		connection.Close(closeCode, reason)
+	}
+
+	return true
+}
+
+func (n *Node) releaseLease(ctx context.Context, roomID string) {
+	err := n.backend.Release(ctx, roomLeasePrefix+roomID, n.id)
+	if err != nil {
+		slog.Warn("Unable to release the lease of the room", logger.KeyRoomID, roomID, logger.KeyError, err)
+	}
+}
+
+// RoomOwner: returns the id of the node which owns the room, it is empty when the room does not exist
+func (n *Node) RoomOwner(ctx context.Context, roomID string) (string, error) {
+	return n.backend.Holder(ctx, roomLeasePrefix+roomID)
+}
+
+// JoinRoom: relays a client connected to this node to a room owned by another node
+func (n *Node) JoinRoom(ctx context.Context, ownerNodeID string, request JoinRequest) (*Relay, error) {
+	connectionID := uuid.New().String()
+
+	// the relay is registered before joining, so that the first events sent by the owner are not missed
+	relay := &Relay{
+		node:        n,
+		id:          connectionID,
+		roomID:      request.RoomID,
+		ownerNodeID: ownerNodeID,
+		inbox:       make(chan envelope, relayInboxSize),
+		messages:    make(chan RelayedMessage),
+		closed:      make(chan struct{}),
+	}
+
+	n.mutex.Lock()
+	n.relays[connectionID] = relay
+	n.mutex.Unlock()
+
+	err := n.publish(ctx, roomChannelPrefix+request.RoomID, envelope{
+		Type:         envelopeJoin,
+		ConnectionID: connectionID,
+		NodeID:       n.id,
+		Join:         &request,
+	})
+	if err != nil {
+		n.removeRelay(relay)
+		return nil, err
+	}
+
+	go relay.forward()
+	return relay, nil
+}
+
+// dispatch: handles the messages sent to a room of this node by the other nodes, until the room is released
+func (n *Node) dispatch(roomID string, room *ownedRoom) {
+	for message := range room.subscription.Messages() {
+		var receivedEnvelope envelope
+		err := json.Unmarshal(message, &receivedEnvelope)
+		if err != nil {
+			slog.Warn("Unable to unmarshal a cluster message", logger.KeyRoomID, roomID, logger.KeyError, err)
+			continue
+		}
+
+		switch receivedEnvelope.Type {
+		case envelopeJoin:
+			if receivedEnvelope.Join == nil || n.joinHandler == nil {
+				continue
+			}
+
+			connection := &RemoteConnection{
+				node:     n,
+				id:       receivedEnvelope.ConnectionID,
+				roomID:   roomID,
+				nodeID:   receivedEnvelope.NodeID,
+				remoteIP: receivedEnvelope.Join.RemoteIP,
+				inbox:    make(chan []byte, remoteInboxSize),
+				closed:   make(chan struct{}),
+			}
+
+			n.mutex.Lock()
+			room.connections[connection.id] = connection
+			n.mutex.Unlock()
+
+			// the checks of the join call the backend, hence they must not hold up the events of the other clients
+			go n.joinHandler(*receivedEnvelope.Join, connection)
+
+		case envelopeEvent:
+			connection := n.remoteConnection(room, receivedEnvelope.ConnectionID)
+			if connection != nil {
+				connection.deliver(receivedEnvelope.Payload)
+			}
+
+		case envelopeLeave:
+			connection := n.remoteConnection(room, receivedEnvelope.ConnectionID)
+			if connection != nil {
+				connection.shutdown()
+			}
+		}
+	}
+}
+
+// dispatchRelayed: hands the messages sent by the owners of the rooms to the clients relayed by this node,
+// until the node is closed
+func (n *Node) dispatchRelayed() {
+	for message := range n.subscription.Messages() {
+		var receivedBatch batch
+		err := json.Unmarshal(message, &receivedBatch)
+		if err != nil {
+			slog.Warn("Unable to unmarshal a cluster message", "node_id", n.id, logger.KeyError, err)
+			continue
+		}
+
+		for _, receivedEnvelope := range receivedBatch.Envelopes {
+			n.mutex.Lock()
+			relay := n.relays[receivedEnvelope.ConnectionID]
+			n.mutex.Unlock()
+
+			// the client may have left meanwhile
+			if relay != nil {
+				relay.deliver(receivedEnvelope)
+			}
+		}
+	}
+}
+
+// sendToNode: queues a message for a client relayed by the node, the messages of a node are published in batches
+func (n *Node) sendToNode(nodeID string, message envelope) error {
+	n.mutex.Lock()
+	outbox, ok := n.outboxes[nodeID]
+	if !ok {
+		outbox = newNodeOutbox(n, nodeID)
+		n.outboxes[nodeID] = outbox
+	}
+	n.mutex.Unlock()
+
+	return outbox.push(message)
+}
+
+func (n *Node) remoteConnection(room *ownedRoom, connectionID string) *RemoteConnection {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return room.connections[connectionID]
+}
+
+// heartbeat: renews the leases, and disconnects the clients whose node or whose room owner is gone
+func (n *Node) heartbeat() {
+	defer close(n.heartbeatDone)
+
+	ticker := time.NewTicker(n.leaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stopHeartbeat:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+			n.renewLeases(ctx)
+			n.checkRemoteNodes(ctx)
+			cancel()
+		}
+	}
+}
+
+func (n *Node) renewLeases(ctx context.Context) {
+	renewed, err := n.backend.Renew(ctx, nodeLeasePrefix+n.id, n.id, n.leaseTTL)
+	if err == nil && !renewed {
+		// the lease has expired, e.g. because the backend was unreachable for a while
+		renewed, err = n.backend.Acquire(ctx, nodeLeasePrefix+n.id, n.id, n.leaseTTL)
+	}
+	if err != nil || !renewed {
+		slog.Error("Unable to renew the lease of the node", "node_id", n.id, logger.KeyError, err)
+	}
+
+	n.mutex.Lock()
+	roomIDs := make([]string, 0, len(n.rooms))
+	for roomID := range n.rooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+	n.mutex.Unlock()
+
+	for _, roomID := range roomIDs {
+		renewed, err := n.backend.Renew(ctx, roomLeasePrefix+roomID, n.id, n.leaseTTL)
+		if err == nil && !renewed {
+			// the lease has expired, it is taken back unless another node has taken the room over meanwhile
+			renewed, err = n.backend.Acquire(ctx, roomLeasePrefix+roomID, n.id, n.leaseTTL)
+		}
+
+		switch {
+		case err == nil && renewed:
+			n.markRenewed(roomID)
+
+		case err == nil:
+			slog.Error("The room has been taken over by another node, dropping it", logger.KeyRoomID, roomID, "node_id", n.id)
+			n.loseRoom(roomID)
+
+		case n.leaseExpired(roomID):
+			// another node may have taken the room over while the backend was unreachable from this node
+			slog.Error("Unable to renew the lease of the room before it expired, dropping the room", logger.KeyRoomID, roomID, "node_id", n.id, logger.KeyError, err)
+			n.loseRoom(roomID)
+
+		default:
+			slog.Error("Unable to renew the lease of the room", logger.KeyRoomID, roomID, "node_id", n.id, logger.KeyError, err)
+		}
+	}
+}
+
+func (n *Node) markRenewed(roomID string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if room, ok := n.rooms[roomID]; ok {
+		room.renewedAt = time.Now()
+	}
+}
+
+// leaseExpired: reports whether the lease of the room has not been renewed for longer than its ttl
+func (n *Node) leaseExpired(roomID string) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	room, ok := n.rooms[roomID]
+	return ok && time.Since(room.renewedAt) >= n.leaseTTL
+}
+
+// loseRoom: stops serving a room whose lease has been lost, its clients are asked to reconnect to its new owner.
+// The lease and the state of the room are left to the node which has taken it over.
+func (n *Node) loseRoom(roomID string) {
+	if !n.dropRoom(roomID, closeCodeServiceRestart, "the room has moved to another server, please reconnect") {
+		return
+	}
+
+	if n.lostRoomHandler != nil {
+		n.lostRoomHandler(roomID)
+	}
+}
+
+// checkRemoteNodes: the members relayed by a node which is gone are removed from the rooms of this node,
+// and the clients relayed to a room which is not owned by its node anymore are disconnected
+func (n *Node) checkRemoteNodes(ctx context.Context) {
+	n.mutex.Lock()
+	var connections []*RemoteConnection
+	for _, room := range n.rooms {
+		for _, connection := range room.connections {
+			connections = append(connections, connection)
+		}
+	}
+	relays := make([]*Relay, 0, len(n.relays))
+	for _, relay := range n.relays {
+		relays = append(relays, relay)
+	}
+	n.mutex.Unlock()
+
+	// Key: NodeID, Value: whether the node is alive, so that every node is only checked once
+	aliveNodes := make(map[string]bool)
+	for _, connection := range connections {
+		alive, checked := aliveNodes[connection.nodeID]
+		if !checked {
+			holder, err := n.backend.Holder(ctx, nodeLeasePrefix+connection.nodeID)
+			if err != nil {
+				// the backend is unreachable, the clients are kept until it can tell
+				return
+			}
+			alive = holder == connection.nodeID
+			aliveNodes[connection.nodeID] = alive
+		}
+
+		if !alive {
+			slog.Warn("The node of a relayed member is gone, removing the member", logger.KeyRoomID, connection.roomID, "node_id", connection.nodeID)
+			connection.shutdown()
+		}
+	}
+
+	n.closeIdleOutboxes()
+
+	// Key: RoomID, Value: the current owner of the room, so that every room is only checked and taken over once
+	owners := make(map[string]string)
+	for _, relay := range relays {
+		owner, checked := owners[relay.roomID]
+		if !checked {
+			var err error
+			owner, err = n.RoomOwner(ctx, relay.roomID)
+			if err != nil {
+				return
+			}
+
+			// the room is taken over before its clients are disconnected, so that they resume their seats on this node
+			if owner == "" && n.orphanedRoomHandler != nil {
+				slog.Warn("The owner of a relayed room is gone, taking the room over", logger.KeyRoomID, relay.roomID, "node_id", relay.ownerNodeID)
+				n.orphanedRoomHandler(relay.roomID)
+			}
+			owners[relay.roomID] = owner
+		}
+
+		if owner != relay.ownerNodeID {
+			slog.Warn("The owner of a relayed room has changed, disconnecting the client", logger.KeyRoomID, relay.roomID, "node_id", relay.ownerNodeID)
+			relay.Close()
+		}
+	}
+}
+
+// closeIdleOutboxes: closes the outboxes of the nodes which do not relay any client to the rooms of this node anymore
+func (n *Node) closeIdleOutboxes() {
+	n.mutex.Lock()
+	relayingNodes := make(map[string]struct{})
+	for _, room := range n.rooms {
+		for _, connection := range room.connections {
+			relayingNodes[connection.nodeID] = struct{}{}
+		}
+	}
+
+	var idleOutboxes []*nodeOutbox
+	for nodeID, outbox := range n.outboxes {
+		if _, ok := relayingNodes[nodeID]; !ok {
+			idleOutboxes = append(idleOutboxes, outbox)
+			delete(n.outboxes, nodeID)
+		}
+	}
+	n.mutex.Unlock()
+
+	for _, outbox := range idleOutboxes {
+		outbox.close()
+	}
+}
+
+func (n *Node) removeRelay(relay *Relay) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	delete(n.relays, relay.id)
+}
+
+func (n *Node) removeRemoteConnection(connection *RemoteConnection) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if room, ok := n.rooms[connection.roomID]; ok {
+		delete(room.connections, connection.id)
+	}
+}
+
+func (n *Node) publish(ctx context.Context, channel string, message envelope) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return n.backend.Publish(ctx, channel, payload)
+}
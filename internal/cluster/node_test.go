@@ -0,0 +1,218 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestNodes: an owner and a node relaying clients to it, sharing the backend
+func newTestNodes(t *testing.T, backend Backend, joinHandler JoinHandler) (owner *Node, relayNode *Node) {
+	t.Helper()
+
+	owner = NewNode(Config{NodeID: "owner", LeaseTTL: time.Minute}, backend)
+	relayNode = NewNode(Config{NodeID: "relay", LeaseTTL: time.Minute}, backend)
+	owner.HandleJoins(joinHandler)
+
+	for _, node := range []*Node{owner, relayNode} {
+		err := node.Start(context.Background())
+		if err != nil {
+			t.Fatalf("unable to start the node: %v", err)
+		}
+		t.Cleanup(func() {
+			node.Close(context.Background())
+		})
+	}
+
+	claimed, err := owner.ClaimRoom(context.Background(), "ROOM01")
+	if err != nil || !claimed {
+		t.Fatalf("unable to claim the room: %v", err)
+	}
+
+	return owner, relayNode
+}
+
+func TestSlowJoinsDoNotHoldUpTheOtherClients(t *testing.T) {
+	joins := make(chan *RemoteConnection, 2)
+	release := make(chan struct{})
+
+	_, relayNode := newTestNodes(t, NewMemoryBackend(), func(request JoinRequest, connection *RemoteConnection) {
+		joins <- connection
+		if request.Name == "slow" {
+			<-release
+		}
+	})
+	defer close(release)
+
+	for _, name := range []string{"slow", "fast"} {
+		_, err := relayNode.JoinRoom(context.Background(), "owner", JoinRequest{RoomID: "ROOM01", Name: name, Authorized: true})
+		if err != nil {
+			t.Fatalf("unable to join the room: %v", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-joins:
+		case <-time.After(time.Second):
+			t.Fatal("the second join has waited for the first one")
+		}
+	}
+}
+
+func TestARelayedClientWhoseEventsAreNotHandledIsDisconnected(t *testing.T) {
+	_, relayNode := newTestNodes(t, NewMemoryBackend(), func(request JoinRequest, connection *RemoteConnection) {
+		// the events of the client are never read
+	})
+
+	relay, err := relayNode.JoinRoom(context.Background(), "owner", JoinRequest{RoomID: "ROOM01", Name: "bob", Authorized: true})
+	if err != nil {
+		t.Fatalf("unable to join the room: %v", err)
+	}
+
+	for i := 0; i <= remoteInboxSize; i++ {
+		err := relay.Send([]byte(`{"type":"MEMBER_VOTED"}`))
+		if err != nil {
+			t.Fatalf("unable to send an event: %v", err)
+		}
+	}
+
+	select {
+	case message := <-relay.Messages():
+		if !message.Close || message.CloseCode != closeCodePolicyViolation {
+			t.Fatalf("got %+v, want the connection to be closed with %d", message, closeCodePolicyViolation)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the client has not been disconnected")
+	}
+}
+
+// gatedBackend: a backend whose publications to the channel are held until the gate is opened
+type gatedBackend struct {
+	Backend
+	channel string
+	gate    chan struct{}
+
+	mutex        sync.Mutex
+	publications int
+}
+
+func (b *gatedBackend) Publish(ctx context.Context, channel string, message []byte) error {
+	if channel == b.channel {
+		<-b.gate
+		b.mutex.Lock()
+		b.publications++
+		b.mutex.Unlock()
+	}
+	return b.Backend.Publish(ctx, channel, message)
+}
+
+func TestTheMessagesOfTheClientsOfANodeArePublishedTogether(t *testing.T) {
+	backend := &gatedBackend{Backend: NewMemoryBackend(), channel: nodeChannelPrefix + "relay", gate: make(chan struct{})}
+	connections := make(chan *RemoteConnection, 2)
+	_, relayNode := newTestNodes(t, backend, func(request JoinRequest, connection *RemoteConnection) {
+		connections <- connection
+	})
+
+	var relays []*Relay
+	var remoteConnections []*RemoteConnection
+	for _, name := range []string{"alice", "bob"} {
+		relay, err := relayNode.JoinRoom(context.Background(), "owner", JoinRequest{RoomID: "ROOM01", Name: name, Authorized: true})
+		if err != nil {
+			t.Fatalf("unable to join the room: %v", err)
+		}
+		relays = append(relays, relay)
+		remoteConnections = append(remoteConnections, <-connections)
+	}
+
+	// the messages written while the first one is being published wait for the next batch
+	const messageCount = 50
+	for i := 0; i < messageCount; i++ {
+		for _, connection := range remoteConnections {
+			err := connection.WriteMessage([]byte(strconv.Itoa(i)))
+			if err != nil {
+				t.Fatalf("unable to write a message: %v", err)
+			}
+		}
+	}
+	close(backend.gate)
+
+	for _, relay := range relays {
+		for i := 0; i < messageCount; i++ {
+			select {
+			case message := <-relay.Messages():
+				if string(message.Payload) != strconv.Itoa(i) {
+					t.Fatalf("got message %q, want %d", message.Payload, i)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("got %d messages, want %d", i, messageCount)
+			}
+		}
+	}
+
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+	if backend.publications > 2 {
+		t.Fatalf("got %d publications, want the messages to be published in at most 2 batches", backend.publications)
+	}
+}
+
+// unreachableBackend: a backend whose leases cannot be renewed
+type unreachableBackend struct {
+	Backend
+}
+
+func (b unreachableBackend) Renew(ctx context.Context, key string, holder string, ttl time.Duration) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestARoomWhoseLeaseIsLostIsDropped(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryBackend()
+
+	owner := NewNode(Config{NodeID: "owner", LeaseTTL: time.Minute}, backend)
+	var lostRoomIDs []string
+	owner.HandleLostRooms(func(roomID string) {
+		lostRoomIDs = append(lostRoomIDs, roomID)
+	})
+
+	for _, roomID := range []string{"ROOM01", "ROOM02"} {
+		claimed, err := owner.ClaimRoom(ctx, roomID)
+		if err != nil || !claimed {
+			t.Fatalf("unable to claim the room: %v", err)
+		}
+	}
+
+	// another node has taken ROOM01 over while the lease of the owner had expired
+	backend.leases[roomLeasePrefix+"ROOM01"] = memoryLease{holder: "other", expiresAt: time.Now().Add(time.Minute)}
+	owner.renewLeases(ctx)
+
+	if len(lostRoomIDs) != 1 || lostRoomIDs[0] != "ROOM01" {
+		t.Fatalf("got %v lost rooms, want ROOM01", lostRoomIDs)
+	}
+	if holder, _ := backend.Holder(ctx, roomLeasePrefix+"ROOM01"); holder != "other" {
+		t.Fatalf("got %q, want the lease to be left to the other node", holder)
+	}
+
+	// the lease of ROOM02 cannot be renewed, the room is kept until the lease would have expired
+	owner.backend = unreachableBackend{Backend: backend}
+	owner.renewLeases(ctx)
+	if len(lostRoomIDs) != 1 {
+		t.Fatalf("got %v lost rooms, want ROOM02 to be kept until its lease expires", lostRoomIDs)
+	}
+
+	owner.mutex.Lock()
+	owner.rooms["ROOM02"].renewedAt = time.Now().Add(-time.Minute)
+	owner.mutex.Unlock()
+	owner.renewLeases(ctx)
+
+	if len(lostRoomIDs) != 2 || lostRoomIDs[1] != "ROOM02" {
+		t.Fatalf("got %v lost rooms, want ROOM02 to be dropped once its lease has expired", lostRoomIDs)
+	}
+	if len(owner.rooms) != 0 {
+		t.Fatalf("got %d rooms, want none", len(owner.rooms))
+	}
+}
@@ -0,0 +1,125 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+)
+
+const (
+	// maxOutboxSize is the number of messages which can wait to be published to a node, the writes fail beyond it
+	maxOutboxSize = 4096
+
+	// maxBatchSize is the number of messages published at once, so that a batch stays small enough for the backend
+	maxBatchSize = 256
+)
+
+var ErrOutboxFull = errors.New("too many messages are waiting to be sent to the node of the client")
+
+// batch: the messages published at once to a node, for the clients it relays
+type batch struct {
+	Envelopes []envelope `json:"envelopes"`
+}
+
+/*
+nodeOutbox: the messages sent by the rooms of this node to the clients relayed by another node.
+
+The messages written while a batch is being published are published together with the next batch. A broadcast
+to the members of a room relayed by the same node is written by every member at the same time, hence it is
+published once rather than once per member. The messages of a client are published in the order they are written.
+*/
+type nodeOutbox struct {
+	node   *Node
+	nodeID string
+
+	mutex     sync.Mutex
+	envelopes []envelope
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func newNodeOutbox(node *Node, nodeID string) *nodeOutbox {
+	outbox := &nodeOutbox{
+		node:   node,
+		nodeID: nodeID,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go outbox.run()
+	return outbox
+}
+
+// push: queues the message, it never blocks
+func (o *nodeOutbox) push(message envelope) error {
+	o.mutex.Lock()
+	if len(o.envelopes) >= maxOutboxSize {
+		o.mutex.Unlock()
+		return ErrOutboxFull
+	}
+	o.envelopes = append(o.envelopes, message)
+	o.mutex.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (o *nodeOutbox) run() {
+	defer close(o.done)
+
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-o.wake:
+			o.flush()
+		}
+	}
+}
+
+// flush: publishes the queued messages, in batches of at most maxBatchSize messages
+func (o *nodeOutbox) flush() {
+	for {
+		o.mutex.Lock()
+		batchSize := min(len(o.envelopes), maxBatchSize)
+		envelopes := o.envelopes[:batchSize:batchSize]
+		o.envelopes = o.envelopes[batchSize:]
+		o.mutex.Unlock()
+
+		if batchSize == 0 {
+			return
+		}
+
+		payload, err := json.Marshal(batch{Envelopes: envelopes})
+		if err != nil {
+			slog.Error("Unable to marshal the messages of the relayed clients", "node_id", o.nodeID, logger.KeyError, err)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+		err = o.node.backend.Publish(ctx, nodeChannelPrefix+o.nodeID, payload)
+		cancel()
+		if err != nil {
+			slog.Warn("Unable to publish the messages of the relayed clients", "node_id", o.nodeID, "messages", batchSize, logger.KeyError, err)
+		}
+	}
+}
+
+// close: publishes the queued messages and stops, e.g. so that the relayed clients are told why a room is closed
+func (o *nodeOutbox) close() {
+	o.once.Do(func() {
+		close(o.stop)
+		<-o.done
+		o.flush()
+	})
+}
@@ -0,0 +1,194 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix namespaces the keys and the channels, so that the Redis server can be shared with other applications
+const redisKeyPrefix = "estimatex:"
+
+var (
+	// renewLeaseScript extends the lease only when it is still held by the holder
+	renewLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+	// releaseLeaseScript deletes the lease only when it is still held by the holder
+	releaseLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+	// storeStateScript writes the state only when the lease of KEYS[2] is held by the holder
+	storeStateScript = redis.NewScript(`
+if redis.call("GET", KEYS[2]) == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+	// deleteStateScript deletes the state only when the lease of KEYS[2] is held by the holder
+	deleteStateScript = redis.NewScript(`
+if redis.call("GET", KEYS[2]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+)
+
+// stateKeysScanCount is the number of keys looked at by every SCAN call while listing the states
+const stateKeysScanCount = 100
+
+// RedisBackend: keeps the leases in Redis keys with an expiration, and relays the messages with the Redis pub/sub
+type RedisBackend struct {
+	client *redis.Client
+}
+
+type redisSubscription struct {
+	pubSub   *redis.PubSub
+	messages chan []byte
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func NewRedisBackend(redisURL string) (*RedisBackend, error) {
+	if redisURL == "" {
+		return nil, errors.New("the redis cluster backend requires a Redis URL")
+	}
+
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+
+	return &RedisBackend{client: redis.NewClient(options)}, nil
+}
+
+func (b *RedisBackend) Acquire(ctx context.Context, key string, holder string, ttl time.Duration) (bool, error) {
+	acquired, err := b.client.SetNX(ctx, redisKeyPrefix+key, holder, ttl).Result()
+	if err != nil || acquired {
+		return acquired, err
+	}
+
+	// the holder may already have the lease, in which case it is renewed
+	return b.Renew(ctx, key, holder, ttl)
+}
+
+func (b *RedisBackend) Renew(ctx context.Context, key string, holder string, ttl time.Duration) (bool, error) {
+	renewed, err := renewLeaseScript.Run(ctx, b.client, []string{redisKeyPrefix + key}, holder, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return renewed == 1, nil
+}
+
+func (b *RedisBackend) Release(ctx context.Context, key string, holder string) error {
+	return releaseLeaseScript.Run(ctx, b.client, []string{redisKeyPrefix + key}, holder).Err()
+}
+
+func (b *RedisBackend) Holder(ctx context.Context, key string) (string, error) {
+	holder, err := b.client.Get(ctx, redisKeyPrefix+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return holder, err
+}
+
+func (b *RedisBackend) StoreState(ctx context.Context, key string, state []byte, leaseKey string, holder string) (bool, error) {
+	stored, err := storeStateScript.Run(ctx, b.client, []string{redisKeyPrefix + key, redisKeyPrefix + leaseKey}, holder, state).Int()
+	if err != nil {
+		return false, err
+	}
+	return stored == 1, nil
+}
+
+func (b *RedisBackend) DeleteState(ctx context.Context, key string, leaseKey string, holder string) error {
+	return deleteStateScript.Run(ctx, b.client, []string{redisKeyPrefix + key, redisKeyPrefix + leaseKey}, holder).Err()
+}
+
+func (b *RedisBackend) LoadState(ctx context.Context, key string) ([]byte, error) {
+	state, err := b.client.Get(ctx, redisKeyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return state, err
+}
+
+func (b *RedisBackend) StateKeys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+
+	iterator := b.client.Scan(ctx, 0, redisKeyPrefix+prefix+"*", stateKeysScanCount).Iterator()
+	for iterator.Next(ctx) {
+		keys = append(keys, strings.TrimPrefix(iterator.Val(), redisKeyPrefix))
+	}
+	return keys, iterator.Err()
+}
+
+func (b *RedisBackend) Publish(ctx context.Context, channel string, message []byte) error {
+	return b.client.Publish(ctx, redisKeyPrefix+channel, message).Err()
+}
+
+func (b *RedisBackend) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	pubSub := b.client.Subscribe(ctx, redisKeyPrefix+channel)
+
+	// the first reply confirms the subscription, the messages published before it would be missed
+	_, err := pubSub.Receive(ctx)
+	if err != nil {
+		pubSub.Close()
+		return nil, err
+	}
+
+	subscription := &redisSubscription{
+		pubSub:   pubSub,
+		messages: make(chan []byte, memorySubscriptionBufferSize),
+		closed:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(subscription.messages)
+
+		for message := range pubSub.Channel() {
+			select {
+			case subscription.messages <- []byte(message.Payload):
+			case <-subscription.closed:
+				return
+			}
+		}
+	}()
+
+	return subscription, nil
+}
+
+func (b *RedisBackend) Ping(ctx context.Context) error {
+	return b.client.Ping(ctx).Err()
+}
+
+func (b *RedisBackend) Close() error {
+	return b.client.Close()
+}
+
+func (s *redisSubscription) Messages() <-chan []byte {
+	return s.messages
+}
+
+func (s *redisSubscription) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.closed)
+		err = s.pubSub.Close()
+	})
+	return err
+}
@@ -0,0 +1,207 @@
+package cluster
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+)
+
+// the websocket close codes used by the relays, they are duplicated here so that the package does not depend on the websockets
+const (
+	closeCodeGoingAway       = 1001
+	closeCodePolicyViolation = 1008
+	closeCodeServiceRestart  = 1012
+)
+
+// RemoteConnection: the connection of a client relayed by another node, as seen by the owner of the room.
+// It is read and written like the websocket connection of a client connected to the owner.
+type RemoteConnection struct {
+	node   *Node
+	id     string
+	roomID string
+
+	// nodeID is the node to which the client is connected
+	nodeID string
+
+	// remoteIP is the source IP address of the client, as seen by its node
+	remoteIP string
+
+	inbox chan []byte
+
+	// closed is closed once the client has left or has been disconnected
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// ReadMessage: returns the next event sent by the client, or ErrConnectionClosed once the client is gone
+func (c *RemoteConnection) ReadMessage() ([]byte, error) {
+	select {
+	case payload := <-c.inbox:
+		return payload, nil
+	case <-c.closed:
+		return nil, ErrConnectionClosed
+	}
+}
+
+// WriteMessage: sends a message to the client through its node, together with the other messages sent to the
+// clients of the node. It fails when the node is too far behind, as for a websocket connection whose writes time out.
+func (c *RemoteConnection) WriteMessage(message []byte) error {
+	select {
+	case <-c.closed:
+		return ErrConnectionClosed
+	default:
+	}
+
+	return c.node.sendToNode(c.nodeID, envelope{
+		Type:         envelopeMessage,
+		ConnectionID: c.id,
+		Payload:      message,
+	})
+}
+
+// Close: asks the node of the client to close the websocket connection with the given close code and reason
+func (c *RemoteConnection) Close(closeCode int, reason string) error {
+	if !c.shutdown() {
+		return nil
+	}
+
+	return c.node.sendToNode(c.nodeID, envelope{
+		Type:         envelopeClose,
+		ConnectionID: c.id,
+		CloseCode:    closeCode,
+		CloseReason:  reason,
+	})
+}
+
+func (c *RemoteConnection) RemoteAddr() string {
+	return c.remoteIP
+}
+
+// deliver: queues an event sent by the client, it never blocks so that a client whose events are not handled
+// does not hold up the other clients of the room. The client is disconnected when its inbox is full.
+func (c *RemoteConnection) deliver(payload []byte) {
+	select {
+	case c.inbox <- payload:
+	case <-c.closed:
+	default:
+		slog.Warn("The inbox of a relayed client is full, disconnecting the client", logger.KeyRoomID, c.roomID, "node_id", c.nodeID)
+		c.Close(closeCodePolicyViolation, "too many events")
+	}
+}
+
+// shutdown: marks the connection as closed, it reports false when it was already closed
+func (c *RemoteConnection) shutdown() bool {
+	shutdown := false
+	c.closeOnce.Do(func() {
+		shutdown = true
+		close(c.closed)
+		c.node.removeRemoteConnection(c)
+	})
+	return shutdown
+}
+
+// RelayedMessage: a message sent by the owner of the room to a relayed client. When Close is set,
+// the websocket connection of the client must be closed with the close code and the reason.
+type RelayedMessage struct {
+	Payload []byte
+
+	Close       bool
+	CloseCode   int
+	CloseReason string
+}
+
+// Relay: the connection of a client to a room owned by another node, as seen by the node of the client
+type Relay struct {
+	node        *Node
+	id          string
+	roomID      string
+	ownerNodeID string
+
+	// inbox holds the messages of the owner until they are written to the client
+	inbox    chan envelope
+	messages chan RelayedMessage
+
+	// closed stops the forwarding of the messages once the relay is closed
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Messages: the messages sent by the owner of the room. The channel is closed once the relay is closed,
+// and a channel closed without a close message means that the room is not available anymore.
+func (r *Relay) Messages() <-chan RelayedMessage {
+	return r.messages
+}
+
+// Send: sends an event of the client to the owner of the room
+func (r *Relay) Send(payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+
+	return r.node.publish(ctx, roomChannelPrefix+r.roomID, envelope{
+		Type:         envelopeEvent,
+		ConnectionID: r.id,
+		Payload:      payload,
+	})
+}
+
+// Close: tells the owner of the room that the client has left, and stops the relay
+func (r *Relay) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		r.node.removeRelay(r)
+
+		ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+		defer cancel()
+
+		err := r.node.publish(ctx, roomChannelPrefix+r.roomID, envelope{
+			Type:         envelopeLeave,
+			ConnectionID: r.id,
+		})
+		if err != nil {
+			slog.Warn("Unable to tell the owner of the room that a relayed client has left", logger.KeyRoomID, r.roomID, logger.KeyError, err)
+		}
+	})
+}
+
+// deliver: queues a message of the owner, it never blocks so that a client which does not read its messages
+// does not hold up the other clients of the node. The client is disconnected when its inbox is full.
+func (r *Relay) deliver(message envelope) {
+	select {
+	case r.inbox <- message:
+	case <-r.closed:
+	default:
+		slog.Warn("The inbox of a relayed client is full, disconnecting the client", logger.KeyRoomID, r.roomID, "owner_node_id", r.ownerNodeID)
+		go r.Close()
+	}
+}
+
+// forward: turns the messages of the owner into RelayedMessages, until the relay is closed
+func (r *Relay) forward() {
+	defer close(r.messages)
+
+	for {
+		var receivedEnvelope envelope
+		select {
+		case receivedEnvelope = <-r.inbox:
+		case <-r.closed:
+			return
+		}
+
+		relayedMessage := RelayedMessage{Payload: receivedEnvelope.Payload}
+		if receivedEnvelope.Type == envelopeClose {
+			relayedMessage = RelayedMessage{
+				Close:       true,
+				CloseCode:   receivedEnvelope.CloseCode,
+				CloseReason: receivedEnvelope.CloseReason,
+			}
+		}
+
+		select {
+		case r.messages <- relayedMessage:
+		case <-r.closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+	"github.com/skamranahmed/estimatex-server/internal/snapshot"
+)
+
+// roomStatePrefix is the prefix of the keys of the room states
+const roomStatePrefix = "state:"
+
+var ErrRoomNotOwned = errors.New("the room is not owned by this node")
+
+/*
+SnapshotStore: keeps the snapshots of the rooms in the backend, so that the rooms of a node which is gone are
+taken over by the other nodes.
+
+A snapshot is only written and deleted by the owner of the room, under the lease of the room. A node which has
+lost the lease of a room, e.g. because it could not reach the backend for a while, cannot overwrite the state
+written by the node which has taken the room over.
+*/
+type SnapshotStore struct {
+	node *Node
+}
+
+func NewSnapshotStore(node *Node) *SnapshotStore {
+	return &SnapshotStore{node: node}
+}
+
+// Save: it returns ErrRoomNotOwned when the lease of the room is not held by the node
+func (s *SnapshotStore) Save(room snapshot.Room) error {
+	payload, err := json.Marshal(room)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+
+	stored, err := s.node.backend.StoreState(ctx, roomStatePrefix+room.ID, payload, roomLeasePrefix+room.ID, s.node.id)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return ErrRoomNotOwned
+	}
+	return nil
+}
+
+// Delete: it does nothing when the lease of the room is not held by the node, the room is then owned by another node
+func (s *SnapshotStore) Delete(roomID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+
+	return s.node.backend.DeleteState(ctx, roomStatePrefix+roomID, roomLeasePrefix+roomID, s.node.id)
+}
+
+func (s *SnapshotStore) Load(roomID string) (*snapshot.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+
+	payload, err := s.node.backend.LoadState(ctx, roomStatePrefix+roomID)
+	if err != nil || payload == nil {
+		return nil, err
+	}
+
+	var room snapshot.Room
+	err = json.Unmarshal(payload, &room)
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// LoadAll: returns the snapshots of the rooms of every node, the rooms which are owned by a node are not taken over
+// since they cannot be claimed
+func (s *SnapshotStore) LoadAll() ([]snapshot.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+
+	keys, err := s.node.backend.StateKeys(ctx, roomStatePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var rooms []snapshot.Room
+	for _, key := range keys {
+		room, err := s.Load(strings.TrimPrefix(key, roomStatePrefix))
+		if err != nil {
+			slog.Warn("Unable to read a room snapshot", "key", key, logger.KeyError, err)
+			continue
+		}
+		if room != nil {
+			rooms = append(rooms, *room)
+		}
+	}
+
+	return rooms, nil
+}
+
+func (s *SnapshotStore) Ping(ctx context.Context) error {
+	return s.node.backend.Ping(ctx)
+}
@@ -0,0 +1,69 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/skamranahmed/estimatex-server/internal/snapshot"
+)
+
+func TestSnapshotStoreOnlyWritesTheRoomsOfItsNode(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryBackend()
+
+	owner := NewNode(Config{NodeID: "owner", LeaseTTL: time.Minute}, backend)
+	other := NewNode(Config{NodeID: "other", LeaseTTL: time.Minute}, backend)
+	ownerStore := NewSnapshotStore(owner)
+	otherStore := NewSnapshotStore(other)
+
+	claimed, err := owner.ClaimRoom(ctx, "ROOM01")
+	if err != nil || !claimed {
+		t.Fatalf("unable to claim the room: %v", err)
+	}
+
+	err = ownerStore.Save(snapshot.Room{Version: snapshot.Version, ID: "ROOM01", MaxCapacity: 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a node which does not own the room can neither overwrite nor delete its state
+	err = otherStore.Save(snapshot.Room{Version: snapshot.Version, ID: "ROOM01", MaxCapacity: 1})
+	if !errors.Is(err, ErrRoomNotOwned) {
+		t.Fatalf("got %v, want ErrRoomNotOwned", err)
+	}
+	err = otherStore.Delete("ROOM01")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rooms, err := otherStore.LoadAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rooms) != 1 || rooms[0].MaxCapacity != 5 {
+		t.Fatalf("got %+v, want the room written by its owner", rooms)
+	}
+
+	// the state outlives the owner, so that the room can be taken over
+	owner.dropRoom("ROOM01", closeCodeServiceRestart, "")
+	owner.releaseLease(ctx, "ROOM01")
+
+	room, err := otherStore.Load("ROOM01")
+	if err != nil || room == nil {
+		t.Fatalf("got %v, %v, want the state of the room", room, err)
+	}
+
+	claimed, err = other.ClaimRoom(ctx, "ROOM01")
+	if err != nil || !claimed {
+		t.Fatalf("unable to take the room over: %v", err)
+	}
+
+	// a closed room is deleted by its owner
+	other.ReleaseRoom("ROOM01")
+	room, err = otherStore.Load("ROOM01")
+	if err != nil || room != nil {
+		t.Fatalf("got %v, %v, want no state", room, err)
+	}
+}
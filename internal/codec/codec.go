@@ -0,0 +1,78 @@
+// Package codec converts the events between JSON, in which they are handled, logged and relayed by the server,
+// and the wire encoding picked by the client when it connects
+package codec
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/skamranahmed/estimatex-server/internal/event"
+)
+
+// eventEnvelopeSize is the room left for the fields around the data when an event is encoded
+const eventEnvelopeSize = 192
+
+// Codec: a wire encoding of the events, the events are converted from and to their JSON encoding so that
+// every encoding carries the same fields as the JSON one
+type Codec interface {
+	// Name: the name with which a client picks the encoding, with the `encoding` query parameter
+	Name() string
+
+	// FrameType: the type of the websocket frames in which the events are written
+	FrameType() int
+
+	// Encode: converts a message from JSON to the encoding, the plain text messages are encoded as strings
+	Encode(jsonMessage []byte) ([]byte, error)
+
+	// EncodeEvent: writes the event in the encoding with the given data, the data of the envelope is ignored.
+	// The data is converted once and the result is kept along with it, so that the data shared by the events
+	// of several members is not converted again for each of them.
+	EncodeEvent(envelope event.Event, data *event.SharedData) ([]byte, error)
+
+	// Decode: converts a message from the encoding to JSON
+	Decode(message []byte) ([]byte, error)
+}
+
+const (
+	NameJSON        = "json"
+	NameMessagePack = "msgpack"
+)
+
+var (
+	JSON        Codec = jsonCodec{}
+	MessagePack Codec = messagePackCodec{}
+)
+
+// ByName: returns the codec of the given encoding, the clients which do not pick an encoding get JSON
+func ByName(name string) (Codec, bool) {
+	switch name {
+	case "", NameJSON:
+		return JSON, true
+	case NameMessagePack:
+		return MessagePack, true
+	}
+	return nil, false
+}
+
+// jsonCodec: the events are written as they are, in text frames
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return NameJSON
+}
+
+func (jsonCodec) FrameType() int {
+	return websocket.TextMessage
+}
+
+func (jsonCodec) Encode(jsonMessage []byte) ([]byte, error) {
+	return jsonMessage, nil
+}
+
+// EncodeEvent: the envelope is written around the data as it is
+func (jsonCodec) EncodeEvent(envelope event.Event, data *event.SharedData) ([]byte, error) {
+	envelope.Data = data.JSON
+	return envelope.AppendJSON(make([]byte, 0, len(data.JSON)+eventEnvelopeSize)), nil
+}
+
+func (jsonCodec) Decode(message []byte) ([]byte, error) {
+	return message, nil
+}
@@ -0,0 +1,520 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/skamranahmed/estimatex-server/internal/event"
+)
+
+// maxMessagePackDepth is the maximum nesting of the arrays and the maps of a message sent by a client
+const maxMessagePackDepth = 32
+
+var (
+	ErrInvalidMessagePack = errors.New("invalid msgpack message")
+	errTrailingBytes      = errors.New("unexpected bytes after the msgpack message")
+	errTrailingJSON       = errors.New("unexpected data after the JSON value")
+)
+
+// messagePackCodec: the events are written in MessagePack, in binary frames. The JSON objects are written as maps
+// whose keys keep the order of the JSON fields, and the JSON numbers as integers when they have no fraction.
+type messagePackCodec struct{}
+
+func (messagePackCodec) Name() string {
+	return NameMessagePack
+}
+
+func (messagePackCodec) FrameType() int {
+	return websocket.BinaryMessage
+}
+
+func (messagePackCodec) Encode(jsonMessage []byte) ([]byte, error) {
+	encodedMessage, err := encodeJSON(jsonMessage)
+	if err == nil {
+		return encodedMessage, nil
+	}
+
+	// the messages which are not JSON, i.e. the plain text messages of the first version of the protocol, are strings
+	var buffer bytes.Buffer
+	writeString(&buffer, string(jsonMessage))
+	return buffer.Bytes(), nil
+}
+
+// EncodeEvent: the envelope is written as a map with the fields of its JSON encoding, in the same order, around the
+// data which has been converted once
+func (messagePackCodec) EncodeEvent(envelope event.Event, data *event.SharedData) ([]byte, error) {
+	encodedData, err := data.Encoding(NameMessagePack, encodeJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	// the type and the data are always written, the other fields are omitted when they are empty
+	count := 2
+	for _, isSet := range []bool{envelope.ID != "", envelope.Seq != 0, envelope.PrevSeq != 0, envelope.SentAt != 0, envelope.TraceID != ""} {
+		if isSet {
+			count++
+		}
+	}
+
+	var buffer bytes.Buffer
+	buffer.Grow(len(encodedData) + eventEnvelopeSize)
+	writeHeader(&buffer, count, 0x80, 0xde, 0xdf)
+
+	writeString(&buffer, "type")
+	writeString(&buffer, envelope.Type)
+	writeString(&buffer, "data")
+	buffer.Write(encodedData)
+
+	if envelope.ID != "" {
+		writeString(&buffer, "id")
+		writeString(&buffer, envelope.ID)
+	}
+	if envelope.Seq != 0 {
+		writeString(&buffer, "seq")
+		writeUnsigned(&buffer, envelope.Seq)
+	}
+	if envelope.PrevSeq != 0 {
+		writeString(&buffer, "prev_seq")
+		writeUnsigned(&buffer, envelope.PrevSeq)
+	}
+	if envelope.SentAt != 0 {
+		writeString(&buffer, "sent_at")
+		writeInteger(&buffer, envelope.SentAt)
+	}
+	if envelope.TraceID != "" {
+		writeString(&buffer, "trace_id")
+		writeString(&buffer, envelope.TraceID)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (messagePackCodec) Decode(message []byte) ([]byte, error) {
+	reader := &messagePackReader{message: message}
+
+	var buffer bytes.Buffer
+	err := reader.decodeValue(&buffer, 0)
+	if err != nil {
+		return nil, err
+	}
+	if reader.offset != len(message) {
+		return nil, errTrailingBytes
+	}
+	return buffer.Bytes(), nil
+}
+
+// encodeJSON: converts a single JSON value to MessagePack
+func encodeJSON(jsonMessage []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(jsonMessage))
+	decoder.UseNumber()
+
+	var buffer bytes.Buffer
+	err := encodeJSONValue(&buffer, decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = decoder.Token()
+	if err != io.EOF {
+		return nil, errTrailingJSON
+	}
+	return buffer.Bytes(), nil
+}
+
+// encodeJSONValue: reads the next JSON value and writes it in MessagePack
+func encodeJSONValue(buffer *bytes.Buffer, decoder *json.Decoder) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	switch value := token.(type) {
+	case json.Delim:
+		// the number of elements is written before the elements, hence they are written to their own buffer first
+		var elements bytes.Buffer
+		count := 0
+		for decoder.More() {
+			if value == '{' {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				writeString(&elements, keyToken.(string))
+			}
+			err := encodeJSONValue(&elements, decoder)
+			if err != nil {
+				return err
+			}
+			count++
+		}
+
+		// the closing delimiter
+		_, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		if value == '{' {
+			writeHeader(buffer, count, 0x80, 0xde, 0xdf)
+		} else {
+			writeHeader(buffer, count, 0x90, 0xdc, 0xdd)
+		}
+		buffer.Write(elements.Bytes())
+
+	case string:
+		writeString(buffer, value)
+
+	case json.Number:
+		writeNumber(buffer, value)
+
+	case bool:
+		if value {
+			buffer.WriteByte(0xc3)
+		} else {
+			buffer.WriteByte(0xc2)
+		}
+
+	case nil:
+		buffer.WriteByte(0xc0)
+	}
+
+	return nil
+}
+
+func writeString(buffer *bytes.Buffer, value string) {
+	length := len(value)
+	switch {
+	case length < 32:
+		buffer.WriteByte(0xa0 | byte(length))
+	case length <= math.MaxUint8:
+		buffer.Write([]byte{0xd9, byte(length)})
+	case length <= math.MaxUint16:
+		buffer.WriteByte(0xda)
+		buffer.Write(binary.BigEndian.AppendUint16(nil, uint16(length)))
+	default:
+		buffer.WriteByte(0xdb)
+		buffer.Write(binary.BigEndian.AppendUint32(nil, uint32(length)))
+	}
+	buffer.WriteString(value)
+}
+
+// writeHeader: writes the number of elements of a map or an array, in its fixed, 16 bits or 32 bits format
+func writeHeader(buffer *bytes.Buffer, count int, fixed byte, format16 byte, format32 byte) {
+	switch {
+	case count < 16:
+		buffer.WriteByte(fixed | byte(count))
+	case count <= math.MaxUint16:
+		buffer.WriteByte(format16)
+		buffer.Write(binary.BigEndian.AppendUint16(nil, uint16(count)))
+	default:
+		buffer.WriteByte(format32)
+		buffer.Write(binary.BigEndian.AppendUint32(nil, uint32(count)))
+	}
+}
+
+// writeNumber: the integers are written in their smallest format, the other numbers as 64 bits floats
+func writeNumber(buffer *bytes.Buffer, number json.Number) {
+	// -0 is written as a float, since the integers have no negative zero
+	if integer, err := strconv.ParseInt(number.String(), 10, 64); err == nil && (integer != 0 || !strings.HasPrefix(number.String(), "-")) {
+		writeInteger(buffer, integer)
+		return
+	}
+
+	if unsignedInteger, err := strconv.ParseUint(number.String(), 10, 64); err == nil {
+		writeUnsigned(buffer, unsignedInteger)
+		return
+	}
+
+	float, _ := strconv.ParseFloat(number.String(), 64)
+	buffer.WriteByte(0xcb)
+	buffer.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(float)))
+}
+
+// writeUnsigned: the integers which fit in a signed 64 bits integer are written in their smallest format, like the JSON numbers
+func writeUnsigned(buffer *bytes.Buffer, unsignedInteger uint64) {
+	if unsignedInteger <= math.MaxInt64 {
+		writeInteger(buffer, int64(unsignedInteger))
+		return
+	}
+	buffer.WriteByte(0xcf)
+	buffer.Write(binary.BigEndian.AppendUint64(nil, unsignedInteger))
+}
+
+func writeInteger(buffer *bytes.Buffer, integer int64) {
+	switch {
+	case integer >= 0 && integer <= math.MaxInt8:
+		buffer.WriteByte(byte(integer))
+	case integer < 0 && integer >= -32:
+		buffer.WriteByte(byte(int8(integer)))
+	case integer >= 0 && integer <= math.MaxUint8:
+		buffer.Write([]byte{0xcc, byte(integer)})
+	case integer >= 0 && integer <= math.MaxUint16:
+		buffer.WriteByte(0xcd)
+		buffer.Write(binary.BigEndian.AppendUint16(nil, uint16(integer)))
+	case integer >= 0 && integer <= math.MaxUint32:
+		buffer.WriteByte(0xce)
+		buffer.Write(binary.BigEndian.AppendUint32(nil, uint32(integer)))
+	case integer >= 0:
+		buffer.WriteByte(0xcf)
+		buffer.Write(binary.BigEndian.AppendUint64(nil, uint64(integer)))
+	case integer >= math.MinInt8:
+		buffer.Write([]byte{0xd0, byte(int8(integer))})
+	case integer >= math.MinInt16:
+		buffer.WriteByte(0xd1)
+		buffer.Write(binary.BigEndian.AppendUint16(nil, uint16(int16(integer))))
+	case integer >= math.MinInt32:
+		buffer.WriteByte(0xd2)
+		buffer.Write(binary.BigEndian.AppendUint32(nil, uint32(int32(integer))))
+	default:
+		buffer.WriteByte(0xd3)
+		buffer.Write(binary.BigEndian.AppendUint64(nil, uint64(integer)))
+	}
+}
+
+// messagePackReader: reads a message sent by a client, the lengths it carries are checked against the size of the
+// message before anything is allocated
+type messagePackReader struct {
+	message []byte
+	offset  int
+}
+
+func (r *messagePackReader) next(n int) ([]byte, error) {
+	if n < 0 || n > len(r.message)-r.offset {
+		return nil, ErrInvalidMessagePack
+	}
+	bytesRead := r.message[r.offset : r.offset+n]
+	r.offset += n
+	return bytesRead, nil
+}
+
+func (r *messagePackReader) length(size int) (int, error) {
+	lengthBytes, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+
+	switch size {
+	case 1:
+		return int(lengthBytes[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(lengthBytes)), nil
+	default:
+		return int(binary.BigEndian.Uint32(lengthBytes)), nil
+	}
+}
+
+// decodeValue: reads the next MessagePack value and writes it in JSON
+func (r *messagePackReader) decodeValue(buffer *bytes.Buffer, depth int) error {
+	if depth > maxMessagePackDepth {
+		return fmt.Errorf("%w: nested more than %d times", ErrInvalidMessagePack, maxMessagePackDepth)
+	}
+
+	formatBytes, err := r.next(1)
+	if err != nil {
+		return err
+	}
+	format := formatBytes[0]
+
+	switch {
+	case format <= 0x7f:
+		buffer.WriteString(strconv.Itoa(int(format)))
+		return nil
+	case format >= 0xe0:
+		buffer.WriteString(strconv.Itoa(int(int8(format))))
+		return nil
+	case format&0xf0 == 0x80:
+		return r.decodeMap(buffer, int(format&0x0f), depth)
+	case format&0xf0 == 0x90:
+		return r.decodeArray(buffer, int(format&0x0f), depth)
+	case format&0xe0 == 0xa0:
+		return r.decodeString(buffer, int(format&0x1f))
+	}
+
+	switch format {
+	case 0xc0:
+		buffer.WriteString("null")
+	case 0xc2:
+		buffer.WriteString("false")
+	case 0xc3:
+		buffer.WriteString("true")
+
+	// the strings and the binaries are both written as JSON strings
+	case 0xd9, 0xc4:
+		return r.decodeSizedString(buffer, 1)
+	case 0xda, 0xc5:
+		return r.decodeSizedString(buffer, 2)
+	case 0xdb, 0xc6:
+		return r.decodeSizedString(buffer, 4)
+
+	case 0xdc:
+		count, err := r.length(2)
+		if err != nil {
+			return err
+		}
+		return r.decodeArray(buffer, count, depth)
+	case 0xdd:
+		count, err := r.length(4)
+		if err != nil {
+			return err
+		}
+		return r.decodeArray(buffer, count, depth)
+	case 0xde:
+		count, err := r.length(2)
+		if err != nil {
+			return err
+		}
+		return r.decodeMap(buffer, count, depth)
+	case 0xdf:
+		count, err := r.length(4)
+		if err != nil {
+			return err
+		}
+		return r.decodeMap(buffer, count, depth)
+
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		integerBytes, err := r.next(1 << (format - 0xcc))
+		if err != nil {
+			return err
+		}
+		buffer.WriteString(strconv.FormatUint(readUnsigned(integerBytes), 10))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		integerBytes, err := r.next(1 << (format - 0xd0))
+		if err != nil {
+			return err
+		}
+		buffer.WriteString(strconv.FormatInt(readSigned(integerBytes), 10))
+
+	case 0xca:
+		floatBytes, err := r.next(4)
+		if err != nil {
+			return err
+		}
+		return writeFloat(buffer, float64(math.Float32frombits(binary.BigEndian.Uint32(floatBytes))))
+	case 0xcb:
+		floatBytes, err := r.next(8)
+		if err != nil {
+			return err
+		}
+		return writeFloat(buffer, math.Float64frombits(binary.BigEndian.Uint64(floatBytes)))
+
+	default:
+		// the extension types have no JSON equivalent
+		return fmt.Errorf("%w: unsupported format 0x%x", ErrInvalidMessagePack, format)
+	}
+
+	return nil
+}
+
+func (r *messagePackReader) decodeString(buffer *bytes.Buffer, length int) error {
+	stringBytes, err := r.next(length)
+	if err != nil {
+		return err
+	}
+
+	jsonString, _ := json.Marshal(string(stringBytes))
+	buffer.Write(jsonString)
+	return nil
+}
+
+func (r *messagePackReader) decodeSizedString(buffer *bytes.Buffer, size int) error {
+	length, err := r.length(size)
+	if err != nil {
+		return err
+	}
+	return r.decodeString(buffer, length)
+}
+
+func (r *messagePackReader) decodeArray(buffer *bytes.Buffer, count int, depth int) error {
+	// every element takes at least a byte
+	if count > len(r.message)-r.offset {
+		return ErrInvalidMessagePack
+	}
+
+	buffer.WriteByte('[')
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		err := r.decodeValue(buffer, depth+1)
+		if err != nil {
+			return err
+		}
+	}
+	buffer.WriteByte(']')
+	return nil
+}
+
+func (r *messagePackReader) decodeMap(buffer *bytes.Buffer, count int, depth int) error {
+	// every key and every value take at least a byte
+	if count > (len(r.message)-r.offset)/2 {
+		return ErrInvalidMessagePack
+	}
+
+	buffer.WriteByte('{')
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+
+		// the keys of a JSON object are strings
+		keyStart := buffer.Len()
+		err := r.decodeValue(buffer, depth+1)
+		if err != nil {
+			return err
+		}
+		if buffer.Bytes()[keyStart] != '"' {
+			return fmt.Errorf("%w: the keys of a map must be strings", ErrInvalidMessagePack)
+		}
+
+		buffer.WriteByte(':')
+		err = r.decodeValue(buffer, depth+1)
+		if err != nil {
+			return err
+		}
+	}
+	buffer.WriteByte('}')
+	return nil
+}
+
+func readUnsigned(integerBytes []byte) uint64 {
+	switch len(integerBytes) {
+	case 1:
+		return uint64(integerBytes[0])
+	case 2:
+		return uint64(binary.BigEndian.Uint16(integerBytes))
+	case 4:
+		return uint64(binary.BigEndian.Uint32(integerBytes))
+	default:
+		return binary.BigEndian.Uint64(integerBytes)
+	}
+}
+
+func readSigned(integerBytes []byte) int64 {
+	switch len(integerBytes) {
+	case 1:
+		return int64(int8(integerBytes[0]))
+	case 2:
+		return int64(int16(binary.BigEndian.Uint16(integerBytes)))
+	case 4:
+		return int64(int32(binary.BigEndian.Uint32(integerBytes)))
+	default:
+		return int64(binary.BigEndian.Uint64(integerBytes))
+	}
+}
+
+// writeFloat: NaN and the infinities have no JSON equivalent
+func writeFloat(buffer *bytes.Buffer, float float64) error {
+	if math.IsNaN(float) || math.IsInf(float, 0) {
+		return fmt.Errorf("%w: %v is not a valid number", ErrInvalidMessagePack, float)
+	}
+	buffer.WriteString(strconv.FormatFloat(float, 'g', -1, 64))
+	return nil
+}
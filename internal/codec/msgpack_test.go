@@ -0,0 +1,219 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/skamranahmed/estimatex-server/internal/event"
+)
+
+func TestMessagePackRoundTrip(t *testing.T) {
+	revealedVotes := []event.RevealedVote{
+		{MemberID: "m1", MemberName: "alice", Vote: "5"},
+		{MemberID: "m2", MemberName: "bob", Vote: "?"},
+	}
+
+	testCases := []struct {
+		name     string
+		envelope event.Event
+		data     any
+	}{
+		{name: "create room", data: event.CreateRoomEventData{RoomID: "ABC123"}},
+		{name: "room join updates", data: event.RoomJoinUpdatesEventData{Message: "bob joined the room", MemberID: "m2", MemberName: "bob"}},
+		{name: "room capacity reached", data: event.RoomCapacityReachedEventData{Message: "the room is full"}},
+		{name: "begin voting prompt", data: event.BeginVotingPromptEventData{Message: "enter the ticket id"}},
+		{name: "begin voting", data: event.BeginVotingEventData{TicketID: "ABC-1"}},
+		{name: "ask for vote", data: event.AskForVoteEventData{TicketID: "ABC-1", Title: "Checkout <flow> & \"payments\"", Description: strings.Repeat("long description ", 20), URL: "https://tracker.example.com/ABC-1"}},
+		{name: "member voted", data: event.MemberVotedEventData{TicketID: "ABC-1", Vote: "5"}},
+		{name: "voting completed", data: event.VotingCompletedEventData{TicketID: "ABC-1", Message: "everyone has voted"}},
+		{name: "reveal votes prompt", data: event.RevealVotesPromptEventData{TicketID: "ABC-1", Message: "reveal the votes"}},
+		{name: "reveal votes", data: event.RevealVotesEventData{TicketID: "ABC-1"}},
+		{name: "votes revealed", data: event.VotesRevealedEventData{TicketID: "ABC-1", MemberVoteChoiceMap: map[string]interface{}{
+			"alice": map[string]any{"value": "5", "member_id": "m1"},
+			"bob":   nil,
+		}}},
+		{name: "awaiting admin vote start", data: event.AwaitingAdminVoteStartEventData{Message: "waiting for the admin"}},
+		{name: "create invite", data: event.CreateInviteEventData{TTLSeconds: 86400}},
+		{name: "invite created", data: event.InviteCreatedEventData{InviteID: "i1", Token: strings.Repeat("t", 300), ExpiresAt: 1760000000000}},
+		{name: "revoke invite", data: event.RevokeInviteEventData{InviteID: "i1"}},
+		{name: "invite revoked", data: event.InviteRevokedEventData{InviteID: "i1"}},
+		{name: "rate limited", data: event.RateLimitedEventData{Scope: "member", EventType: "MEMBER_VOTED", Message: "slow down", ID: "e1"}},
+		{name: "error", data: event.ErrorEventData{Code: "INVALID_PAYLOAD", EventType: "BEGIN_VOTING", Message: "ticket_id is missing", ID: "e2"}},
+		{name: "room closed", data: event.RoomClosedEventData{Message: "the admin has left"}},
+		{name: "kick member", data: event.KickMemberEventData{MemberID: "m2", Ban: true}},
+		{name: "member kicked", data: event.MemberKickedEventData{MemberID: "m2", MemberName: "bob", Banned: true, Message: "bob has been removed"}},
+		{name: "mute member", data: event.MuteMemberEventData{MemberID: "m2", Muted: false}},
+		{name: "member muted", data: event.MemberMutedEventData{MemberID: "m2", MemberName: "bob", Muted: true, Message: "bob has been muted"}},
+		{name: "set final estimate", data: event.SetFinalEstimateEventData{TicketID: "ABC-1", Estimate: "8"}},
+		{name: "final estimate set", data: event.FinalEstimateSetEventData{TicketID: "ABC-1", Estimate: "8", Message: "the estimate is 8", SyncedToTracker: true}},
+		{name: "resume token", data: event.ResumeTokenEventData{MemberID: "m1", ResumeToken: "c2VjcmV0"}},
+		{name: "ack", data: event.AckEventData{ID: "e3", EventType: "MEMBER_VOTED", Duplicate: true}},
+		{name: "replay events", data: event.ReplayEventsEventData{SinceSeq: math.MaxUint64}},
+		{name: "room state", data: event.RoomStateEventData{
+			RoomID:              "ABC123",
+			MaxCapacity:         70000,
+			IsPasswordProtected: true,
+			CreatedAt:           1760000000000,
+			Phase:               "voting",
+			CurrentTicketID:     "ABC-2",
+			Members: []event.RoomStateMember{
+				{MemberID: "m1", MemberName: "alice", IsRoomAdmin: true, Connected: true, HasVoted: true},
+				{MemberID: "m2", MemberName: "bob", Muted: true},
+			},
+			RecentResults: []event.RoomStateResult{{TicketID: "ABC-1", Votes: revealedVotes, FinalEstimate: "5", RevealedAt: 1760000000001}},
+			LastSeq:       1 << 40,
+		}},
+		{name: "welcome", data: event.WelcomeEventData{
+			ProtocolVersion:            2,
+			SupportedProtocolVersions:  []int{1, 2},
+			DeprecatedProtocolVersions: []int{},
+			ServerVersion:              "1.4.0",
+			Capabilities:               []string{"replay", "msgpack"},
+		}},
+		{name: "member vote cast", data: event.MemberVoteCastEventData{TicketID: "ABC-1", MemberID: "m1", MemberName: "alice", Message: "alice has voted"}},
+		{name: "votes revealed v2", data: event.VotesRevealedV2EventData{TicketID: "ABC-1", Votes: revealedVotes}},
+
+		// the fields of the envelope, from the smallest to the largest numbers
+		{name: "numbered event", envelope: event.Event{Seq: 7, PrevSeq: 3, SentAt: 1760000000000, TraceID: "4bf92f3577b34da6a3ce929d0e0e4736"}, data: event.BeginVotingEventData{TicketID: "ABC-1"}},
+		{name: "event with an id", envelope: event.Event{ID: "e4", Seq: math.MaxUint64, PrevSeq: math.MaxInt64}, data: event.AckEventData{ID: "e4", EventType: "BEGIN_VOTING"}},
+		{name: "event without data", data: nil},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			jsonData, err := json.Marshal(testCase.data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			envelope := testCase.envelope
+			envelope.Type = "EVENT"
+			envelope.Data = jsonData
+			jsonMessage := envelope.AppendJSON(nil)
+
+			encodedMessage, err := MessagePack.Encode(jsonMessage)
+			if err != nil {
+				t.Fatalf("unable to encode %s: %v", jsonMessage, err)
+			}
+			decodedMessage, err := MessagePack.Decode(encodedMessage)
+			if err != nil {
+				t.Fatalf("unable to decode %s: %v", jsonMessage, err)
+			}
+			if !bytes.Equal(decodedMessage, jsonMessage) {
+				t.Fatalf("got %s, want %s", decodedMessage, jsonMessage)
+			}
+
+			// the event encoded around the shared data is the same as the event encoded as a whole
+			encodedEvent, err := MessagePack.EncodeEvent(envelope, event.NewSharedData(jsonData))
+			if err != nil {
+				t.Fatalf("unable to encode the event: %v", err)
+			}
+			if !bytes.Equal(encodedEvent, encodedMessage) {
+				t.Fatalf("got %x, want %x", encodedEvent, encodedMessage)
+			}
+
+			jsonEvent, err := JSON.EncodeEvent(envelope, event.NewSharedData(jsonData))
+			if err != nil {
+				t.Fatalf("unable to encode the event: %v", err)
+			}
+			if !bytes.Equal(jsonEvent, jsonMessage) {
+				t.Fatalf("got %s, want %s", jsonEvent, jsonMessage)
+			}
+		})
+	}
+}
+
+func TestTheSharedDataIsEncodedOnce(t *testing.T) {
+	data := event.NewSharedData(json.RawMessage(`{"ticket_id":"ABC-1"}`))
+
+	firstEvent, err := MessagePack.EncodeEvent(event.Event{Type: "BEGIN_VOTING", Seq: 1}, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the data is not converted again for the next member
+	encodedData, err := data.Encoding(NameMessagePack, func([]byte) ([]byte, error) {
+		return nil, errors.New("the data has been converted twice")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Contains(firstEvent, encodedData) {
+		t.Fatalf("got %x, want the event %x to carry it", encodedData, firstEvent)
+	}
+
+	secondEvent, err := MessagePack.EncodeEvent(event.Event{Type: "BEGIN_VOTING", Seq: 2}, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decodedEvent, err := MessagePack.Decode(secondEvent)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `{"type":"BEGIN_VOTING","data":{"ticket_id":"ABC-1"},"seq":2}`; string(decodedEvent) != want {
+		t.Fatalf("got %s, want %s", decodedEvent, want)
+	}
+}
+
+func TestPlainTextMessagesAreEncodedAsStrings(t *testing.T) {
+	encodedMessage, err := MessagePack.Encode([]byte("Welcome to the room"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decodedMessage, err := MessagePack.Decode(encodedMessage)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `"Welcome to the room"`; string(decodedMessage) != want {
+		t.Fatalf("got %s, want %s", decodedMessage, want)
+	}
+}
+
+// FuzzMessagePack: a message from a client either fails to decode, or decodes to JSON which round trips through the codec
+func FuzzMessagePack(f *testing.F) {
+	for _, jsonMessage := range []string{
+		`{"type":"JOIN_ROOM","data":{"room_id":"ABC123","member_name":"alice"},"id":"e1"}`,
+		`{"type":"MEMBER_VOTED","data":{"ticket_id":"ABC-1","vote":"5"},"seq":18446744073709551615}`,
+		`{"type":"REPLAY_EVENTS","data":{"since_seq":-9223372036854775808,"ratio":0.5,"ok":true,"none":null,"list":[1,"2",[]]}}`,
+		`"Welcome to the room"`,
+	} {
+		encodedMessage, err := MessagePack.Encode([]byte(jsonMessage))
+		if err != nil {
+			f.Fatalf("unable to encode %s: %v", jsonMessage, err)
+		}
+		f.Add(encodedMessage)
+	}
+
+	// truncated, oversized and nested messages
+	f.Add([]byte{0x81, 0xa4, 't', 'y'})
+	f.Add([]byte{0xdf, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0xdb, 0xff, 0xff, 0xff, 0xff})
+	f.Add(bytes.Repeat([]byte{0x91}, 64))
+	f.Add([]byte{0x82, 0x01, 0x02, 0xc1})
+
+	f.Fuzz(func(t *testing.T, message []byte) {
+		jsonMessage, err := MessagePack.Decode(message)
+		if err != nil {
+			return
+		}
+		if !json.Valid(jsonMessage) {
+			t.Fatalf("%x decodes to the invalid JSON %s", message, jsonMessage)
+		}
+
+		encodedMessage, err := MessagePack.Encode(jsonMessage)
+		if err != nil {
+			t.Fatalf("unable to encode %s: %v", jsonMessage, err)
+		}
+		decodedMessage, err := MessagePack.Decode(encodedMessage)
+		if err != nil {
+			t.Fatalf("unable to decode %s: %v", jsonMessage, err)
+		}
+		if !bytes.Equal(decodedMessage, jsonMessage) {
+			t.Fatalf("got %s, want %s", decodedMessage, jsonMessage)
+		}
+	})
+}
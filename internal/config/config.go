@@ -0,0 +1,510 @@
+package config
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skamranahmed/estimatex-server/internal/event"
+)
+
+// Config: holds the server configuration, which is read from the environment variables at startup
+type Config struct {
+	// Port is the port on which the HTTP server listens
+	Port int
+
+	// LogFormat is either text or json, and LogLevel is one of debug, info, warn or error
+	LogFormat string
+	LogLevel  string
+
+	// TracingExporter is one of none, stdout or otlp. The otlp exporter is configured with the
+	// standard OTEL_EXPORTER_OTLP_* environment variables.
+	TracingExporter string
+
+	// AllowedOrigins is the allow-list of the Origin headers accepted on websocket upgrades, `*` allows every origin
+	AllowedOrigins []string
+
+	// TLSCertFile and TLSKeyFile enable serving over TLS when both are set. The files are checked for changes
+	// every TLSReloadInterval, so a renewed certificate is served without restarting the server.
+	TLSCertFile       string
+	TLSKeyFile        string
+	TLSReloadInterval time.Duration
+
+	// DrainTimeout is how long the server waits, after receiving SIGINT or SIGTERM, for the open rooms to close
+	// before shutting down. New connections are refused and the readiness endpoint fails while draining.
+	DrainTimeout time.Duration
+
+	// HTTPRedirectPort is the port on which plain HTTP requests are redirected to HTTPS, 0 disables the redirect
+	HTTPRedirectPort int
+
+	// AdminToken is the bearer token of the admin API, the admin API is disabled when it is empty
+	AdminToken string
+
+	// WebhookURLs receive the webhook events of every room, signed with WebhookSecret. A delivery is attempted
+	// WebhookMaxAttempts times, and is then appended to WebhookDeadLetterFile when it is set.
+	WebhookURLs           []string
+	WebhookSecret         string
+	WebhookMaxAttempts    int
+	WebhookDeadLetterFile string
+
+	// RoomWebhookAllowedHosts is the allow-list of the hosts of the webhooks which can be set by a room admin
+	// on CREATE_ROOM, it follows the format of AllowedOrigins. The room webhooks are disabled when it is empty.
+	RoomWebhookAllowedHosts []string
+
+	// ChatWebhookAllowedHosts is the allow-list of the hosts of the Slack or Mattermost incoming webhooks which can
+	// be set by a room admin on CREATE_ROOM, it follows the format of AllowedOrigins. The chat notifications are
+	// disabled when it is empty.
+	ChatWebhookAllowedHosts []string
+
+	// JoinURLTemplate is the link to join a room from a client, posted to the chats, in which `{room_id}` is replaced
+	// by the id of the room. The chat notifications only carry the room id when it is empty.
+	JoinURLTemplate string
+
+	// Tracker is one of none, jira, github or fake. The tickets of BEGIN_VOTING are fetched from the tracker,
+	// and the final estimates are written back to it. Only the settings of the selected tracker are used.
+	Tracker string
+
+	JiraBaseURL          string
+	JiraEmail            string
+	JiraAPIToken         string
+	JiraStoryPointsField string
+
+	GitHubAPIURL              string
+	GitHubRepository          string
+	GitHubToken               string
+	GitHubEstimateLabelPrefix string
+
+	// ClusterBackend is either memory, for a single instance, or redis, to share the rooms between several
+	// instances. NodeID identifies the instance in the cluster, and it must be unique among the instances.
+	ClusterBackend string
+	RedisURL       string
+	NodeID         string
+
+	// ClusterLeaseTTL is how long the rooms of an instance which has crashed stay unavailable, before they are
+	// taken over by another instance and the clients relayed to them are asked to reconnect
+	ClusterLeaseTTL time.Duration
+
+	// SnapshotDir is the directory in which the state of the rooms is written, so that the rooms are restored when the
+	// server restarts. The snapshots are disabled when it is empty, unless the cluster backend is redis, in which case
+	// the snapshots are kept in Redis.
+	SnapshotDir      string
+	SnapshotInterval time.Duration
+
+	// ResumeWindow is how long the members of a restored room have to reconnect with their resume token
+	ResumeWindow time.Duration
+
+	// PollTimeout is how long a poll request of a client connected through HTTP waits for messages, and
+	// HTTPIdleTimeout is how long such a connection is kept when its messages are neither polled nor streamed
+	PollTimeout     time.Duration
+	HTTPIdleTimeout time.Duration
+
+	// MinProtocolVersion is the oldest version of the protocol which is still served, the clients speaking an older
+	// version are refused. It is raised once the deprecation window of a version is over.
+	MinProtocolVersion int
+
+	// RoomIDStyle, RoomIDLength and RoomIDAlphabet control how the ids of the new rooms are generated,
+	// see session.NewRoomIDGenerator for the supported styles
+	RoomIDStyle    string
+	RoomIDLength   int
+	RoomIDAlphabet string
+
+	// InviteTokenSecret is the key used to sign room invite tokens. When it is not provided, a random key is
+	// generated at startup, which means that the invite tokens minted by a previous process will not be valid anymore.
+	InviteTokenSecret []byte
+
+	// TrustedProxies are the addresses, or the CIDR ranges, of the reverse proxies in front of the server. The IP of
+	// a client connecting through one of them is read from the X-Forwarded-For header, so that the bans and the per
+	// IP limits apply to the client instead of the proxy. The header is ignored when it is empty.
+	TrustedProxies []netip.Prefix
+
+	// JoinAttemptsPerMinute and JoinAttemptsBurst control how many failed JOIN_ROOM attempts a single source IP
+	// is allowed to make before it is temporarily blocked
+	JoinAttemptsPerMinute int
+	JoinAttemptsBurst     int
+
+	// The token bucket rate limits, per source IP on websocket upgrades, per member on incoming events
+	// and per room on the events which are broadcast to every member. A rate of 0 disables the limit.
+	UpgradesPerMinute       int
+	UpgradesBurst           int
+	MemberEventsPerMinute   int
+	MemberEventsBurst       int
+	RoomBroadcastsPerMinute int
+	RoomBroadcastsBurst     int
+
+	// The server wide limits. MaxEventSize is in bytes, and the field lengths are in characters.
+	MaxRooms          int
+	MaxMembersPerRoom int
+	MaxConnections    int
+	MaxEventSize      int
+	MaxNameLength     int
+	MaxPasswordLength int
+	MaxTicketIDLength int
+	MaxVoteLength     int
+
+	// ReplayBufferSize is the number of the latest events sent to the members of a room which are kept to be replayed,
+	// a broadcast event is kept once whatever the number of its recipients
+	ReplayBufferSize int
+}
+
+// maxBcryptPasswordLength is the maximum number of bytes of a password that bcrypt can hash
+const maxBcryptPasswordLength = 72
+
+// Load: builds the Config from the environment variables, falling back to the defaults for the ones that are not set
+func Load() (*Config, error) {
+	var err error
+	cfg := &Config{}
+
+	cfg.Port, err = intFromEnv("ESTIMATEX_PORT", 8080)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.LogFormat = stringFromEnv("ESTIMATEX_LOG_FORMAT", "text")
+	cfg.LogLevel = stringFromEnv("ESTIMATEX_LOG_LEVEL", "info")
+
+	cfg.TracingExporter = stringFromEnv("ESTIMATEX_TRACING_EXPORTER", "none")
+
+	cfg.AllowedOrigins = listFromEnv("ESTIMATEX_ALLOWED_ORIGINS", []string{"*"})
+
+	cfg.TLSCertFile = stringFromEnv("ESTIMATEX_TLS_CERT_FILE", "")
+	cfg.TLSKeyFile = stringFromEnv("ESTIMATEX_TLS_KEY_FILE", "")
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, fmt.Errorf("ESTIMATEX_TLS_CERT_FILE and ESTIMATEX_TLS_KEY_FILE must be set together")
+	}
+
+	cfg.TLSReloadInterval, err = durationFromEnv("ESTIMATEX_TLS_RELOAD_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TLSReloadInterval == 0 {
+		return nil, fmt.Errorf("ESTIMATEX_TLS_RELOAD_INTERVAL must be greater than zero")
+	}
+
+	cfg.DrainTimeout, err = durationFromEnv("ESTIMATEX_DRAIN_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.HTTPRedirectPort, err = intFromEnv("ESTIMATEX_HTTP_REDIRECT_PORT", 0)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.HTTPRedirectPort != 0 && !cfg.TLSEnabled() {
+		return nil, fmt.Errorf("ESTIMATEX_HTTP_REDIRECT_PORT requires ESTIMATEX_TLS_CERT_FILE and ESTIMATEX_TLS_KEY_FILE to be set")
+	}
+
+	cfg.AdminToken = stringFromEnv("ESTIMATEX_ADMIN_TOKEN", "")
+
+	cfg.WebhookURLs = listFromEnv("ESTIMATEX_WEBHOOK_URLS", nil)
+	cfg.WebhookSecret = stringFromEnv("ESTIMATEX_WEBHOOK_SECRET", "")
+	if len(cfg.WebhookURLs) > 0 && cfg.WebhookSecret == "" {
+		return nil, fmt.Errorf("ESTIMATEX_WEBHOOK_SECRET must be set when ESTIMATEX_WEBHOOK_URLS is set")
+	}
+
+	cfg.WebhookMaxAttempts, err = positiveIntFromEnv("ESTIMATEX_WEBHOOK_MAX_ATTEMPTS", 5)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.WebhookDeadLetterFile = stringFromEnv("ESTIMATEX_WEBHOOK_DEAD_LETTER_FILE", "")
+	cfg.RoomWebhookAllowedHosts = listFromEnv("ESTIMATEX_ROOM_WEBHOOK_ALLOWED_HOSTS", nil)
+	cfg.ChatWebhookAllowedHosts = listFromEnv("ESTIMATEX_CHAT_WEBHOOK_ALLOWED_HOSTS", nil)
+
+	cfg.JoinURLTemplate = stringFromEnv("ESTIMATEX_JOIN_URL_TEMPLATE", "")
+	if cfg.JoinURLTemplate != "" && !strings.Contains(cfg.JoinURLTemplate, "{room_id}") {
+		return nil, fmt.Errorf("ESTIMATEX_JOIN_URL_TEMPLATE must contain {room_id}")
+	}
+
+	cfg.Tracker = stringFromEnv("ESTIMATEX_TRACKER", "none")
+
+	cfg.JiraBaseURL = stringFromEnv("ESTIMATEX_JIRA_BASE_URL", "")
+	cfg.JiraEmail = stringFromEnv("ESTIMATEX_JIRA_EMAIL", "")
+	cfg.JiraAPIToken = stringFromEnv("ESTIMATEX_JIRA_API_TOKEN", "")
+	cfg.JiraStoryPointsField = stringFromEnv("ESTIMATEX_JIRA_STORY_POINTS_FIELD", "customfield_10016")
+
+	cfg.GitHubAPIURL = stringFromEnv("ESTIMATEX_GITHUB_API_URL", "https://api.github.com")
+	cfg.GitHubRepository = stringFromEnv("ESTIMATEX_GITHUB_REPOSITORY", "")
+	cfg.GitHubToken = stringFromEnv("ESTIMATEX_GITHUB_TOKEN", "")
+	// the prefix is not trimmed, so that its trailing space is kept
+	cfg.GitHubEstimateLabelPrefix = os.Getenv("ESTIMATEX_GITHUB_ESTIMATE_LABEL_PREFIX")
+	if cfg.GitHubEstimateLabelPrefix == "" {
+		cfg.GitHubEstimateLabelPrefix = "estimate: "
+	}
+
+	cfg.ClusterBackend = stringFromEnv("ESTIMATEX_CLUSTER_BACKEND", "memory")
+	cfg.RedisURL = stringFromEnv("ESTIMATEX_REDIS_URL", "")
+	if cfg.ClusterBackend == "redis" && cfg.RedisURL == "" {
+		return nil, fmt.Errorf("ESTIMATEX_REDIS_URL must be set when ESTIMATEX_CLUSTER_BACKEND is redis")
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "estimatex"
+	}
+	cfg.NodeID = stringFromEnv("ESTIMATEX_NODE_ID", hostname)
+
+	cfg.ClusterLeaseTTL, err = durationFromEnv("ESTIMATEX_CLUSTER_LEASE_TTL", 15*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ClusterLeaseTTL < 3*time.Second {
+		return nil, fmt.Errorf("ESTIMATEX_CLUSTER_LEASE_TTL must be at least 3s")
+	}
+
+	cfg.SnapshotDir = stringFromEnv("ESTIMATEX_SNAPSHOT_DIR", "")
+	if cfg.ClusterBackend == "redis" && cfg.SnapshotDir != "" {
+		return nil, fmt.Errorf("ESTIMATEX_SNAPSHOT_DIR cannot be set when ESTIMATEX_CLUSTER_BACKEND is redis, the snapshots are kept in Redis")
+	}
+
+	cfg.SnapshotInterval, err = durationFromEnv("ESTIMATEX_SNAPSHOT_INTERVAL", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SnapshotInterval == 0 {
+		return nil, fmt.Errorf("ESTIMATEX_SNAPSHOT_INTERVAL must be greater than zero")
+	}
+
+	cfg.ResumeWindow, err = durationFromEnv("ESTIMATEX_RESUME_WINDOW", 2*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ResumeWindow == 0 {
+		return nil, fmt.Errorf("ESTIMATEX_RESUME_WINDOW must be greater than zero")
+	}
+
+	cfg.PollTimeout, err = durationFromEnv("ESTIMATEX_POLL_TIMEOUT", 25*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PollTimeout == 0 {
+		return nil, fmt.Errorf("ESTIMATEX_POLL_TIMEOUT must be greater than zero")
+	}
+
+	cfg.HTTPIdleTimeout, err = durationFromEnv("ESTIMATEX_HTTP_IDLE_TIMEOUT", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.HTTPIdleTimeout == 0 {
+		return nil, fmt.Errorf("ESTIMATEX_HTTP_IDLE_TIMEOUT must be greater than zero")
+	}
+
+	cfg.MinProtocolVersion, err = positiveIntFromEnv("ESTIMATEX_MIN_PROTOCOL_VERSION", event.ProtocolVersion1)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MinProtocolVersion > event.LatestProtocolVersion {
+		return nil, fmt.Errorf("ESTIMATEX_MIN_PROTOCOL_VERSION cannot be greater than %d", event.LatestProtocolVersion)
+	}
+
+	cfg.RoomIDStyle = stringFromEnv("ESTIMATEX_ROOM_ID_STYLE", "alphanumeric")
+
+	// a words based id is made of a few words, whereas a character based id needs more characters to be hard to guess
+	defaultRoomIDLength := 6
+	if cfg.RoomIDStyle == "words" {
+		defaultRoomIDLength = 3
+	}
+	cfg.RoomIDLength, err = intFromEnv("ESTIMATEX_ROOM_ID_LENGTH", defaultRoomIDLength)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.RoomIDAlphabet = stringFromEnv("ESTIMATEX_ROOM_ID_ALPHABET", "")
+
+	cfg.InviteTokenSecret = []byte(os.Getenv("ESTIMATEX_INVITE_TOKEN_SECRET"))
+	if len(cfg.InviteTokenSecret) == 0 && cfg.ClusterBackend == "redis" {
+		// an invite token is verified by the instance of the client, which is not the instance which has minted it
+		return nil, fmt.Errorf("ESTIMATEX_INVITE_TOKEN_SECRET must be set when ESTIMATEX_CLUSTER_BACKEND is redis")
+	}
+	if len(cfg.InviteTokenSecret) == 0 {
+		cfg.InviteTokenSecret = make([]byte, 32)
+		_, err = rand.Read(cfg.InviteTokenSecret)
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate the invite token secret: %w", err)
+		}
+	}
+
+	cfg.TrustedProxies, err = prefixesFromEnv("ESTIMATEX_TRUSTED_PROXIES")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.JoinAttemptsPerMinute, err = intFromEnv("ESTIMATEX_JOIN_ATTEMPTS_PER_MINUTE", 10)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.JoinAttemptsBurst, err = intFromEnv("ESTIMATEX_JOIN_ATTEMPTS_BURST", 5)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.UpgradesPerMinute, err = intFromEnv("ESTIMATEX_UPGRADES_PER_MINUTE", 30)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.UpgradesBurst, err = intFromEnv("ESTIMATEX_UPGRADES_BURST", 10)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.MemberEventsPerMinute, err = intFromEnv("ESTIMATEX_MEMBER_EVENTS_PER_MINUTE", 60)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.MemberEventsBurst, err = intFromEnv("ESTIMATEX_MEMBER_EVENTS_BURST", 10)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.RoomBroadcastsPerMinute, err = intFromEnv("ESTIMATEX_ROOM_BROADCASTS_PER_MINUTE", 300)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.RoomBroadcastsBurst, err = intFromEnv("ESTIMATEX_ROOM_BROADCASTS_BURST", 50)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.MaxRooms, err = positiveIntFromEnv("ESTIMATEX_MAX_ROOMS", 1000)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.MaxMembersPerRoom, err = positiveIntFromEnv("ESTIMATEX_MAX_MEMBERS_PER_ROOM", 50)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.MaxConnections, err = positiveIntFromEnv("ESTIMATEX_MAX_CONNECTIONS", 10000)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.MaxEventSize, err = positiveIntFromEnv("ESTIMATEX_MAX_EVENT_SIZE", 4096)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.MaxNameLength, err = positiveIntFromEnv("ESTIMATEX_MAX_NAME_LENGTH", 64)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.MaxPasswordLength, err = positiveIntFromEnv("ESTIMATEX_MAX_PASSWORD_LENGTH", maxBcryptPasswordLength)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxPasswordLength > maxBcryptPasswordLength {
+		return nil, fmt.Errorf("ESTIMATEX_MAX_PASSWORD_LENGTH cannot be greater than %d", maxBcryptPasswordLength)
+	}
+
+	cfg.MaxTicketIDLength, err = positiveIntFromEnv("ESTIMATEX_MAX_TICKET_ID_LENGTH", 128)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.MaxVoteLength, err = positiveIntFromEnv("ESTIMATEX_MAX_VOTE_LENGTH", 16)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.ReplayBufferSize, err = positiveIntFromEnv("ESTIMATEX_REPLAY_BUFFER_SIZE", 1024)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// TLSEnabled: reports whether the server must be served over TLS
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+func stringFromEnv(key string, defaultValue string) string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func intFromEnv(key string, defaultValue int) (int, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	parsedValue, err := strconv.Atoi(value)
+	if err != nil || parsedValue < 0 {
+		return 0, fmt.Errorf("invalid value for %s: %q, expected a non-negative integer", key, value)
+	}
+	return parsedValue, nil
+}
+
+func positiveIntFromEnv(key string, defaultValue int) (int, error) {
+	value, err := intFromEnv(key, defaultValue)
+	if err != nil {
+		return 0, err
+	}
+	if value == 0 {
+		return 0, fmt.Errorf("invalid value for %s: it must be greater than zero", key)
+	}
+	return value, nil
+}
+
+// listFromEnv: reads a comma separated list, the empty items are ignored
+func listFromEnv(key string, defaultValue []string) []string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// prefixesFromEnv: reads a comma separated list of IP addresses and CIDR ranges, an address is a range of its own
+func prefixesFromEnv(key string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range listFromEnv(key, nil) {
+		if address, err := netip.ParseAddr(item); err == nil {
+			address = address.Unmap().WithZone("")
+			prefixes = append(prefixes, netip.PrefixFrom(address, address.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %q, expected an IP address or a CIDR range", key, item)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func durationFromEnv(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	parsedValue, err := time.ParseDuration(value)
+	if err != nil || parsedValue < 0 {
+		return 0, fmt.Errorf("invalid value for %s: %q, expected a duration like 30s or 5m", key, value)
+	}
+	return parsedValue, nil
+}
@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/skamranahmed/estimatex-server/internal/api"
+	"github.com/skamranahmed/estimatex-server/internal/cluster"
+	"github.com/skamranahmed/estimatex-server/internal/entity"
+	"github.com/skamranahmed/estimatex-server/internal/event"
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+	"github.com/skamranahmed/estimatex-server/internal/metrics"
+	"github.com/skamranahmed/estimatex-server/internal/transport"
+)
+
+// joinRemoteRoom: checks the client against the latest snapshot of a room owned by another instance of the server,
+// before relaying it to the owner. The credentials of the client are checked here, so that they never leave this
+// instance, and the owner checks what may have changed since the snapshot. It reports false when the client could
+// not be relayed, in which case the client has been told why.
+func (c *Controller) joinRemoteRoom(ctx context.Context, r *http.Request, connection transport.Connection, ownerNodeID string, request cluster.JoinRequest, requestLogger *slog.Logger) bool {
+	room, err := c.sessionManager.RemoteRoom(request.RoomID)
+	if err != nil {
+		requestLogger.Error("Unable to load the snapshot of the room", "owner_node_id", ownerNodeID, logger.KeyError, err, logger.KeyErrorCode, api.ErrorCodeServiceUnavailable)
+		api.SendErrorResponse(connection, api.ErrorCodeServiceUnavailable, "unable to join the room, please try again")
+		return false
+	}
+
+	resumeToken := strings.TrimSpace(r.URL.Query().Get("resume_token"))
+	if resumeToken != "" {
+		memberID := strings.TrimSpace(r.URL.Query().Get("member_id"))
+		_, errorCode, errMessage := c.checkResume(room, request.RoomID, memberID, request.RemoteIP, func(room *entity.Room) (*entity.DetachedMember, error) {
+			return room.ResumeMember(memberID, resumeToken)
+		}, requestLogger)
+		if errorCode != "" {
+			api.SendErrorResponse(connection, errorCode, errMessage)
+			return false
+		}
+
+		request.MemberID = memberID
+	} else {
+		password := r.URL.Query().Get("password")
+		inviteToken := strings.TrimSpace(r.URL.Query().Get("invite_token"))
+		errorCode, errMessage := c.checkJoin(room, request.RoomID, request.RemoteIP, func(room *entity.Room) error {
+			return room.Authorize(password, inviteToken)
+		}, requestLogger)
+		if errorCode != "" {
+			api.SendErrorResponse(connection, errorCode, errMessage)
+			return false
+		}
+
+		// the invite may have been revoked since the snapshot, hence the owner checks it again
+		if inviteToken != "" {
+			claims, _ := room.InviteSigner.Verify(inviteToken)
+			request.InviteID = claims.ID
+		}
+	}
+
+	request.Authorized = true
+	return c.relayToOwner(ctx, connection, ownerNodeID, request, requestLogger)
+}
+
+// relayToOwner: relays the client to the room owned by another instance of the server, until either side closes
+// the connection. It reports false when the client could not be relayed, in which case the connection is closed.
+// The events are relayed as JSON, the connection of the client converts them from and to its encoding.
+func (c *Controller) relayToOwner(ctx context.Context, connection transport.Connection, ownerNodeID string, request cluster.JoinRequest, requestLogger *slog.Logger) bool {
+	relay, err := c.cluster.JoinRoom(ctx, ownerNodeID, request)
+	if err != nil {
+		requestLogger.Error("Unable to relay the client to the owner of the room", "owner_node_id", ownerNodeID, logger.KeyError, err, logger.KeyErrorCode, api.ErrorCodeServiceUnavailable)
+		api.SendErrorResponse(connection, api.ErrorCodeServiceUnavailable, "unable to join the room, please try again")
+		return false
+	}
+
+	requestLogger.Info("Client relayed to the owner of the room", "owner_node_id", ownerNodeID)
+
+	// the events of the client are forwarded to the owner, the connection slot is released once the client is gone
+	go func() {
+		defer func() {
+			relay.Close()
+			c.activeConnections.Add(-1)
+		}()
+
+		for {
+			payload, err := connection.ReadMessage()
+			if err != nil {
+				if errors.Is(err, transport.ErrInvalidMessage) {
+					requestLogger.Warn("Error decoding the received event message from the client", logger.KeyError, err)
+				}
+				return
+			}
+
+			err = relay.Send(payload)
+			if err != nil {
+				requestLogger.Warn("Unable to relay an event to the owner of the room", logger.KeyError, err)
+				return
+			}
+		}
+	}()
+
+	// the messages of the owner are written to the client, until the owner closes the connection or goes away
+	go func() {
+		for message := range relay.Messages() {
+			if message.Close {
+				connection.Close(message.CloseCode, message.CloseReason)
+				return
+			}
+
+			err := connection.WriteMessage(message.Payload)
+			if err != nil {
+				metrics.WebsocketWriteErrors.Inc()
+				connection.Close(transport.CloseGoingAway, "Server closing connection")
+				return
+			}
+		}
+
+		// the relay has stopped without being closed by the owner, e.g. the owner has crashed, hence the client is asked to reconnect
+		connection.Close(transport.CloseServiceRestart, "the room is not available anymore, please reconnect")
+	}()
+
+	return true
+}
+
+// admitRemoteMember: adds a client relayed by another instance of the server to a room of this instance. Its credentials
+// have been checked by its instance, the other checks are the same as for a client connected to this instance.
+func (c *Controller) admitRemoteMember(request cluster.JoinRequest, remoteConnection *cluster.RemoteConnection) {
+	requestLogger := slog.With(logger.KeyRemoteAddr, request.RemoteIP, logger.KeyRoomID, request.RoomID)
+
+	if !request.Authorized {
+		requestLogger.Warn("Refusing a relayed client whose credentials have not been checked by its instance", logger.KeyErrorCode, api.ErrorCodeServiceUnavailable)
+		api.SendErrorResponse(remoteConnection, api.ErrorCodeServiceUnavailable, "unable to join the room, please try again")
+		return
+	}
+
+	if c.joinAttemptLimiter.Blocked(request.RemoteIP) {
+		requestLogger.Warn("Too many failed attempts to join a room", logger.KeyErrorCode, api.ErrorCodeTooManyRequests)
+		api.SendErrorResponse(remoteConnection, api.ErrorCodeTooManyRequests, "⛔ Too many failed attempts to join a room. Please wait a minute and try again.")
+		return
+	}
+
+	protocolVersion := max(request.ProtocolVersion, event.ProtocolVersion1)
+	err := c.checkProtocolVersion(protocolVersion)
+	if err != nil {
+		requestLogger.Warn("Got an unsupported protocol version", logger.KeyError, err, logger.KeyErrorCode, api.ErrorCodeUnsupportedVersion)
+		api.SendErrorResponse(remoteConnection, api.ErrorCodeUnsupportedVersion, err.Error())
+		return
+	}
+
+	room := c.sessionManager.FindRoom(request.RoomID)
+
+	isResumed := request.MemberID != ""
+
+	var member *entity.Member
+	if isResumed {
+		detachedMember, errorCode, errMessage := c.checkResume(room, request.RoomID, request.MemberID, request.RemoteIP, func(room *entity.Room) (*entity.DetachedMember, error) {
+			return room.ClaimDetachedMember(request.MemberID)
+		}, requestLogger)
+		if errorCode != "" {
+			api.SendErrorResponse(remoteConnection, errorCode, errMessage)
+			return
+		}
+
+		member = entity.NewResumedMember(detachedMember, remoteConnection, room.ID, slog.With(logger.KeyRemoteAddr, request.RemoteIP))
+	} else {
+		errorCode, errMessage := c.checkJoin(room, request.RoomID, request.RemoteIP, func(room *entity.Room) error {
+			return room.CheckInvite(request.InviteID)
+		}, requestLogger)
+		if errorCode != "" {
+			api.SendErrorResponse(remoteConnection, errorCode, errMessage)
+			return
+		}
+
+		member = entity.NewRemoteMember(request.Name, remoteConnection, room.ID, slog.With(logger.KeyRemoteAddr, request.RemoteIP))
+	}
+	member.ProtocolVersion = protocolVersion
+
+	if !c.seatMember(room, member, remoteConnection, requestLogger) {
+		return
+	}
+	member.Logger.Info("Relayed member connected to the room", "resumed", isResumed, "protocol_version", protocolVersion)
+
+	memberRole := metrics.MemberRole(member.IsRoomAdmin)
+	metrics.ConnectedMembers.WithLabelValues(memberRole).Inc()
+	metrics.ProtocolVersionConnections.WithLabelValues(strconv.Itoa(protocolVersion)).Inc()
+
+	done := make(chan bool)
+
+	go func() {
+		member.ReadMessages(room, done)
+
+		// the admin of a restored room may come back through another instance, the room is closed when it disconnects
+		metrics.ConnectedMembers.WithLabelValues(memberRole).Dec()
+		if member.IsRoomAdmin {
+			c.sessionManager.RemoveRoom(room)
+		}
+	}()
+
+	go member.WriteMessages(done)
+
+	// the events are sent once the write go-routine is running, since it consumes them
+	ctx := context.Background()
+	c.sendWelcome(ctx, member)
+	if c.sessionManager.SnapshotsEnabled() {
+		member.SendResumeTokenEvent(ctx)
+	}
+	if isResumed {
+		if request.LastSeq != nil {
+			room.ReplayMissedEvents(ctx, member, *request.LastSeq)
+		}
+		room.AnnounceResumedMember(ctx, member)
+	}
+}
@@ -1,130 +1,612 @@
 package controller
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 	"github.com/skamranahmed/estimatex-server/internal/api"
+	"github.com/skamranahmed/estimatex-server/internal/cluster"
+	"github.com/skamranahmed/estimatex-server/internal/codec"
+	"github.com/skamranahmed/estimatex-server/internal/config"
 	"github.com/skamranahmed/estimatex-server/internal/entity"
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+	"github.com/skamranahmed/estimatex-server/internal/metrics"
+	"github.com/skamranahmed/estimatex-server/internal/ratelimit"
 	"github.com/skamranahmed/estimatex-server/internal/session"
+	"github.com/skamranahmed/estimatex-server/internal/tracing"
+	"github.com/skamranahmed/estimatex-server/internal/transport"
+	"github.com/skamranahmed/estimatex-server/internal/webhook"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var (
-	websocketUpgrader = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
+// the transports of the connections, they are logged along with the connections
+const (
+	transportWebSocket = "websocket"
+	transportSSE       = "sse"
+	transportPoll      = "poll"
+)
+
+// roomFullMessage is sent to a client joining a room whose seats are all taken, with the id of the room
+const roomFullMessage = "😢 Room %s is full. You cannot join. Please try again later or choose a different room."
+
+// clientConnection: the connection of a client to this instance, whatever its transport
+type clientConnection interface {
+	transport.Connection
+
+	// SetCodec: picks the wire encoding of the events, it fails when the transport cannot carry the encoding
+	SetCodec(eventCodec codec.Codec) error
+}
+
+// Controller: handles the connections of the clients, over a websocket or over HTTP requests
+type Controller struct {
+	sessionManager *session.SessionManager
+
+	// cluster relays the clients to the rooms owned by the other instances of the server
+	cluster *cluster.Node
+
+	// websocketUpgrader only accepts the upgrade requests coming from the allowed origins, as do the HTTP connections
+	websocketUpgrader websocket.Upgrader
+	originChecker     *OriginChecker
+
+	// httpConnections are the connections of the clients which cannot upgrade to websocket, their messages
+	// are either streamed or polled, in which case a poll waits for pollTimeout
+	httpConnections *transport.HTTPConnections
+	pollTimeout     time.Duration
+
+	// roomWebhookHosts is the allow-list of the hosts of the room webhooks, it allows nothing when it is empty
+	roomWebhookHosts *OriginChecker
+
+	// chatWebhookHosts is the allow-list of the hosts of the chat webhooks, it allows nothing when it is empty
+	chatWebhookHosts *OriginChecker
+
+	// proxyResolver gives the IP address of the client behind the trusted proxies, on which the bans and the
+	// per IP limits apply
+	proxyResolver *ProxyResolver
+
+	// upgradeLimiter limits the websocket upgrades per source IP
+	upgradeLimiter *ratelimit.Limiter
+
+	// joinAttemptLimiter limits the failed JOIN_ROOM attempts per source IP, so that
+	// room ids, passwords and invite tokens cannot be brute forced
+	joinAttemptLimiter *ratelimit.Limiter
+
+	// activeConnections is the number of websocket connections which are currently open or being set up
+	activeConnections atomic.Int64
+
+	// draining is set when the server is shutting down, the new connections are refused while the existing ones are kept
+	draining atomic.Bool
+
+	// minProtocolVersion is the oldest version of the protocol which is served, and capabilities
+	// are the features listed in the "WELCOME" event
+	minProtocolVersion int
+	capabilities       []string
+
+	maxConnections    int
+	maxEventSize      int
+	maxMembersPerRoom int
+	maxNameLength     int
+	maxPasswordLength int
+}
+
+func New(cfg *config.Config, sessionManager *session.SessionManager, clusterNode *cluster.Node) *Controller {
+	originChecker := NewOriginChecker(cfg.AllowedOrigins)
+
+	controller := &Controller{
+		sessionManager: sessionManager,
+		cluster:        clusterNode,
+		websocketUpgrader: websocket.Upgrader{
+			CheckOrigin: originChecker.CheckOrigin,
+		},
+		originChecker:      originChecker,
+		httpConnections:    transport.NewHTTPConnections(cfg.HTTPIdleTimeout),
+		pollTimeout:        cfg.PollTimeout,
+		roomWebhookHosts:   NewOriginChecker(cfg.RoomWebhookAllowedHosts),
+		chatWebhookHosts:   NewOriginChecker(cfg.ChatWebhookAllowedHosts),
+		proxyResolver:      NewProxyResolver(cfg.TrustedProxies),
+		upgradeLimiter:     ratelimit.PerMinute(cfg.UpgradesPerMinute, cfg.UpgradesBurst),
+		joinAttemptLimiter: ratelimit.PerMinute(cfg.JoinAttemptsPerMinute, cfg.JoinAttemptsBurst),
+		minProtocolVersion: cfg.MinProtocolVersion,
+		capabilities:       serverCapabilities(cfg.Tracker != "none", sessionManager.SnapshotsEnabled()),
+		maxConnections:     cfg.MaxConnections,
+		maxEventSize:       cfg.MaxEventSize,
+		maxMembersPerRoom:  cfg.MaxMembersPerRoom,
+		maxNameLength:      cfg.MaxNameLength,
+		maxPasswordLength:  cfg.MaxPasswordLength,
 	}
 
-	sessionManager = session.DefaultManager
-)
+	// the clients connected to the other instances join the rooms of this instance through the cluster
+	clusterNode.HandleJoins(controller.admitRemoteMember)
+
+	return controller
+}
+
+// Drain: refuses all the new connections from now on, the connections which are already open are not affected
+func (c *Controller) Drain() {
+	c.draining.Store(true)
+}
+
+// ActiveConnections: returns the number of websocket connections which are currently open
+func (c *Controller) ActiveConnections() int {
+	return int(c.activeConnections.Load())
+}
+
+// RejectedUpgrades: returns the number of websocket upgrades refused because of the per IP rate limit
+func (c *Controller) RejectedUpgrades() uint64 {
+	return c.upgradeLimiter.Rejected()
+}
+
+// RejectedJoinAttempts: returns the number of JOIN_ROOM attempts refused because of too many failed attempts
+func (c *Controller) RejectedJoinAttempts() uint64 {
+	return c.joinAttemptLimiter.Rejected()
+}
+
+func (c *Controller) ServeWS(w http.ResponseWriter, r *http.Request) {
+	clientIP := c.proxyResolver.ClientIP(r)
+	connectionLogger := slog.With(logger.KeyRemoteAddr, clientIP)
+
+	ctx, span := tracing.Start(tracing.ExtractFromHeader(r.Context(), r.Header), "websocket upgrade",
+		tracing.AttributeRemoteAddr.String(clientIP),
+	)
+	defer span.End()
+
+	if !c.admitConnection(w, span, clientIP, connectionLogger) {
+		return
+	}
 
-func ServeWS(w http.ResponseWriter, r *http.Request) {
 	// upgrading the HTTP request to a websocket request
-	wsConnection, err := websocketUpgrader.Upgrade(w, r, nil)
+	wsConnection, err := c.websocketUpgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
+		connectionLogger.Warn("Unable to upgrade the connection to websocket", logger.KeyError, err)
+		metrics.WebsocketUpgradeFailures.WithLabelValues("upgrade_error").Inc()
+		c.activeConnections.Add(-1)
+		tracing.RecordError(span, tracing.ErrConnectionRefused)
 		return
 	}
 	// connection established
 
-	actionValue, clientName, err := validateRequest(r)
+	// a client sending an event larger than the limit is disconnected with the 1009 (message too big) close code
+	wsConnection.SetReadLimit(int64(c.maxEventSize))
+
+	c.connect(ctx, span, r, transport.NewWebSocket(wsConnection, clientIP), transportWebSocket, connectionLogger)
+}
+
+// admitConnection: refuses the connection when the client is rate limited, when the server is draining or when it has
+// reached its maximum number of connections, otherwise the connection takes a slot which is released once the client leaves
+func (c *Controller) admitConnection(w http.ResponseWriter, span trace.Span, clientIP string, requestLogger *slog.Logger) bool {
+	// the rate limit is applied before upgrading, so that a flood of connections costs as little as possible
+	if !c.upgradeLimiter.Allow(clientIP) {
+		requestLogger.Warn("Too many connection attempts", logger.KeyErrorCode, api.ErrorCodeTooManyRequests)
+		metrics.WebsocketUpgradeFailures.WithLabelValues("rate_limited").Inc()
+		tracing.RecordError(span, tracing.ErrConnectionRefused)
+		http.Error(w, "too many connection attempts, please try again later", http.StatusTooManyRequests)
+		return false
+	}
+
+	if c.draining.Load() {
+		requestLogger.Info("Refusing a connection while draining", logger.KeyErrorCode, api.ErrorCodeServiceUnavailable)
+		metrics.WebsocketUpgradeFailures.WithLabelValues("draining").Inc()
+		tracing.RecordError(span, tracing.ErrConnectionRefused)
+		http.Error(w, "the server is restarting, please try again in a moment", http.StatusServiceUnavailable)
+		return false
+	}
+
+	// the connection slot is taken before upgrading, so that concurrent upgrades cannot go over the limit
+	if c.activeConnections.Add(1) > int64(c.maxConnections) {
+		c.activeConnections.Add(-1)
+		requestLogger.Warn("The maximum number of connections has been reached", "max_connections", c.maxConnections, logger.KeyErrorCode, api.ErrorCodeServiceUnavailable)
+		metrics.WebsocketUpgradeFailures.WithLabelValues("max_connections").Inc()
+		tracing.RecordError(span, tracing.ErrConnectionRefused)
+		http.Error(w, "the server is at its maximum number of connections, please try again later", http.StatusServiceUnavailable)
+		return false
+	}
+
+	return true
+}
+
+// connect: creates or joins the room asked for by the client, whatever the transport of its connection. The client is
+// told why it could not be set up on its connection, which is then closed.
+func (c *Controller) connect(ctx context.Context, span trace.Span, r *http.Request, connection clientConnection, transportName string, connectionLogger *slog.Logger) {
+	// connectionLogger is the base of the member's logger, and requestLogger gets more fields
+	// attached to it as the request is validated
+	connectionLogger = connectionLogger.With("transport", transportName)
+	requestLogger := connectionLogger
+
+	clientIP := connection.RemoteAddr()
+
+	var member *entity.Member
+	var room *entity.Room
+
+	// relayed is set when the client has joined a room owned by another instance of the server
+	var relayed bool
+
+	// the connection slot is released here when the member could not be set up,
+	// otherwise it is released once the member disconnects
+	defer func() {
+		if member == nil && !relayed {
+			c.activeConnections.Add(-1)
+			tracing.RecordError(span, tracing.ErrConnectionRefused)
+		}
+	}()
+
+	actionValue, clientName, err := c.validateRequest(r, requestLogger)
+	if err != nil {
+		api.SendErrorResponse(connection, api.ErrorCodeBadRequest, err.Error())
+		return
+	}
+
+	protocolVersion, err := c.protocolVersion(r)
+	if err != nil {
+		requestLogger.Warn("Got an unsupported protocol version", logger.KeyError, err, logger.KeyErrorCode, api.ErrorCodeUnsupportedVersion)
+		api.SendErrorResponse(connection, api.ErrorCodeUnsupportedVersion, err.Error())
+		return
+	}
+
+	eventCodec, err := encoding(r, protocolVersion)
+	if err == nil {
+		err = connection.SetCodec(eventCodec)
+	}
+	if err != nil {
+		requestLogger.Warn("Got an unsupported encoding", logger.KeyError, err, logger.KeyErrorCode, api.ErrorCodeBadRequest)
+		api.SendErrorResponse(connection, api.ErrorCodeBadRequest, err.Error())
+		return
+	}
+	requestLogger = requestLogger.With("protocol_version", protocolVersion, "encoding", eventCodec.Name())
+
+	// lastSeq is the sequence number of the latest event received by a member who resumes its seat,
+	// the events it has missed since then are replayed
+	lastSeq, err := parseLastSeq(r)
 	if err != nil {
-		api.SendErrorResponse(wsConnection, err.Error())
+		requestLogger.Warn("Got invalid value for last_seq", logger.KeyError, err, logger.KeyErrorCode, api.ErrorCodeBadRequest)
+		api.SendErrorResponse(connection, api.ErrorCodeBadRequest, "invalid last_seq value provided")
 		return
 	}
 
-	// the `done` channel is used for the communication between the websocket reading and websocket writing goroutine
+	// the `done` channel is used for the communication between the reading and writing goroutines of the connection
 	// and to coordinate the termination of each other
 	done := make(chan bool)
 
-	var member *entity.Member
-	var room *entity.Room
-
 	isRoomAdmin := false
 
+	// isResumed is set when the member takes back its seat in a room restored after a restart
+	isResumed := false
+
 	if actionValue == string(session.ActionCreateRoom) {
 		maxRoomCapacityString := strings.TrimSpace(r.URL.Query().Get("max_room_capacity"))
 		maxRoomCapacityInteger, err := strconv.Atoi(maxRoomCapacityString)
 		if err != nil {
-			log.Printf("[BAD_REQUEST_ERROR]: Got invalid value for max_room_capacity, error: %+v\n", err)
-			api.SendErrorResponse(wsConnection, "invalid max_room_capacity value provided")
+			requestLogger.Warn("Got invalid value for max_room_capacity", logger.KeyError, err, logger.KeyErrorCode, api.ErrorCodeBadRequest)
+			api.SendErrorResponse(connection, api.ErrorCodeBadRequest, "invalid max_room_capacity value provided")
+			return
+		}
+
+		if maxRoomCapacityInteger < 1 || maxRoomCapacityInteger > c.maxMembersPerRoom {
+			requestLogger.Warn("Got out of range value for max_room_capacity", "max_room_capacity", maxRoomCapacityInteger, logger.KeyErrorCode, api.ErrorCodeBadRequest)
+			api.SendErrorResponse(connection, api.ErrorCodeBadRequest, fmt.Sprintf("max_room_capacity must be between 1 and %d", c.maxMembersPerRoom))
+			return
+		}
+
+		// create a new room, the password is optional
+		roomPassword := r.URL.Query().Get("password")
+		if len(roomPassword) > c.maxPasswordLength {
+			requestLogger.Warn("Got a password which is too long", "max_length", c.maxPasswordLength, logger.KeyErrorCode, api.ErrorCodeBadRequest)
+			api.SendErrorResponse(connection, api.ErrorCodeBadRequest, fmt.Sprintf("password cannot be longer than %d bytes", c.maxPasswordLength))
+			return
+		}
+
+		webhookSubscriptions, err := c.roomWebhookSubscriptions(r)
+		if err != nil {
+			requestLogger.Warn("Got an invalid room webhook", logger.KeyError, err, logger.KeyErrorCode, api.ErrorCodeBadRequest)
+			api.SendErrorResponse(connection, api.ErrorCodeBadRequest, err.Error())
 			return
 		}
 
 		// the client who creates the room is the room admin
 		isRoomAdmin = true
 
-		// create a new room
-		room = sessionManager.CreateRoom(maxRoomCapacityInteger)
+		room, err = c.sessionManager.CreateRoom(ctx, session.RoomOptions{
+			MaxCapacity:          maxRoomCapacityInteger,
+			Password:             roomPassword,
+			WebhookSubscriptions: webhookSubscriptions,
+		})
+		if errors.Is(err, session.ErrMaxRoomsReached) {
+			requestLogger.Warn("Unable to create a room", logger.KeyError, err, logger.KeyErrorCode, api.ErrorCodeServiceUnavailable)
+			api.SendErrorResponse(connection, api.ErrorCodeServiceUnavailable, "😢 The server has reached its maximum number of rooms. Please try again later.")
+			return
+		}
+		if err != nil {
+			requestLogger.Error("Unable to create a room", logger.KeyError, err, logger.KeyErrorCode, api.ErrorCodeInternal)
+			api.SendErrorResponse(connection, api.ErrorCodeInternal, "unable to create the room, please try again")
+			return
+		}
 
 		// create a new client (i.e member)
-		member = entity.NewMember(clientName, wsConnection, room.ID, isRoomAdmin)
+		member = entity.NewMember(clientName, connection, room.ID, isRoomAdmin, connectionLogger)
+		member.ProtocolVersion = protocolVersion
+		member.Logger.Info("Room created", "protocol_version", protocolVersion, "encoding", eventCodec.Name(), "max_capacity", room.MaxCapacity, "is_password_protected", room.IsPasswordProtected())
 
-		// add member to the room
+		// the admin takes the first seat of the new room, hence the room cannot be full
 		room.AddMember(member)
 	}
 
 	if actionValue == string(session.ActionJoinRoom) {
 		roomID := strings.TrimSpace(r.URL.Query().Get("room_id"))
+		requestLogger = requestLogger.With(logger.KeyRoomID, roomID)
 
-		// check if the room with the provided roomID exists or not
-		room = sessionManager.FindRoom(roomID)
-		if room == nil {
-			log.Printf("[BAD_REQUEST_ERROR]: Trying to join a room that doesn't exist")
-			errMessage := fmt.Sprintf("⚠️ Room id: %s does not exist. Please check the room id and try again.", roomID)
-			api.SendErrorResponse(wsConnection, errMessage)
+		// a source IP which has made too many failed attempts to join a room is blocked for a while
+		if c.joinAttemptLimiter.Blocked(clientIP) {
+			requestLogger.Warn("Too many failed attempts to join a room", logger.KeyErrorCode, api.ErrorCodeTooManyRequests)
+			api.SendErrorResponse(connection, api.ErrorCodeTooManyRequests, "⛔ Too many failed attempts to join a room. Please wait a minute and try again.")
 			return
 		}
 
-		/*
-			if the room exists, add the member (client) to the room
-			but if the room's max capacity has already been reached,
-			then we must NOT add the member to the room, rather throw an error
-		*/
-		if room.GetRoomMembersCount() >= room.MaxCapacity {
-			log.Printf("[BAD_REQUEST_ERROR]: Trying to join a room that is already at maximum capacity")
-			errMessage := fmt.Sprintf("😢 Room %s is full. You cannot join. Please try again later or choose a different room.", roomID)
-			api.SendErrorResponse(wsConnection, errMessage)
-			return
+		room = c.sessionManager.FindRoom(roomID)
+		if room == nil {
+			// the room may be owned by another instance of the server, in which case the client is relayed to it
+			ownerNodeID, err := c.cluster.RoomOwner(ctx, roomID)
+			if err != nil {
+				requestLogger.Error("Unable to find the owner of the room", logger.KeyError, err, logger.KeyErrorCode, api.ErrorCodeServiceUnavailable)
+				api.SendErrorResponse(connection, api.ErrorCodeServiceUnavailable, "unable to join the room, please try again")
+				return
+			}
+
+			// the owner of the room may be gone, in which case this instance takes the room over from its snapshot
+			if ownerNodeID == "" {
+				room, err = c.sessionManager.TakeOverRoom(ctx, roomID)
+				if err != nil {
+					requestLogger.Warn("Unable to take over the room", logger.KeyError, err, logger.KeyErrorCode, api.ErrorCodeServiceUnavailable)
+					api.SendErrorResponse(connection, api.ErrorCodeServiceUnavailable, "unable to join the room, please try again")
+					return
+				}
+			}
+
+			if ownerNodeID != "" && ownerNodeID != c.cluster.ID() {
+				relayed = c.joinRemoteRoom(ctx, r, connection, ownerNodeID, cluster.JoinRequest{
+					RoomID:   roomID,
+					Name:     clientName,
+					RemoteIP: clientIP,
+
+					ProtocolVersion: protocolVersion,
+					LastSeq:         lastSeq,
+				}, requestLogger)
+				return
+			}
 		}
 
-		// create a new client (i.e member)
-		member = entity.NewMember(clientName, wsConnection, roomID, isRoomAdmin)
+		// a member of a room restored after a restart takes back its seat with its resume token
+		memberID := strings.TrimSpace(r.URL.Query().Get("member_id"))
+		resumeToken := strings.TrimSpace(r.URL.Query().Get("resume_token"))
+		if resumeToken != "" {
+			detachedMember, errorCode, errMessage := c.checkResume(room, roomID, memberID, clientIP, func(room *entity.Room) (*entity.DetachedMember, error) {
+				return room.ResumeMember(memberID, resumeToken)
+			}, requestLogger)
+			if errorCode != "" {
+				api.SendErrorResponse(connection, errorCode, errMessage)
+				return
+			}
 
+			member = entity.NewResumedMember(detachedMember, connection, roomID, connectionLogger)
+			isResumed = true
+		} else {
+			password := r.URL.Query().Get("password")
+			inviteToken := strings.TrimSpace(r.URL.Query().Get("invite_token"))
+			errorCode, errMessage := c.checkJoin(room, roomID, clientIP, func(room *entity.Room) error {
+				return room.Authorize(password, inviteToken)
+			}, requestLogger)
+			if errorCode != "" {
+				api.SendErrorResponse(connection, errorCode, errMessage)
+				return
+			}
+
+			// create a new client (i.e member)
+			member = entity.NewMember(clientName, connection, roomID, isRoomAdmin, connectionLogger)
+		}
+		member.ProtocolVersion = protocolVersion
 		// add member to the room
-		room.AddMember(member)
+		if !c.seatMember(room, member, connection, requestLogger) {
+			return
+		}
+		member.Logger.Info("Member connected to the room", "resumed", isResumed, "protocol_version", protocolVersion, "encoding", eventCodec.Name())
 	}
 
+	span.SetAttributes(tracing.AttributeRoomID.String(room.ID), tracing.AttributeMemberID.String(member.ID))
+
+	memberRole := metrics.MemberRole(member.IsRoomAdmin)
+	metrics.ConnectedMembers.WithLabelValues(memberRole).Inc()
+	metrics.ProtocolVersionConnections.WithLabelValues(strconv.Itoa(protocolVersion)).Inc()
+
 	// start a go routine which would continuously read messages from the client (member)
-	go member.ReadMessages(room, done)
+	go func() {
+		member.ReadMessages(room, done)
+
+		// the member has disconnected, and the room is closed when its admin disconnects
+		c.activeConnections.Add(-1)
+		metrics.ConnectedMembers.WithLabelValues(memberRole).Dec()
+		if member.IsRoomAdmin {
+			c.sessionManager.RemoveRoom(room)
+		}
+	}()
 
 	// start a go routine which would write messages to the client (member)
 	go member.WriteMessages(done)
 
+	c.sendWelcome(ctx, member)
+
 	if actionValue == string(session.ActionCreateRoom) {
 		// inform the client (member) that the room has been created
-		member.SendCreateRoomEvent(room.ID)
+		member.SendCreateRoomEvent(ctx, room.ID)
+	}
+
+	if c.sessionManager.SnapshotsEnabled() {
+		member.SendResumeTokenEvent(ctx)
+	}
+
+	if isResumed {
+		if lastSeq != nil {
+			room.ReplayMissedEvents(ctx, member, *lastSeq)
+		}
+		room.AnnounceResumedMember(ctx, member)
 	}
 
 	return
 }
 
-func validateRequest(r *http.Request) (actionValue string, clientName string, err error) {
+// checkResume: gives the seat of a member of a room restored after a restart back to the client, resume checks
+// the resume token of the client and claims the seat. It returns the seat of the member when the token is valid,
+// otherwise the error sent to the client.
+func (c *Controller) checkResume(room *entity.Room, roomID string, memberID string, clientIP string, resume func(room *entity.Room) (*entity.DetachedMember, error), requestLogger *slog.Logger) (*entity.DetachedMember, api.ErrorCode, string) {
+	if room == nil {
+		requestLogger.Warn("Trying to resume a seat in a room that doesn't exist", logger.KeyErrorCode, api.ErrorCodeRoomNotFound)
+		c.joinAttemptLimiter.Allow(clientIP)
+		return nil, api.ErrorCodeRoomNotFound, fmt.Sprintf("⚠️ Room id: %s does not exist anymore.", roomID)
+	}
+
+	detachedMember, err := resume(room)
+	if err != nil {
+		requestLogger.Warn("Trying to resume a seat with an invalid resume token", logger.KeyMemberID, memberID, logger.KeyError, err, logger.KeyErrorCode, api.ErrorCodeUnauthorized)
+		c.joinAttemptLimiter.Allow(clientIP)
+		return nil, api.ErrorCodeUnauthorized, fmt.Sprintf("🔒 Unable to resume your seat in the room %s: %s.", roomID, err.Error())
+	}
+
+	return detachedMember, "", ""
+}
+
+// checkJoin: checks whether the client can join the room, the room is nil when it does not exist and authorize checks
+// the credentials of the client. It returns an empty error code when the client can join, otherwise the error sent to the client.
+func (c *Controller) checkJoin(room *entity.Room, roomID string, clientIP string, authorize func(room *entity.Room) error, requestLogger *slog.Logger) (api.ErrorCode, string) {
+	if room == nil {
+		requestLogger.Warn("Trying to join a room that doesn't exist", logger.KeyErrorCode, api.ErrorCodeRoomNotFound)
+		c.joinAttemptLimiter.Allow(clientIP)
+		return api.ErrorCodeRoomNotFound, fmt.Sprintf("⚠️ Room id: %s does not exist. Please check the room id and try again.", roomID)
+	}
+
+	// the client must provide either the room password or a valid invite token, if the room is protected
+	err := authorize(room)
+	if err != nil {
+		requestLogger.Warn("Trying to join a room with invalid credentials", logger.KeyError, err, logger.KeyErrorCode, api.ErrorCodeUnauthorized)
+		c.joinAttemptLimiter.Allow(clientIP)
+		return api.ErrorCodeUnauthorized, fmt.Sprintf("🔒 Unable to join the room %s: %s. Please check the password or ask the admin for a new invite.", roomID, err.Error())
+	}
+
+	// a client banned by the admin cannot join the room again, whatever its credentials
+	if room.IsBanned(clientIP) {
+		requestLogger.Warn("Trying to join a room from a banned IP", logger.KeyErrorCode, api.ErrorCodeForbidden)
+		return api.ErrorCodeForbidden, fmt.Sprintf("⛔ You have been banned from the room %s.", roomID)
+	}
+
+	/*
+		if the room exists, add the member (client) to the room
+		but if the room's max capacity has already been reached,
+		then we must NOT add the member to the room, rather throw an error
+	*/
+	if room.GetRoomMembersCount()+room.DetachedMembersCount() >= room.MaxCapacity {
+		requestLogger.Warn("Trying to join a room that is already at maximum capacity", logger.KeyErrorCode, api.ErrorCodeRoomFull)
+		return api.ErrorCodeRoomFull, fmt.Sprintf(roomFullMessage, roomID)
+	}
+
+	return "", ""
+}
+
+// seatMember: adds the member to the room, the room may have filled up since checkJoin when clients join at the same
+// time, in which case the client is told so and false is returned
+func (c *Controller) seatMember(room *entity.Room, member *entity.Member, connection transport.Connection, requestLogger *slog.Logger) bool {
+	err := room.AddMember(member)
+	if errors.Is(err, entity.ErrRoomFull) {
+		requestLogger.Warn("The room has reached its maximum capacity while joining it", logger.KeyErrorCode, api.ErrorCodeRoomFull)
+		api.SendErrorResponse(connection, api.ErrorCodeRoomFull, fmt.Sprintf(roomFullMessage, room.ID))
+		return false
+	}
+	return true
+}
+
+func (c *Controller) validateRequest(r *http.Request, requestLogger *slog.Logger) (actionValue string, clientName string, err error) {
 	actionValue = strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("action")))
 
 	if !session.IsActionValid(actionValue) {
-		log.Printf("[BAD_REQUEST_ERROR]: Got invalid action value: %+v\n", actionValue)
+		requestLogger.Warn("Got invalid action value", "action", actionValue, logger.KeyErrorCode, api.ErrorCodeBadRequest)
 		return "", "", fmt.Errorf("invalid action value: %s", actionValue)
 	}
 
 	clientName = strings.TrimSpace(r.URL.Query().Get("name"))
 	if clientName == "" {
-		log.Printf("[BAD_REQUEST_ERROR]: Got an empty client name\n")
+		requestLogger.Warn("Got an empty client name", logger.KeyErrorCode, api.ErrorCodeBadRequest)
 		return "", "", fmt.Errorf("name cannot be empty")
 	}
 
+	if utf8.RuneCountInString(clientName) > c.maxNameLength {
+		requestLogger.Warn("Got a client name which is too long", "max_length", c.maxNameLength, logger.KeyErrorCode, api.ErrorCodeBadRequest)
+		return "", "", fmt.Errorf("name cannot be longer than %d characters", c.maxNameLength)
+	}
+
 	return actionValue, clientName, nil
 }
+
+// parseLastSeq: returns nil when the last_seq query parameter is not set
+func parseLastSeq(r *http.Request) (*uint64, error) {
+	lastSeqString := strings.TrimSpace(r.URL.Query().Get("last_seq"))
+	if lastSeqString == "" {
+		return nil, nil
+	}
+
+	lastSeq, err := strconv.ParseUint(lastSeqString, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &lastSeq, nil
+}
+
+// roomWebhookSubscriptions: reads the optional webhook of a new room, its URL must be allowed by the server
+// and a secret must be provided to sign the deliveries. The optional chat webhook of the room is read as well.
+func (c *Controller) roomWebhookSubscriptions(r *http.Request) ([]webhook.Subscription, error) {
+	var subscriptions []webhook.Subscription
+
+	webhookURL := strings.TrimSpace(r.URL.Query().Get("webhook_url"))
+	if webhookURL != "" {
+		err := checkWebhookURL("webhook_url", webhookURL, c.roomWebhookHosts)
+		if err != nil {
+			return nil, err
+		}
+
+		webhookSecret := r.URL.Query().Get("webhook_secret")
+		if webhookSecret == "" {
+			return nil, fmt.Errorf("webhook_secret is required with webhook_url")
+		}
+
+		subscriptions = append(subscriptions, webhook.Subscription{URL: webhookURL, Secret: webhookSecret})
+	}
+
+	chatWebhookURL := strings.TrimSpace(r.URL.Query().Get("chat_webhook_url"))
+	if chatWebhookURL != "" {
+		err := checkWebhookURL("chat_webhook_url", chatWebhookURL, c.chatWebhookHosts)
+		if err != nil {
+			return nil, err
+		}
+
+		subscriptions = append(subscriptions, webhook.Subscription{URL: chatWebhookURL, Format: webhook.FormatChat})
+	}
+
+	return subscriptions, nil
+}
+
+// checkWebhookURL: a webhook URL must be an http or https URL whose host is allowed by the server
+func checkWebhookURL(parameterName string, webhookURL string, allowedHosts *OriginChecker) error {
+	parsedWebhookURL, err := url.ParseRequestURI(webhookURL)
+	if err != nil || (parsedWebhookURL.Scheme != "http" && parsedWebhookURL.Scheme != "https") {
+		return fmt.Errorf("%s must be an http or https URL", parameterName)
+	}
+
+	if !allowedHosts.Allows(parsedWebhookURL) {
+		return fmt.Errorf("%s is not allowed on this server", parameterName)
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+	"github.com/skamranahmed/estimatex-server/internal/tracing"
+	"github.com/skamranahmed/estimatex-server/internal/transport"
+)
+
+// ServeSSE: opens a connection whose messages are written to a Server-Sent Events stream, for the clients which cannot
+// upgrade to websocket. It takes the query parameters of the websocket endpoint, and the first event of the stream
+// carries the id of the connection with which the client posts its events.
+func (c *Controller) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	connection := c.openHTTPConnection(w, r, transportSSE)
+	if connection == nil {
+		return
+	}
+
+	err := connection.ServeStream(w, r)
+	if err != nil {
+		slog.Debug("The event stream has stopped", logger.KeyRemoteAddr, c.proxyResolver.ClientIP(r), logger.KeyError, err)
+	}
+
+	// the client has gone away when the stream stops before the connection is closed
+	connection.Close(transport.CloseGoingAway, "the event stream has stopped")
+}
+
+// OpenPolledConnection: opens a connection whose messages are polled, for the clients which can neither upgrade to
+// websocket nor read an event stream. It takes the query parameters of the websocket endpoint.
+func (c *Controller) OpenPolledConnection(w http.ResponseWriter, r *http.Request) {
+	connection := c.openHTTPConnection(w, r, transportPoll)
+	if connection == nil {
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{"connection_id": connection.ID})
+}
+
+// PollMessages: returns the messages of the connection, it waits for them up to the poll timeout
+func (c *Controller) PollMessages(w http.ResponseWriter, r *http.Request) {
+	connection := c.findHTTPConnection(w, r)
+	if connection == nil {
+		return
+	}
+
+	result, err := connection.Poll(r.Context(), c.pollTimeout)
+	if errors.Is(err, transport.ErrReaderBusy) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// PostEvent: hands an event of the client to its room, the event is written in JSON as it would be on a websocket
+func (c *Controller) PostEvent(w http.ResponseWriter, r *http.Request) {
+	connection := c.findHTTPConnection(w, r)
+	if connection == nil {
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(c.maxEventSize)))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			http.Error(w, "the event is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "unable to read the event", http.StatusBadRequest)
+		return
+	}
+
+	err = connection.Deliver(r.Context(), payload)
+	if errors.Is(err, transport.ErrClosed) {
+		http.Error(w, "the connection is closed", http.StatusGone)
+		return
+	}
+	if err != nil {
+		// the client has gone away while the room was busy
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// CloseHTTPConnection: the client leaves its room, as it would by closing its websocket
+func (c *Controller) CloseHTTPConnection(w http.ResponseWriter, r *http.Request) {
+	connection := c.findHTTPConnection(w, r)
+	if connection == nil {
+		return
+	}
+
+	connection.Close(transport.CloseNormalClosure, "Client closing connection")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PreflightHTTPConnection: lets the browsers of the allowed origins post their events with a JSON content type
+func (c *Controller) PreflightHTTPConnection(w http.ResponseWriter, r *http.Request) {
+	if !c.allowOrigin(w, r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// openHTTPConnection: goes through the same checks as a websocket upgrade, and then sets the client up in the background
+// while the messages are read, so that the messages sent meanwhile do not fill the outbox of the connection.
+// It returns nil when the connection has been refused, in which case the response has been written.
+func (c *Controller) openHTTPConnection(w http.ResponseWriter, r *http.Request, transportName string) *transport.HTTPConnection {
+	clientIP := c.proxyResolver.ClientIP(r)
+	connectionLogger := slog.With(logger.KeyRemoteAddr, clientIP)
+
+	if !c.allowOrigin(w, r) {
+		connectionLogger.Warn("Refusing an HTTP connection from an origin which is not allowed", "origin", r.Header.Get("Origin"))
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return nil
+	}
+
+	// a polled connection outlives the request which opens it
+	ctx, span := tracing.Start(tracing.ExtractFromHeader(context.WithoutCancel(r.Context()), r.Header), "http connection",
+		tracing.AttributeRemoteAddr.String(clientIP),
+	)
+
+	if !c.admitConnection(w, span, clientIP, connectionLogger) {
+		span.End()
+		return nil
+	}
+
+	connection := c.httpConnections.Open(clientIP)
+	request := r.Clone(ctx)
+
+	go func() {
+		defer span.End()
+		c.connect(ctx, span, request, connection, transportName, connectionLogger)
+	}()
+
+	return connection
+}
+
+// findHTTPConnection: returns nil when the connection does not exist, in which case the response has been written
+func (c *Controller) findHTTPConnection(w http.ResponseWriter, r *http.Request) *transport.HTTPConnection {
+	if !c.allowOrigin(w, r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return nil
+	}
+
+	connection := c.httpConnections.Find(r.PathValue("connection_id"))
+	if connection == nil {
+		http.Error(w, "unknown connection", http.StatusNotFound)
+		return nil
+	}
+	return connection
+}
+
+// allowOrigin: the browsers are only let through from the allowed origins, like for the websocket upgrades,
+// and they are allowed to read the responses
+func (c *Controller) allowOrigin(w http.ResponseWriter, r *http.Request) bool {
+	if !c.originChecker.CheckOrigin(r) {
+		return false
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OriginChecker: decides whether a websocket upgrade request is allowed based on its Origin header.
+//
+// The allow-list entries can be:
+//   - `*` which allows every origin
+//   - a full origin like `https://estimatex.dev`, which must match the scheme, host and port
+//   - a wildcard subdomain like `https://*.example.com`, which matches any subdomain of example.com (but not example.com itself)
+//   - a host without a scheme like `example.com` or `*.example.com`, which matches the host for any scheme
+type OriginChecker struct {
+	allowAll bool
+	patterns []originPattern
+}
+
+type originPattern struct {
+	// scheme is empty when the pattern matches any scheme
+	scheme string
+	host   string
+	// wildcard is true when the pattern matches the subdomains of the host
+	wildcard bool
+}
+
+func NewOriginChecker(allowedOrigins []string) *OriginChecker {
+	checker := &OriginChecker{}
+
+	for _, allowedOrigin := range allowedOrigins {
+		allowedOrigin = strings.ToLower(strings.TrimSpace(allowedOrigin))
+		if allowedOrigin == "" {
+			continue
+		}
+
+		if allowedOrigin == "*" {
+			checker.allowAll = true
+			continue
+		}
+
+		pattern := originPattern{host: allowedOrigin}
+		if scheme, host, found := strings.Cut(allowedOrigin, "://"); found {
+			pattern.scheme = scheme
+			pattern.host = host
+		}
+		pattern.host = strings.TrimSuffix(pattern.host, "/")
+
+		if strings.HasPrefix(pattern.host, "*.") {
+			pattern.wildcard = true
+			pattern.host = strings.TrimPrefix(pattern.host, "*")
+		}
+
+		checker.patterns = append(checker.patterns, pattern)
+	}
+
+	return checker
+}
+
+// CheckOrigin: matches the signature of websocket.Upgrader.CheckOrigin
+func (c *OriginChecker) CheckOrigin(r *http.Request) bool {
+	if c.allowAll {
+		return true
+	}
+
+	originHeader := r.Header.Get("Origin")
+	if originHeader == "" {
+		// non browser clients (like the estimatex CLI) do not send the Origin header
+		return true
+	}
+
+	origin, err := url.Parse(strings.ToLower(originHeader))
+	if err != nil {
+		return false
+	}
+
+	return c.Allows(origin)
+}
+
+// Allows: matches the scheme and the host of a URL against the allow-list, it is also used
+// to check the URLs of the room webhooks
+func (c *OriginChecker) Allows(target *url.URL) bool {
+	if c.allowAll {
+		return true
+	}
+
+	if target.Host == "" {
+		return false
+	}
+
+	target = &url.URL{Scheme: strings.ToLower(target.Scheme), Host: strings.ToLower(target.Host)}
+	for _, pattern := range c.patterns {
+		if pattern.matches(target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p originPattern) matches(origin *url.URL) bool {
+	if p.scheme != "" && p.scheme != origin.Scheme {
+		return false
+	}
+
+	if p.wildcard {
+		// p.host is `.example.com` here, so a bare `example.com` never matches a wildcard pattern
+		return strings.HasSuffix(origin.Host, p.host)
+	}
+
+	return origin.Host == p.host
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/skamranahmed/estimatex-server/internal/codec"
+	"github.com/skamranahmed/estimatex-server/internal/entity"
+	"github.com/skamranahmed/estimatex-server/internal/event"
+	"github.com/skamranahmed/estimatex-server/internal/health"
+)
+
+// protocolVersion: reads the version of the protocol spoken by the client, the clients which do not send it
+// are the ones which were built before the protocol was versioned, hence they speak the first version
+func (c *Controller) protocolVersion(r *http.Request) (int, error) {
+	protocolVersionString := strings.TrimSpace(r.URL.Query().Get("protocol_version"))
+	if protocolVersionString == "" {
+		return event.ProtocolVersion1, nil
+	}
+
+	protocolVersion, err := strconv.Atoi(protocolVersionString)
+	if err != nil {
+		return 0, fmt.Errorf("invalid protocol_version value: %q", protocolVersionString)
+	}
+
+	return protocolVersion, c.checkProtocolVersion(protocolVersion)
+}
+
+// checkProtocolVersion: the versions older than the minimum one have reached the end of their deprecation window
+func (c *Controller) checkProtocolVersion(protocolVersion int) error {
+	if protocolVersion < c.minProtocolVersion || protocolVersion > event.LatestProtocolVersion {
+		return fmt.Errorf("protocol version %d is not supported, the server supports the versions %d to %d, please upgrade your client",
+			protocolVersion, c.minProtocolVersion, event.LatestProtocolVersion)
+	}
+	return nil
+}
+
+// encoding: reads the wire encoding picked by the client, the clients of the first version of the protocol
+// only speak JSON
+func encoding(r *http.Request, protocolVersion int) (codec.Codec, error) {
+	encodingName := strings.TrimSpace(r.URL.Query().Get("encoding"))
+
+	eventCodec, ok := codec.ByName(encodingName)
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %q, the supported encodings are %s and %s", encodingName, codec.NameJSON, codec.NameMessagePack)
+	}
+
+	if eventCodec != codec.JSON && protocolVersion < event.ProtocolVersion2 {
+		return nil, fmt.Errorf("the %s encoding requires the version %d of the protocol or a later one", eventCodec.Name(), event.ProtocolVersion2)
+	}
+	return eventCodec, nil
+}
+
+// sendWelcome: tells the client which versions of the protocol and which features the server supports,
+// the clients of the first version of the protocol do not know the "WELCOME" event, hence they do not get it
+func (c *Controller) sendWelcome(ctx context.Context, member *entity.Member) {
+	if member.ProtocolVersion < event.ProtocolVersion2 {
+		return
+	}
+
+	supportedProtocolVersions := event.SupportedProtocolVersions(c.minProtocolVersion)
+
+	member.SendWelcomeEvent(ctx, event.WelcomeEventData{
+		ProtocolVersion:            member.ProtocolVersion,
+		SupportedProtocolVersions:  supportedProtocolVersions,
+		DeprecatedProtocolVersions: supportedProtocolVersions[:len(supportedProtocolVersions)-1],
+		ServerVersion:              health.Version,
+		Capabilities:               c.capabilities,
+	})
+}
+
+// serverCapabilities: the features which depend on the configuration are only listed when they are enabled
+func serverCapabilities(trackerEnabled bool, snapshotsEnabled bool) []string {
+	capabilities := []string{event.CapabilityInvites, event.CapabilityModeration, event.CapabilityFinalEstimate, event.CapabilityReplay, event.CapabilityRoomState, event.CapabilityMessagePack}
+	if trackerEnabled {
+		capabilities = append(capabilities, event.CapabilityIssueTracker)
+	}
+	if snapshotsEnabled {
+		capabilities = append(capabilities, event.CapabilityResume)
+	}
+	return capabilities
+}
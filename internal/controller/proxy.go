@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ProxyResolver: finds the IP address of the client of a request, through the reverse proxies which are trusted.
+//
+// The X-Forwarded-For header is only read when the request comes from a trusted proxy, since any client can set
+// it. The addresses of the header are read from the right, each one being appended by the proxy it went through,
+// and the first address which is not a trusted proxy is the client. A header which only holds trusted proxies
+// gives its leftmost address.
+type ProxyResolver struct {
+	trustedProxies []netip.Prefix
+}
+
+func NewProxyResolver(trustedProxies []netip.Prefix) *ProxyResolver {
+	return &ProxyResolver{trustedProxies: trustedProxies}
+}
+
+// ClientIP: returns the IP address of the client of the request, without the port
+func (p *ProxyResolver) ClientIP(r *http.Request) string {
+	peerIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		peerIP = host
+	}
+
+	if !p.trusts(peerIP) {
+		return peerIP
+	}
+
+	var forwardedIPs []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, forwardedIP := range strings.Split(header, ",") {
+			forwardedIPs = append(forwardedIPs, strings.TrimSpace(forwardedIP))
+		}
+	}
+
+	clientIP := peerIP
+	for i := len(forwardedIPs) - 1; i >= 0; i-- {
+		address, err := netip.ParseAddr(forwardedIPs[i])
+		if err != nil {
+			// the header has been mangled before reaching the trusted proxies, its remaining addresses cannot be trusted
+			return clientIP
+		}
+
+		clientIP = address.Unmap().WithZone("").String()
+		if !p.trusts(clientIP) {
+			return clientIP
+		}
+	}
+	return clientIP
+}
+
+// trusts: reports whether the IP address belongs to a trusted proxy
+func (p *ProxyResolver) trusts(ip string) bool {
+	if len(p.trustedProxies) == 0 {
+		return false
+	}
+
+	address, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	address = address.Unmap().WithZone("")
+	for _, trustedProxy := range p.trustedProxies {
+		if trustedProxy.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package controller
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestTheClientIPIsReadFromTheTrustedProxies(t *testing.T) {
+	trustedProxies := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::1/128"),
+	}
+
+	testCases := []struct {
+		name           string
+		trustedProxies []netip.Prefix
+		remoteAddr     string
+		forwardedFor   []string
+		want           string
+	}{
+		{name: "no trusted proxy", remoteAddr: "10.0.0.1:5000", forwardedFor: []string{"203.0.113.7"}, want: "10.0.0.1"},
+		{name: "untrusted peer", trustedProxies: trustedProxies, remoteAddr: "198.51.100.1:5000", forwardedFor: []string{"203.0.113.7"}, want: "198.51.100.1"},
+		{name: "trusted peer without header", trustedProxies: trustedProxies, remoteAddr: "10.0.0.1:5000", want: "10.0.0.1"},
+		{name: "trusted peer", trustedProxies: trustedProxies, remoteAddr: "10.0.0.1:5000", forwardedFor: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "trusted ipv6 peer", trustedProxies: trustedProxies, remoteAddr: "[2001:db8::1]:5000", forwardedFor: []string{"2001:db8::7"}, want: "2001:db8::7"},
+		{name: "chain of trusted proxies", trustedProxies: trustedProxies, remoteAddr: "10.0.0.1:5000", forwardedFor: []string{"203.0.113.7, 10.1.0.1", "10.2.0.1"}, want: "203.0.113.7"},
+		{name: "address spoofed by the client", trustedProxies: trustedProxies, remoteAddr: "10.0.0.1:5000", forwardedFor: []string{"192.0.2.66, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "only trusted proxies", trustedProxies: trustedProxies, remoteAddr: "10.0.0.1:5000", forwardedFor: []string{"10.3.0.1, 10.2.0.1"}, want: "10.3.0.1"},
+		{name: "mangled header", trustedProxies: trustedProxies, remoteAddr: "10.0.0.1:5000", forwardedFor: []string{"203.0.113.7, unknown, 10.2.0.1"}, want: "10.2.0.1"},
+		{name: "ipv4 mapped address", trustedProxies: trustedProxies, remoteAddr: "[::ffff:10.0.0.1]:5000", forwardedFor: []string{"::ffff:203.0.113.7"}, want: "203.0.113.7"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws", nil)
+			r.RemoteAddr = testCase.remoteAddr
+			for _, forwardedFor := range testCase.forwardedFor {
+				r.Header.Add("X-Forwarded-For", forwardedFor)
+			}
+
+			clientIP := NewProxyResolver(testCase.trustedProxies).ClientIP(r)
+			if clientIP != testCase.want {
+				t.Fatalf("got %s, want %s", clientIP, testCase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package entity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/skamranahmed/estimatex-server/internal/event"
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+)
+
+const (
+	// maxEventIDLength is the maximum number of bytes of the id of an incoming event
+	maxEventIDLength = 64
+
+	// acknowledgedEventIDsSize is the number of ids of the acknowledged events a member keeps, a client
+	// retrying one of them gets the acknowledgement again without the event being handled twice
+	acknowledgedEventIDsSize = 64
+)
+
+// eventReply: the outcome of an incoming event which has an id, it is carried by the context of the event so that
+// the "ERROR" and "RATE_LIMITED" events sent to its sender carry the id, and the event is only acknowledged
+// when it has not been rejected
+type eventReply struct {
+	id       string
+	memberID string
+	rejected bool
+}
+
+type eventReplyKey struct{}
+
+func withEventReply(ctx context.Context, member *Member, eventID string) (context.Context, *eventReply) {
+	reply := &eventReply{id: eventID, memberID: member.ID}
+	return context.WithValue(ctx, eventReplyKey{}, reply), reply
+}
+
+// rejectEvent: marks the event the member is being replied to as rejected, and returns its id. The events
+// broadcast while handling an event share its context, hence the other members are ignored.
+func (m *Member) rejectEvent(ctx context.Context) string {
+	reply, ok := ctx.Value(eventReplyKey{}).(*eventReply)
+	if !ok || reply.memberID != m.ID {
+		return ""
+	}
+
+	reply.rejected = true
+	return reply.id
+}
+
+// handleEvent: hands the event to the room, and acknowledges it when the client has set its id and it has been
+// accepted. The ids of the acknowledged events are remembered, so that a client can safely send an event again
+// when it has not received the acknowledgement.
+func (m *Member) handleEvent(ctx context.Context, room *Room, receivedEvent event.Event) error {
+	if receivedEvent.ID == "" {
+		return room.HandleEvent(ctx, m, receivedEvent)
+	}
+
+	if len(receivedEvent.ID) > maxEventIDLength {
+		m.Logger.Warn("Got a field which is too long", logger.KeyEventType, receivedEvent.Type, "field", "id", "max_length", maxEventIDLength, logger.KeyErrorCode, event.ErrorCodeFieldTooLong)
+		m.SendErrorEvent(ctx, event.ErrorCodeFieldTooLong, receivedEvent.Type, fmt.Sprintf("⚠️ id cannot be longer than %d bytes", maxEventIDLength))
+		return nil
+	}
+
+	if _, ok := m.acknowledgedEventIDs[receivedEvent.ID]; ok {
+		m.SendAckEvent(ctx, receivedEvent, true)
+		return nil
+	}
+
+	ctx, reply := withEventReply(ctx, m, receivedEvent.ID)
+	err := room.HandleEvent(ctx, m, receivedEvent)
+	if err != nil || reply.rejected {
+		return err
+	}
+
+	m.rememberAcknowledgedEvent(receivedEvent.ID)
+	m.SendAckEvent(ctx, receivedEvent, false)
+	return nil
+}
+
+// rememberAcknowledgedEvent: the oldest id is forgotten once the member has reached acknowledgedEventIDsSize ids,
+// the ids are only used by the read go-routine of the member, hence they are not guarded
+func (m *Member) rememberAcknowledgedEvent(eventID string) {
+	if m.acknowledgedEventIDs == nil {
+		m.acknowledgedEventIDs = make(map[string]struct{}, acknowledgedEventIDsSize)
+	}
+
+	if len(m.acknowledgedEventIDsOrder) == acknowledgedEventIDsSize {
+		delete(m.acknowledgedEventIDs, m.acknowledgedEventIDsOrder[0])
+		m.acknowledgedEventIDsOrder = m.acknowledgedEventIDsOrder[1:]
+	}
+
+	m.acknowledgedEventIDs[eventID] = struct{}{}
+	m.acknowledgedEventIDsOrder = append(m.acknowledgedEventIDsOrder, eventID)
+}
+
+func (m *Member) SendAckEvent(ctx context.Context, acknowledgedEvent event.Event, duplicate bool) {
+	ackEvent := event.AckEventData{
+		ID:        acknowledgedEvent.ID,
+		EventType: acknowledgedEvent.Type,
+		Duplicate: duplicate,
+	}
+	ackEventJsonData, _ := json.Marshal(ackEvent)
+	eventToBeSent := event.Event{
+		Type: string(event.EventAck),
+		Data: json.RawMessage(ackEventJsonData),
+	}
+	m.sendEvent(ctx, eventToBeSent)
+}
@@ -0,0 +1,88 @@
+package entity
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/skamranahmed/estimatex-server/internal/event"
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+	"github.com/skamranahmed/estimatex-server/internal/tracing"
+)
+
+// BroadcastFilter: selects the members of the room who receive a broadcast event, every member receives it when the filter is nil
+type BroadcastFilter func(member *Member) bool
+
+// Broadcast: sends the event to the members of the room selected by the filter. The data is marshalled once and
+// converted once to each wire encoding spoken by the members, only the envelope, which carries the numbers of the
+// event, is written for every member. The event is kept once in the log of the room.
+func (r *Room) Broadcast(ctx context.Context, eventType event.EventType, data any, filter BroadcastFilter) {
+	r.broadcastTo(ctx, r.GetMembers(), eventType, r.sharedPayload(eventType, data), filter)
+}
+
+// broadcastTo: sends the event to the given members selected by the filter, the payload of a member is looked up by
+// the version of the protocol the member speaks. The members who get the same payload get the same event.
+func (r *Room) broadcastTo(ctx context.Context, members []*Member, eventType event.EventType, payloadOf func(protocolVersion int) *event.SharedData, filter BroadcastFilter) {
+	recipients := members
+	if filter != nil {
+		recipients = make([]*Member, 0, len(members))
+		for _, member := range members {
+			if filter(member) {
+				recipients = append(recipients, member)
+			}
+		}
+	}
+
+	broadcastCtx, broadcastSpan := tracing.StartBroadcast(ctx, r.ID, string(eventType), len(recipients))
+	defer broadcastSpan.End()
+
+	// the payloads are kept in the order of their first recipient, so that the events are numbered in a stable order
+	var payloads []*event.SharedData
+	recipientsOf := make(map[*event.SharedData][]*Member, 1)
+	for _, recipient := range recipients {
+		payload := payloadOf(recipient.ProtocolVersion)
+		if _, ok := recipientsOf[payload]; !ok {
+			payloads = append(payloads, payload)
+		}
+		recipientsOf[payload] = append(recipientsOf[payload], recipient)
+	}
+
+	traceID := tracing.TraceID(broadcastCtx)
+	for _, payload := range payloads {
+		r.Events.send(recipientsOf[payload], string(eventType), payload, traceID)
+	}
+}
+
+// sharedPayload: the data is the same for every version of the protocol
+func (r *Room) sharedPayload(eventType event.EventType, data any) func(protocolVersion int) *event.SharedData {
+	payload := event.NewSharedData(r.marshalPayload(eventType, data))
+	return func(int) *event.SharedData {
+		return payload
+	}
+}
+
+// versionedPayload: the data of a version is marshalled the first time a member speaking it is sent the event
+func (r *Room) versionedPayload(eventType event.EventType, dataOf func(protocolVersion int) any) func(protocolVersion int) *event.SharedData {
+	payloads := make(map[int]*event.SharedData, 2)
+	return func(protocolVersion int) *event.SharedData {
+		payload, ok := payloads[protocolVersion]
+		if !ok {
+			payload = event.NewSharedData(r.marshalPayload(eventType, dataOf(protocolVersion)))
+			payloads[protocolVersion] = payload
+		}
+		return payload
+	}
+}
+
+func (r *Room) marshalPayload(eventType event.EventType, data any) json.RawMessage {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		slog.Error("Unable to marshal the data of the broadcast event", logger.KeyRoomID, r.ID, logger.KeyEventType, eventType, logger.KeyError, err)
+		return json.RawMessage("null")
+	}
+	return payload
+}
+
+func isNotRoomAdmin(member *Member) bool {
+	return !member.IsRoomAdmin
+}
@@ -0,0 +1,239 @@
+package entity
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/skamranahmed/estimatex-server/internal/codec"
+	"github.com/skamranahmed/estimatex-server/internal/event"
+	"github.com/skamranahmed/estimatex-server/internal/tracker"
+	"github.com/skamranahmed/estimatex-server/internal/transport"
+)
+
+// discardConnection: a websocket-like connection, the messages are encoded with the codec of the client and dropped
+type discardConnection struct {
+	codec codec.Codec
+}
+
+func (c discardConnection) ReadMessage() ([]byte, error) {
+	return nil, transport.ErrClosed
+}
+
+func (c discardConnection) WriteMessage(message []byte) error {
+	_, err := c.codec.Encode(message)
+	return err
+}
+
+func (c discardConnection) WriteEvent(envelope event.Event, data *event.SharedData) error {
+	_, err := c.codec.EncodeEvent(envelope, data)
+	return err
+}
+
+func (c discardConnection) Close(closeCode int, reason string) error {
+	return nil
+}
+
+func (c discardConnection) RemoteAddr() string {
+	return "10.0.0.1"
+}
+
+// blockingConnection: a client which never reads its messages, e.g. whose TCP window is full, its writes wait until
+// the connection is closed
+type blockingConnection struct {
+	closed    chan struct{}
+	closeOnce sync.Once
+	closeCode atomic.Int64
+}
+
+func newBlockingConnection() *blockingConnection {
+	return &blockingConnection{closed: make(chan struct{})}
+}
+
+func (c *blockingConnection) ReadMessage() ([]byte, error) {
+	<-c.closed
+	return nil, transport.ErrClosed
+}
+
+func (c *blockingConnection) WriteMessage(message []byte) error {
+	<-c.closed
+	return transport.ErrClosed
+}
+
+func (c *blockingConnection) Close(closeCode int, reason string) error {
+	c.closeOnce.Do(func() {
+		c.closeCode.Store(int64(closeCode))
+		close(c.closed)
+	})
+	return nil
+}
+
+func (c *blockingConnection) RemoteAddr() string {
+	return "10.0.0.2"
+}
+
+// countingConnection: a client which reads its messages as soon as they are written
+type countingConnection struct {
+	discardConnection
+	written *atomic.Int64
+}
+
+func (c countingConnection) WriteMessage(message []byte) error {
+	c.written.Add(1)
+	return c.discardConnection.WriteMessage(message)
+}
+
+func (c countingConnection) WriteEvent(envelope event.Event, data *event.SharedData) error {
+	c.written.Add(1)
+	return c.discardConnection.WriteEvent(envelope, data)
+}
+
+// startWriting: runs the write go-routine of the member until the end of the test, unless its connection breaks
+func startWriting(tb testing.TB, member *Member) {
+	done := make(chan bool)
+	go member.WriteMessages(done)
+	tb.Cleanup(func() {
+		select {
+		case <-done:
+			// the write go-routine has stopped on its own
+		default:
+			close(done)
+		}
+	})
+}
+
+func TestABroadcastIsNotHeldUpByASlowMember(t *testing.T) {
+	const memberCount = 50
+	const broadcastCount = 2 * memberQueueSize
+
+	room := newTestRoom(memberCount)
+
+	slowConnection := newBlockingConnection()
+	t.Cleanup(func() {
+		slowConnection.Close(transport.CloseNormalClosure, "test done")
+	})
+	slowMember := NewMember("slow", slowConnection, room.ID, true, slog.Default())
+	err := room.AddMember(slowMember)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	startWriting(t, slowMember)
+
+	written := make([]*atomic.Int64, memberCount-1)
+	for i := range written {
+		written[i] = &atomic.Int64{}
+		member := NewMember(fmt.Sprintf("member-%d", i), countingConnection{discardConnection{codec: codec.MessagePack}, written[i]}, room.ID, false, slog.Default())
+		err := room.AddMember(member)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		startWriting(t, member)
+	}
+
+	// every event reaches the other members while the queue of the slow member fills up, and once it has been disconnected
+	deadline := time.Now().Add(5 * time.Second)
+	for broadcasted := int64(1); broadcasted <= broadcastCount; broadcasted++ {
+		room.Broadcast(context.Background(), event.EventRoomJoinUpdates, event.RoomJoinUpdatesEventData{Message: "hello"}, nil)
+
+		for i, memberWritten := range written {
+			for memberWritten.Load() < broadcasted {
+				if time.Now().After(deadline) {
+					t.Fatalf("member-%d got %d events, want %d", i, memberWritten.Load(), broadcasted)
+				}
+				time.Sleep(100 * time.Microsecond)
+			}
+		}
+	}
+
+	if closeCode := slowConnection.closeCode.Load(); closeCode != transport.ClosePolicyViolation {
+		t.Fatalf("got the %d close code, want the slow member to be disconnected with %d", closeCode, transport.ClosePolicyViolation)
+	}
+}
+
+// BenchmarkBroadcast: sends an event to the members of a room of 50 members, either to each member on its own as
+// the handlers used to, or with a single broadcast, with and without a member who never reads its messages
+func BenchmarkBroadcast(b *testing.B) {
+	const memberCount = 50
+
+	ticket := tracker.Ticket{
+		ID:          "ABC-1",
+		Title:       "Let the members export the estimates of the room",
+		Description: "The estimates of the tickets are exported as CSV once the votes have been revealed.",
+		URL:         "https://tracker.example.com/browse/ABC-1",
+	}
+
+	for _, eventCodec := range []codec.Codec{codec.JSON, codec.MessagePack} {
+		// newRoom: the first member never reads its messages when slowConnection is set. The events written to the
+		// other members are counted, so that an iteration lasts until they have all been written.
+		newRoom := func(b *testing.B, slowConnection transport.Connection) (*Room, *atomic.Int64) {
+			room := newTestRoom(memberCount)
+			written := &atomic.Int64{}
+			for i := 0; i < memberCount; i++ {
+				var connection transport.Connection = countingConnection{discardConnection{codec: eventCodec}, written}
+				if i == 0 && slowConnection != nil {
+					connection = slowConnection
+				}
+
+				member := NewMember(fmt.Sprintf("member-%d", i), connection, room.ID, i == 0, slog.Default())
+				err := room.AddMember(member)
+				if err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+
+				startWriting(b, member)
+			}
+			return room, written
+		}
+
+		// waitForWrites: waits until the given number of events have been written
+		waitForWrites := func(written *atomic.Int64, count int64) {
+			for written.Load() < count {
+				runtime.Gosched()
+			}
+		}
+
+		b.Run(eventCodec.Name()+"/per member", func(b *testing.B) {
+			room, written := newRoom(b, nil)
+			members := room.GetMembers()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, member := range members {
+					member.SendAskForVoteEvent(context.Background(), ticket)
+				}
+				waitForWrites(written, int64(i+1)*memberCount)
+			}
+		})
+
+		b.Run(eventCodec.Name()+"/broadcast", func(b *testing.B) {
+			room, written := newRoom(b, nil)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				room.Broadcast(context.Background(), event.EventAskForVote, askForVoteEventData(ticket), nil)
+				waitForWrites(written, int64(i+1)*memberCount)
+			}
+		})
+
+		// one of the members never reads its messages, the other members still get every event, and the slow member
+		// is disconnected once its queue is full
+		b.Run(eventCodec.Name()+"/broadcast with a slow member", func(b *testing.B) {
+			slowConnection := newBlockingConnection()
+			b.Cleanup(func() {
+				slowConnection.Close(transport.CloseNormalClosure, "benchmark done")
+			})
+			room, written := newRoom(b, slowConnection)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				room.Broadcast(context.Background(), event.EventAskForVote, askForVoteEventData(ticket), nil)
+				waitForWrites(written, int64(i+1)*(memberCount-1))
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package entity
+
+import (
+	"sync"
+	"time"
+
+	"github.com/skamranahmed/estimatex-server/internal/event"
+)
+
+// LoggedEvent: an event sent to members of the room, it is kept once whatever the number of its recipients
+type LoggedEvent struct {
+	Seq     uint64
+	Type    string
+	Data    *event.SharedData
+	SentAt  int64
+	TraceID string
+
+	// Key: MemberID, Value: the number of the event sent to the member before this one
+	Recipients map[string]uint64
+}
+
+// eventFor: the event as it has been sent to the recipient
+func (e LoggedEvent) eventFor(memberID string) event.Event {
+	return event.Event{
+		Type:    e.Type,
+		Data:    e.Data.JSON,
+		Seq:     e.Seq,
+		PrevSeq: e.Recipients[memberID],
+		SentAt:  e.SentAt,
+		TraceID: e.TraceID,
+	}
+}
+
+/*
+EventLog: numbers the events sent to the members of a room, and keeps the latest ones so that a member can
+get the events it has missed.
+
+An event is numbered and kept once along with its recipients, hence a broadcast takes a single slot of the log
+whatever the number of members. Every member only gets the events it is a recipient of, hence the sequence
+numbers seen by a member are increasing but not contiguous.
+*/
+type EventLog struct {
+	// sendMutex is held while an event is numbered and queued for its recipients, so that the events are queued
+	// in the order of their numbers, whatever the number of go-routines sending events to the members of the room.
+	// Queueing an event never waits for a member, hence a slow member does not hold up the room.
+	sendMutex sync.Mutex
+
+	mutex sync.Mutex
+
+	lastSeq uint64
+
+	// droppedSeq is the sequence number of the latest event which has been dropped from the log
+	droppedSeq uint64
+
+	// events are the latest events of the room, it is a ring whose oldest event is at the start index once it is full
+	events []LoggedEvent
+	start  int
+	size   int
+}
+
+func NewEventLog(size int) *EventLog {
+	return &EventLog{
+		events: make([]LoggedEvent, 0, size),
+		size:   size,
+	}
+}
+
+// send: numbers the event, keeps it and queues it for the recipients. Each recipient is given the number of the
+// previous event it has been sent, so that it can tell when it has missed one.
+func (l *EventLog) send(recipients []*Member, eventType string, data *event.SharedData, traceID string) {
+	l.sendMutex.Lock()
+	defer l.sendMutex.Unlock()
+
+	loggedEvent := LoggedEvent{
+		Type:       eventType,
+		Data:       data,
+		SentAt:     time.Now().UnixMilli(),
+		TraceID:    traceID,
+		Recipients: make(map[string]uint64, len(recipients)),
+	}
+	for _, recipient := range recipients {
+		loggedEvent.Recipients[recipient.ID] = recipient.lastSeq
+	}
+	loggedEvent.Seq = l.record(loggedEvent)
+
+	for _, recipient := range recipients {
+		recipient.queueLoggedEvent(loggedEvent)
+	}
+}
+
+// record: gives the next sequence number to the event and keeps it, the oldest event is dropped when the log is full
+func (l *EventLog) record(loggedEvent LoggedEvent) uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.lastSeq++
+	loggedEvent.Seq = l.lastSeq
+
+	if len(l.events) < l.size {
+		l.events = append(l.events, loggedEvent)
+		return loggedEvent.Seq
+	}
+
+	l.droppedSeq = l.events[l.start].Seq
+	l.events[l.start] = loggedEvent
+	l.start = (l.start + 1) % l.size
+
+	return loggedEvent.Seq
+}
+
+// Between: returns the events of the member which come after the sinceSeq sequence number, up to the untilSeq one.
+// It reports false when some of them have already been dropped from the log, in which case the member must get
+// the state of the room again instead.
+func (l *EventLog) Between(memberID string, sinceSeq uint64, untilSeq uint64) ([]LoggedEvent, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if sinceSeq < l.droppedSeq {
+		return nil, false
+	}
+
+	var memberEvents []LoggedEvent
+	for _, loggedEvent := range l.ordered() {
+		if loggedEvent.Seq > sinceSeq && loggedEvent.Seq <= untilSeq && loggedEvent.isSentTo(memberID) {
+			memberEvents = append(memberEvents, loggedEvent)
+		}
+	}
+	return memberEvents, true
+}
+
+// LastSeq: returns the sequence number of the latest event of the room
+func (l *EventLog) LastSeq() uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.lastSeq
+}
+
+// LastSeqOf: returns the sequence number of the latest event of the member which is still in the log, or 0
+func (l *EventLog) LastSeqOf(memberID string) uint64 {
+	if l == nil {
+		return 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	orderedEvents := l.ordered()
+	for i := len(orderedEvents) - 1; i >= 0; i-- {
+		if orderedEvents[i].isSentTo(memberID) {
+			return orderedEvents[i].Seq
+		}
+	}
+	return 0
+}
+
+// snapshot: returns the events of the log, along with the sequence numbers of the latest event and of the latest
+// dropped event
+func (l *EventLog) snapshot() ([]LoggedEvent, uint64, uint64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.ordered(), l.lastSeq, l.droppedSeq
+}
+
+// ordered: returns the events from the oldest to the latest, the mutex must be held
+func (l *EventLog) ordered() []LoggedEvent {
+	orderedEvents := make([]LoggedEvent, 0, len(l.events))
+	orderedEvents = append(orderedEvents, l.events[l.start:]...)
+	return append(orderedEvents, l.events[:l.start]...)
+}
+
+// restore: brings the log back to the state of a snapshot, the numbering carries on from the latest event
+func (l *EventLog) restore(events []LoggedEvent, lastSeq uint64, droppedSeq uint64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if len(events) > l.size {
+		droppedSeq = events[len(events)-l.size-1].Seq
+		events = events[len(events)-l.size:]
+	}
+
+	l.events = append(l.events[:0], events...)
+	l.start = 0
+	l.lastSeq = lastSeq
+	l.droppedSeq = droppedSeq
+}
+
+func (e LoggedEvent) isSentTo(memberID string) bool {
+	_, ok := e.Recipients[memberID]
+	return ok
+}
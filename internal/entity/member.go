@@ -1,49 +1,175 @@
 package entity
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
-	"log"
-	"strings"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
+	"github.com/skamranahmed/estimatex-server/internal/codec"
 	"github.com/skamranahmed/estimatex-server/internal/event"
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+	"github.com/skamranahmed/estimatex-server/internal/metrics"
+	"github.com/skamranahmed/estimatex-server/internal/tracing"
+	"github.com/skamranahmed/estimatex-server/internal/tracker"
+	"github.com/skamranahmed/estimatex-server/internal/transport"
 )
 
+// memberQueueSize is the number of messages kept for a member until they are written to its connection,
+// the member is disconnected once its queue is full
+const memberQueueSize = 256
+
 type Member struct {
-	ID             string
-	Name           string
-	Connection     *websocket.Conn
-	RoomID         string
-	IsRoomAdmin    bool
-	MessageChannel chan string
+	ID   string
+	Name string
+
+	// Connection carries the events of the member whatever its transport: a websocket, HTTP requests, or
+	// the cluster backend when the member is connected to another instance of the server
+	Connection transport.Connection
+
+	RoomID      string
+	IsRoomAdmin bool
+
+	// messageChannel hands the messages to the write go-routine, it holds up to memberQueueSize messages.
+	// overflowed is set once the queue has been found full, the connection of the member is then closed.
+	messageChannel chan outgoingMessage
+	overflowed     atomic.Bool
+
+	// RemoteIP is the source IP address of the member's connection, it is used to ban the member from the room.
+	// It is kept apart from the connection so that it outlives it, in the snapshots of the room.
+	RemoteIP string
+
+	// muted is set when the admin silences the member, a muted member cannot vote
+	muted atomic.Bool
+
+	// JoinedAt is the time at which the member connected to the room
+	JoinedAt time.Time
+
+	// ResumeToken lets the member take back its seat in the room after a restart of the server,
+	// only its hash is written to the snapshots of the room
+	ResumeToken string
+
+	// ProtocolVersion is the version of the protocol the client speaks, the events are sent in the shape of that version
+	ProtocolVersion int
+
+	// eventLog numbers the events sent to the member, it is the log of the room the member has been added to.
+	// lastSeq is the number of the latest event sent to the member, it is only changed by the log while it numbers
+	// an event. sendMutex makes sure that the events replayed to the member are not interleaved with the new ones.
+	eventLog  *EventLog
+	lastSeq   uint64
+	sendMutex sync.Mutex
+
+	// writerStopped is closed once the write go-routine has stopped, the messages are not queued anymore after that
+	writerStopped     chan struct{}
+	writerStoppedOnce sync.Once
+
+	// seatSeq is the number of the latest event sent to the seat of the member before it connected,
+	// it is only set for a member who resumes its seat
+	seatSeq uint64
+
+	// acknowledgedEventIDs are the ids of the latest events which have been acknowledged, from the oldest to the latest
+	acknowledgedEventIDs      map[string]struct{}
+	acknowledgedEventIDsOrder []string
+
+	// joined is set once the member has been announced to the room, so that a repeated JOIN_ROOM is ignored
+	joined atomic.Bool
+
+	// resumed is set for a member who takes back the seat of a detached member, the seat is already its own
+	resumed bool
+
+	// disconnectChannel asks the write go-routine to close the connection, once the messages which have already
+	// been queued are sent. It is buffered so that a disconnect request never blocks, even when the member is gone.
+	disconnectChannel chan closeRequest
+
+	// Logger attaches the room id, the member id and the member name to every log record of the member
+	Logger *slog.Logger
+}
+
+// closeRequest: the close code and the reason sent to the client when the server closes its connection
+type closeRequest struct {
+	code   int
+	reason string
+}
+
+// DetachedMember: a member restored from the snapshot of the room, who has not reconnected since the restart
+type DetachedMember struct {
+	ID          string
+	Name        string
+	IsRoomAdmin bool
+	RemoteIP    string
+	Muted       bool
+	JoinedAt    time.Time
+
+	resumeTokenHash []byte
+
+	// claimed is set once a client has resumed the member, the seat is then waiting for it to be added to the room
+	claimed atomic.Bool
+}
+
+// NewMember: creates a new member with a unique ID, the member's logger is derived from the given logger
+func NewMember(memberName string, connection transport.Connection, roomID string, isRoomAdmin bool, parentLogger *slog.Logger) *Member {
+	return newMember(uuid.New().String(), memberName, connection, roomID, isRoomAdmin, parentLogger)
+}
+
+// NewResumedMember: creates the member who takes back the seat of a detached member, it keeps the id of the
+// detached member, and hence its votes. A new resume token is given to the member.
+func NewResumedMember(detachedMember *DetachedMember, connection transport.Connection, roomID string, parentLogger *slog.Logger) *Member {
+	member := newMember(detachedMember.ID, detachedMember.Name, connection, roomID, detachedMember.IsRoomAdmin, parentLogger)
+	member.JoinedAt = detachedMember.JoinedAt
+	member.SetMuted(detachedMember.Muted)
+
+	// the member has already been announced to the room before the restart
+	member.joined.Store(true)
+	member.resumed = true
+	return member
 }
 
-// NewMember: creates a new member with a unique ID
-func NewMember(memberName string, memberWebSocketConnection *websocket.Conn, roomID string, isRoomAdmin bool) *Member {
+func newMember(memberID string, memberName string, connection transport.Connection, roomID string, isRoomAdmin bool, parentLogger *slog.Logger) *Member {
 	return &Member{
-		ID:             uuid.New().String(),
-		Name:           memberName,
-		Connection:     memberWebSocketConnection,
-		RoomID:         roomID,
-		IsRoomAdmin:    isRoomAdmin,
-		MessageChannel: make(chan string),
+		ID:                memberID,
+		Name:              memberName,
+		Connection:        connection,
+		RoomID:            roomID,
+		IsRoomAdmin:       isRoomAdmin,
+		RemoteIP:          connection.RemoteAddr(),
+		messageChannel:    make(chan outgoingMessage, memberQueueSize),
+		JoinedAt:          time.Now(),
+		ResumeToken:       newResumeToken(),
+		ProtocolVersion:   event.ProtocolVersion1,
+		writerStopped:     make(chan struct{}),
+		disconnectChannel: make(chan closeRequest, 1),
+		Logger:            parentLogger.With(logger.KeyRoomID, roomID, logger.KeyMemberID, memberID, logger.KeyMemberName, memberName),
 	}
 }
 
-// ReadMessages: continuously reads messages from the WebSocket connection.
+// NewRemoteMember: creates a member connected to another instance of the server, such a member is never the room admin
+// since a room is owned by the instance on which it has been created. Only the admin of a restored room can come back
+// through another instance, see NewResumedMember.
+func NewRemoteMember(memberName string, remoteConnection transport.Connection, roomID string, parentLogger *slog.Logger) *Member {
+	return NewMember(memberName, remoteConnection, roomID, false, parentLogger)
+}
+
+// ReadMessages: continuously reads messages from the member's connection.
 // It is a blocking operation, hence it must be run as a go routine.
 func (m *Member) ReadMessages(room *Room, doneChannel chan bool) {
-	log.Println("Starting a go-routine to read messages from the client: ", m.Name)
+	m.Logger.Debug("Starting a go-routine to read messages from the client")
 
 	defer func() {
-		log.Println("Shutting down the read go-routine for the client: ", m.Name)
+		m.Logger.Debug("Shutting down the read go-routine for the client")
 
 		// if the connection is closed for the room admin, then
 		// all other members also need to be removed from the room
 		// also, their connection has to be closed as well
 		if m.IsRoomAdmin {
-			log.Printf("Closing the connection for the admin of room id: %+v. Disconnecting all the other members.", m.RoomID)
+			m.Logger.Info("Closing the connection for the admin of the room, disconnecting all the other members")
 
 			connectedMembers := room.GetMembers()
 
@@ -52,10 +178,10 @@ func (m *Member) ReadMessages(room *Room, doneChannel chan bool) {
 					// remove the member from the room
 					room.RemoveMember(connectedMember.ID)
 
-					log.Printf("Closing connection for the client: %+v\n", connectedMember.Name)
+					connectedMember.Logger.Info("Closing the connection for the client")
 
-					// close the member's websocket connection
-					connectedMember.Connection.Close()
+					// close the member's connection
+					connectedMember.dropConnection()
 				}
 			}
 		}
@@ -63,8 +189,8 @@ func (m *Member) ReadMessages(room *Room, doneChannel chan bool) {
 		// remove the member from the room
 		room.RemoveMember(m.ID)
 
-		// close the member's websocket connection
-		m.Connection.Close()
+		// close the member's connection
+		m.dropConnection()
 
 		select {
 		case <-doneChannel:
@@ -76,7 +202,7 @@ func (m *Member) ReadMessages(room *Room, doneChannel chan bool) {
 		}
 	}()
 
-	// continuously read messages from the member's websocket connection
+	// continuously read messages from the member's connection
 	for {
 		select {
 		case <-doneChannel:
@@ -90,51 +216,59 @@ func (m *Member) ReadMessages(room *Room, doneChannel chan bool) {
 			return
 
 		default:
-			// read the message from the member's websocket connection
-			_, payload, err := m.Connection.ReadMessage()
+			// read the message from the member's connection, the connection answers the close message of the client
+			payload, err := m.Connection.ReadMessage()
 			if err != nil {
-				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-					// respond to the client's close message
-					log.Printf("%+v initiated close for the room id: %+v\n", m.Name, m.RoomID)
-					m.Connection.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Server closing connection"))
+				if transport.IsCloseError(err, transport.CloseNormalClosure, transport.CloseGoingAway) {
+					m.Logger.Info("Client initiated close")
 					return
 				}
 
 				// handle the case where the client's connection is abruptly closed (close code 1006)
-				if strings.Contains(err.Error(), "close 1006 (abnormal closure)") {
-					log.Printf("Client's websocket connection was abruptly closed, error: %+v\n", err)
+				if transport.IsCloseError(err, transport.CloseAbnormalClosure) {
+					m.Logger.Info("Client's websocket connection was abruptly closed", logger.KeyError, err)
+					return
+				}
+
+				if errors.Is(err, transport.ErrInvalidMessage) {
+					m.Logger.Warn("Error decoding the received event message from the client", logger.KeyError, err)
 					return
 				}
 
-				log.Printf("Client closed the websocket connection, error: %+v\n", err)
+				m.Logger.Info("Client closed the connection", logger.KeyError, err)
 				return
 			}
 
 			var receivedEvent event.Event
 			err = json.Unmarshal(payload, &receivedEvent)
 			if err != nil {
-				log.Printf("Error unmarshalling the received event message from the client: %v", err)
-				return
+				// the message is not an event, hence it has no id to reply to, the connection stays open
+				m.Logger.Warn("Error unmarshalling the received event message from the client", logger.KeyError, err, logger.KeyErrorCode, event.ErrorCodeInvalidPayload)
+				m.SendErrorEvent(context.Background(), event.ErrorCodeInvalidPayload, "", "⚠️ The message is not a valid event")
+				continue
 			}
 
 			// logic to handle different types of WebSocket messsages as events
-			err = room.HandleEvent(m, receivedEvent)
+			err = m.handleEvent(context.Background(), room, receivedEvent)
 			if err != nil {
-				log.Printf("Error while handling the received event %s", receivedEvent.Type)
-				// TODO: Do I need to inform the client that something has gone wrong on the server?
+				// the client has already been sent an ERROR event, the errors which are left are the ones of the server
+				m.Logger.Error("Error while handling the received event", logger.KeyEventType, receivedEvent.Type, logger.KeyError, err)
 				return
 			}
 		}
 	}
 }
 
-// WriteMessages: sends messages to the WebSocket connection.
+// WriteMessages: sends messages to the member's connection.
 // It is a blocking operation, hence it must be run as a go routine.
 func (m *Member) WriteMessages(doneChannel chan bool) {
-	log.Println("Starting a go-routine to write messages to the client: ", m.Name)
+	m.Logger.Debug("Starting a go-routine to write messages to the client")
 
 	defer func() {
-		log.Println("Shutting down the write go-routine for the client: ", m.Name)
+		m.Logger.Debug("Shutting down the write go-routine for the client")
+		m.writerStoppedOnce.Do(func() {
+			close(m.writerStopped)
+		})
 
 		select {
 		case <-doneChannel:
@@ -148,14 +282,30 @@ func (m *Member) WriteMessages(doneChannel chan bool) {
 
 	for {
 		select {
-		case messageToBeSentToMember := <-m.MessageChannel:
-			err := m.Connection.WriteMessage(websocket.TextMessage, []byte(messageToBeSentToMember))
-			if err != nil {
-				log.Printf("Error while sending message to the client, error: %+v\n", err)
+		case messageToBeSentToMember := <-m.messageChannel:
+			if !m.writeQueued(messageToBeSentToMember) {
 				// in case of an error, make an early return and close the connection
 				return
 			}
 
+		case request := <-m.disconnectChannel:
+			// the messages queued before the disconnect request are sent first
+			for queued := true; queued; {
+				select {
+				case messageToBeSentToMember := <-m.messageChannel:
+					if !m.writeQueued(messageToBeSentToMember) {
+						return
+					}
+				default:
+					queued = false
+				}
+			}
+
+			// the close message is sent before closing the connection, which makes the `ReadMessages` go-routine stop
+			m.Logger.Info("Closing the connection for the client", "close_code", request.code, "close_reason", request.reason)
+			m.Connection.Close(request.code, request.reason)
+			return
+
 		case <-doneChannel:
 			/*
 				Exit the loop if the done channel is closed (indicating that the websocket connection is closed).
@@ -169,7 +319,48 @@ func (m *Member) WriteMessages(doneChannel chan bool) {
 	}
 }
 
-func (m *Member) SendCreateRoomEvent(roomID string) {
+// Disconnect: closes the member's connection with the given close code and reason, the events which
+// have already been sent to the member are delivered before the connection is closed
+func (m *Member) Disconnect(closeCode int, reason string) {
+	select {
+	case m.disconnectChannel <- closeRequest{code: closeCode, reason: reason}:
+	default:
+		// the member is already being disconnected
+	}
+}
+
+// dropConnection: closes the connection once the member has left the room, the connection of a member who is connected
+// to another instance of the server, or through HTTP requests, cannot notice that the member has left by itself
+func (m *Member) dropConnection() {
+	m.Connection.Close(transport.CloseGoingAway, "Server closing connection")
+}
+
+// newResumeToken: returns a random token, it is only known by the member
+func newResumeToken() string {
+	token := make([]byte, 32)
+	rand.Read(token)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+func hashResumeToken(resumeToken string) []byte {
+	hash := sha256.Sum256([]byte(resumeToken))
+	return hash[:]
+}
+
+// verifyResumeToken: reports whether the token is the one of the detached member
+func (d *DetachedMember) verifyResumeToken(resumeToken string) bool {
+	return subtle.ConstantTimeCompare(hashResumeToken(resumeToken), d.resumeTokenHash) == 1
+}
+
+func (m *Member) IsMuted() bool {
+	return m.muted.Load()
+}
+
+func (m *Member) SetMuted(muted bool) {
+	m.muted.Store(muted)
+}
+
+func (m *Member) SendCreateRoomEvent(ctx context.Context, roomID string) {
 	createRoomEvent := event.CreateRoomEventData{
 		RoomID: roomID,
 	}
@@ -178,22 +369,32 @@ func (m *Member) SendCreateRoomEvent(roomID string) {
 		Type: string(event.EventCreateRoom),
 		Data: json.RawMessage(createRoomEvenJsonData),
 	}
-	m.sendEvent(eventToBeSent)
+	m.sendEvent(ctx, eventToBeSent)
 }
 
-func (m *Member) SendRoomJoinUpdatesEvent(message string) {
-	roomJoinUpdatesEvent := event.RoomJoinUpdatesEventData{
-		Message: message,
-	}
+// SendRoomJoinUpdatesEvent: the joined member is the member the update is about, it is nil when the update is not about a member
+func (m *Member) SendRoomJoinUpdatesEvent(ctx context.Context, message string, joinedMember *Member) {
+	roomJoinUpdatesEvent := roomJoinUpdatesEventData(message, joinedMember)
 	roomJoinUpdatesEventJsonData, _ := json.Marshal(roomJoinUpdatesEvent)
 	eventToBeSent := event.Event{
 		Type: string(event.EventRoomJoinUpdates),
 		Data: json.RawMessage(roomJoinUpdatesEventJsonData),
 	}
-	m.sendEvent(eventToBeSent)
+	m.sendEvent(ctx, eventToBeSent)
+}
+
+func roomJoinUpdatesEventData(message string, joinedMember *Member) event.RoomJoinUpdatesEventData {
+	roomJoinUpdatesEvent := event.RoomJoinUpdatesEventData{
+		Message: message,
+	}
+	if joinedMember != nil {
+		roomJoinUpdatesEvent.MemberID = joinedMember.ID
+		roomJoinUpdatesEvent.MemberName = joinedMember.Name
+	}
+	return roomJoinUpdatesEvent
 }
 
-func (m *Member) SendRoomCapacityReachedEvent(message string) {
+func (m *Member) SendRoomCapacityReachedEvent(ctx context.Context, message string) {
 	roomCapacityReachedEvent := event.RoomCapacityReachedEventData{
 		Message: message,
 	}
@@ -202,10 +403,10 @@ func (m *Member) SendRoomCapacityReachedEvent(message string) {
 		Type: string(event.EventRoomCapacityReached),
 		Data: json.RawMessage(roomCapacityReachedEventJsonData),
 	}
-	m.sendEvent(eventToBeSent)
+	m.sendEvent(ctx, eventToBeSent)
 }
 
-func (m *Member) SendBeginVotingPromptEvent(message string) {
+func (m *Member) SendBeginVotingPromptEvent(ctx context.Context, message string) {
 	beginVotingPromptEvent := event.BeginVotingPromptEventData{
 		Message: message,
 	}
@@ -214,22 +415,28 @@ func (m *Member) SendBeginVotingPromptEvent(message string) {
 		Type: string(event.EventBeginVotingPrompt),
 		Data: json.RawMessage(beginVotingPromptEventJsonData),
 	}
-	m.sendEvent(eventToBeSent)
+	m.sendEvent(ctx, eventToBeSent)
 }
 
-func (m *Member) SendAskForVoteEvent(ticketId string) {
-	askForVoteEvent := event.AskForVoteEventData{
-		TicketID: ticketId,
-	}
-	askForVoteEventJsonData, _ := json.Marshal(askForVoteEvent)
+func (m *Member) SendAskForVoteEvent(ctx context.Context, ticket tracker.Ticket) {
+	askForVoteEventJsonData, _ := json.Marshal(askForVoteEventData(ticket))
 	eventToBeSent := event.Event{
 		Type: string(event.EventAskForVote),
 		Data: json.RawMessage(askForVoteEventJsonData),
 	}
-	m.sendEvent(eventToBeSent)
+	m.sendEvent(ctx, eventToBeSent)
+}
+
+func askForVoteEventData(ticket tracker.Ticket) event.AskForVoteEventData {
+	return event.AskForVoteEventData{
+		TicketID:    ticket.ID,
+		Title:       ticket.Title,
+		Description: ticket.Description,
+		URL:         ticket.URL,
+	}
 }
 
-func (m *Member) SendVotingCompletedEvent(message string) {
+func (m *Member) SendVotingCompletedEvent(ctx context.Context, message string) {
 	votingCompletedEvent := event.VotingCompletedEventData{
 		Message: message,
 	}
@@ -238,10 +445,10 @@ func (m *Member) SendVotingCompletedEvent(message string) {
 		Type: string(event.EventVotingCompleted),
 		Data: json.RawMessage(votingCompletedEventJsonData),
 	}
-	m.sendEvent(eventToBeSent)
+	m.sendEvent(ctx, eventToBeSent)
 }
 
-func (m *Member) SendRevealVotesPromptEvent(message string, ticketId string) {
+func (m *Member) SendRevealVotesPromptEvent(ctx context.Context, message string, ticketId string) {
 	revealVotesPromptEvent := event.RevealVotesPromptEventData{
 		Message:  message,
 		TicketID: ticketId,
@@ -251,23 +458,36 @@ func (m *Member) SendRevealVotesPromptEvent(message string, ticketId string) {
 		Type: string(event.EventRevealVotesPrompt),
 		Data: json.RawMessage(revealVotesPromptEventJsonData),
 	}
-	m.sendEvent(eventToBeSent)
+	m.sendEvent(ctx, eventToBeSent)
 }
 
-func (m *Member) SendVotesRevealedEvent(ticketId string, memberVoteMap map[string]interface{}) {
-	votesRevealedEvent := event.VotesRevealedEventData{
-		TicketID:            ticketId,
-		MemberVoteChoiceMap: memberVoteMap,
-	}
-	votesRevealedEventJsonData, _ := json.Marshal(votesRevealedEvent)
-	eventToBeSent := event.Event{
-		Type: string(event.EventVotesRevealed),
-		Data: json.RawMessage(votesRevealedEventJsonData),
+// votesRevealedEventData: the votes are keyed by member id for the clients of the first version of the protocol,
+// and listed for the later versions
+func votesRevealedEventData(protocolVersion int, ticketId string, memberVoteMap map[string]*Vote) any {
+	var votesRevealedEvent interface{}
+	if protocolVersion < event.ProtocolVersion2 {
+		memberVoteChoiceMap := make(map[string]interface{}, len(memberVoteMap))
+		for memberID, vote := range memberVoteMap {
+			memberVoteChoiceMap[memberID] = vote
+		}
+		votesRevealedEvent = event.VotesRevealedEventData{
+			TicketID:            ticketId,
+			MemberVoteChoiceMap: memberVoteChoiceMap,
+		}
+	} else {
+		votes := make([]event.RevealedVote, 0, len(memberVoteMap))
+		for _, vote := range memberVoteMap {
+			votes = append(votes, event.RevealedVote{MemberID: vote.MemberID, MemberName: vote.MemberName, Vote: vote.Value})
+		}
+		votesRevealedEvent = event.VotesRevealedV2EventData{
+			TicketID: ticketId,
+			Votes:    votes,
+		}
 	}
-	m.sendEvent(eventToBeSent)
+	return votesRevealedEvent
 }
 
-func (m *Member) SendAwaitingAdminVoteStartEvent(message string) {
+func (m *Member) SendAwaitingAdminVoteStartEvent(ctx context.Context, message string) {
 	awaitingAdminVoteStartEvent := event.AwaitingAdminVoteStartEventData{
 		Message: message,
 	}
@@ -276,14 +496,213 @@ func (m *Member) SendAwaitingAdminVoteStartEvent(message string) {
 		Type: string(event.EventAwaitingAdminVoteStart),
 		Data: json.RawMessage(awaitingAdminVoteStartEventJsonData),
 	}
-	m.sendEvent(eventToBeSent)
+	m.sendEvent(ctx, eventToBeSent)
+}
+
+func (m *Member) SendInviteCreatedEvent(ctx context.Context, inviteID string, token string, expiresAt int64) {
+	inviteCreatedEvent := event.InviteCreatedEventData{
+		InviteID:  inviteID,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	}
+	inviteCreatedEventJsonData, _ := json.Marshal(inviteCreatedEvent)
+	eventToBeSent := event.Event{
+		Type: string(event.EventInviteCreated),
+		Data: json.RawMessage(inviteCreatedEventJsonData),
+	}
+	m.sendEvent(ctx, eventToBeSent)
+}
+
+func (m *Member) SendInviteRevokedEvent(ctx context.Context, inviteID string) {
+	inviteRevokedEvent := event.InviteRevokedEventData{
+		InviteID: inviteID,
+	}
+	inviteRevokedEventJsonData, _ := json.Marshal(inviteRevokedEvent)
+	eventToBeSent := event.Event{
+		Type: string(event.EventInviteRevoked),
+		Data: json.RawMessage(inviteRevokedEventJsonData),
+	}
+	m.sendEvent(ctx, eventToBeSent)
+}
+
+func (m *Member) SendRateLimitedEvent(ctx context.Context, scope string, rejectedEventType string, message string) {
+	metrics.Errors.WithLabelValues(string(event.EventRateLimited)).Inc()
+
+	rateLimitedEvent := event.RateLimitedEventData{
+		Scope:     scope,
+		EventType: rejectedEventType,
+		Message:   message,
+		ID:        m.rejectEvent(ctx),
+	}
+	rateLimitedEventJsonData, _ := json.Marshal(rateLimitedEvent)
+	eventToBeSent := event.Event{
+		Type: string(event.EventRateLimited),
+		Data: json.RawMessage(rateLimitedEventJsonData),
+	}
+	m.sendEvent(ctx, eventToBeSent)
+}
+
+func (m *Member) SendErrorEvent(ctx context.Context, code event.ErrorCode, rejectedEventType string, message string) {
+	metrics.Errors.WithLabelValues(string(code)).Inc()
+
+	errorEvent := event.ErrorEventData{
+		Code:      code,
+		EventType: rejectedEventType,
+		Message:   message,
+		ID:        m.rejectEvent(ctx),
+	}
+	errorEventJsonData, _ := json.Marshal(errorEvent)
+	eventToBeSent := event.Event{
+		Type: string(event.EventError),
+		Data: json.RawMessage(errorEventJsonData),
+	}
+	m.sendEvent(ctx, eventToBeSent)
+}
+
+func (m *Member) SendResumeTokenEvent(ctx context.Context) {
+	resumeTokenEvent := event.ResumeTokenEventData{
+		MemberID:    m.ID,
+		ResumeToken: m.ResumeToken,
+	}
+	resumeTokenEventJsonData, _ := json.Marshal(resumeTokenEvent)
+	eventToBeSent := event.Event{
+		Type: string(event.EventResumeToken),
+		Data: json.RawMessage(resumeTokenEventJsonData),
+	}
+	m.sendEvent(ctx, eventToBeSent)
+}
+
+func (m *Member) SendWelcomeEvent(ctx context.Context, welcome event.WelcomeEventData) {
+	welcomeEventJsonData, _ := json.Marshal(welcome)
+	eventToBeSent := event.Event{
+		Type: string(event.EventWelcome),
+		Data: json.RawMessage(welcomeEventJsonData),
+	}
+	m.sendEvent(ctx, eventToBeSent)
+}
+
+// sendEvent: queues the event to be written to the member's connection, see sendSharedEvent
+func (m *Member) sendEvent(ctx context.Context, eventToBeSent event.Event) {
+	m.sendSharedEvent(ctx, eventToBeSent.Type, event.NewSharedData(eventToBeSent.Data))
+}
+
+// sendSharedEvent: queues the event to be written to the member's connection, the event carries the id of the trace
+// it belongs to so that the client side logs can be correlated with the server traces. Once the member has been
+// added to a room, the event is numbered and kept in the log of the room, so that it can be replayed.
+func (m *Member) sendSharedEvent(ctx context.Context, eventType string, data *event.SharedData) {
+	if m.eventLog != nil {
+		m.eventLog.send([]*Member{m}, eventType, data, tracing.TraceID(ctx))
+		return
+	}
+
+	m.sendMutex.Lock()
+	defer m.sendMutex.Unlock()
+
+	metrics.EventsSent.WithLabelValues(eventType).Inc()
+	m.queue(outgoingMessage{envelope: event.Event{Type: eventType, TraceID: tracing.TraceID(ctx)}, data: data})
+}
+
+// queueLoggedEvent: queues an event numbered by the log of the room, it is called by the log in the order of the numbers
+func (m *Member) queueLoggedEvent(loggedEvent LoggedEvent) {
+	m.sendMutex.Lock()
+	defer m.sendMutex.Unlock()
+
+	m.lastSeq = loggedEvent.Seq
+	metrics.EventsSent.WithLabelValues(loggedEvent.Type).Inc()
+	m.queue(outgoingMessage{envelope: loggedEvent.eventFor(m.ID), data: loggedEvent.Data})
+}
+
+// outgoingMessage: either an event, whose data may be shared with the events of other members, the events replayed
+// to the member, or a plain text message
+type outgoingMessage struct {
+	envelope event.Event
+	data     *event.SharedData
+	replayed []LoggedEvent
+	text     string
+}
+
+// queue: hands the message to the write go-routine without ever waiting for it, so that a member who does not read
+// its messages never holds up the events of the other members. The message is dropped once the go-routine has stopped,
+// and the member is disconnected when its queue is full. The sendMutex must be held.
+func (m *Member) queue(message outgoingMessage) {
+	select {
+	case m.messageChannel <- message:
+	case <-m.writerStopped:
+	default:
+		m.dropSlowConsumer()
+	}
+}
+
+// dropSlowConsumer: closes the connection of a member whose queue is full, the write go-routine then stops and the member
+// leaves the room. The connection is closed by another go-routine, since the room is sending an event meanwhile.
+func (m *Member) dropSlowConsumer() {
+	if m.overflowed.Swap(true) {
+		return
+	}
+
+	metrics.Errors.WithLabelValues("SLOW_CONSUMER").Inc()
+	m.Logger.Warn("The member does not read its messages fast enough, closing its connection", "queue_size", memberQueueSize)
+	go m.Connection.Close(transport.ClosePolicyViolation, "too many messages left to read")
+}
+
+// writeQueued: writes a message taken from the queue, it reports false when the connection is broken
+func (m *Member) writeQueued(message outgoingMessage) bool {
+	startedAt := time.Now()
+	err := m.write(message)
+	metrics.WebsocketWriteDuration.Observe(time.Since(startedAt).Seconds())
+	if err != nil {
+		metrics.WebsocketWriteErrors.Inc()
+		m.Logger.Warn("Error while sending message to the client", logger.KeyError, err)
+		return false
+	}
+	return true
 }
 
-func (m *Member) sendEvent(eventToBeSent event.Event) {
-	jsonMessage, err := json.Marshal(eventToBeSent)
+// write: the event is encoded by the connection when it has its own wire encoding, so that the shared data is
+// converted once for every member, it is written as JSON otherwise
+func (m *Member) write(message outgoingMessage) error {
+	if message.replayed != nil {
+		for _, loggedEvent := range message.replayed {
+			err := m.write(outgoingMessage{envelope: loggedEvent.eventFor(m.ID), data: loggedEvent.Data})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if message.data == nil {
+		return m.Connection.WriteMessage([]byte(message.text))
+	}
+
+	if eventWriter, ok := m.Connection.(transport.EventWriter); ok {
+		return eventWriter.WriteEvent(message.envelope, message.data)
+	}
+
+	encodedEvent, err := codec.JSON.EncodeEvent(message.envelope, message.data)
 	if err != nil {
-		log.Printf("unable to marshal message: %+v, error: %+v", eventToBeSent, err)
+		return err
 	}
+	return m.Connection.WriteMessage(encodedEvent)
+}
+
+// sendPlainText: queues a message which is not an event, it is neither numbered nor kept in the log of the room
+func (m *Member) sendPlainText(message string) {
+	m.sendMutex.Lock()
+	defer m.sendMutex.Unlock()
+
+	m.queue(outgoingMessage{text: message})
+}
+
+// replayEvents: queues the events again as they were sent the first time, with their original numbers. They take a
+// single slot of the queue of the member, whatever their number.
+func (m *Member) replayEvents(loggedEvents []LoggedEvent) {
+	if len(loggedEvents) == 0 {
+		return
+	}
+
+	m.sendMutex.Lock()
+	defer m.sendMutex.Unlock()
 
-	m.MessageChannel <- string(jsonMessage)
+	m.queue(outgoingMessage{replayed: loggedEvents})
 }
@@ -1,30 +1,161 @@
 package entity
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/skamranahmed/estimatex-server/internal/event"
+	"github.com/skamranahmed/estimatex-server/internal/invite"
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+	"github.com/skamranahmed/estimatex-server/internal/metrics"
+	"github.com/skamranahmed/estimatex-server/internal/ratelimit"
+	"github.com/skamranahmed/estimatex-server/internal/schema"
+	"github.com/skamranahmed/estimatex-server/internal/tracing"
+	"github.com/skamranahmed/estimatex-server/internal/tracker"
+	"github.com/skamranahmed/estimatex-server/internal/transport"
+	"github.com/skamranahmed/estimatex-server/internal/webhook"
+	"golang.org/x/crypto/bcrypt"
 )
 
-type EventHanlder func(member *Member, event event.Event) error
+const (
+	defaultInviteTTL = 24 * time.Hour
+	maxInviteTTL     = 7 * 24 * time.Hour
+
+	rateLimitScopeMember = "member"
+	rateLimitScopeRoom   = "room"
+
+	// trackerTimeout is the time given to the issue tracker, the event of the member waits for it
+	trackerTimeout = 5 * time.Second
+)
+
+// broadcastEventTypes are the incoming events whose handlers send a message to every member of the room.
+// JOIN_ROOM is not one of them although it is announced to the room: a dropped join would never be announced,
+// and the room would never reach its capacity nor prompt its admin to begin voting.
+var broadcastEventTypes = map[event.EventType]bool{
+	event.EventBeginVoting: true,
+	event.EventMemberVoted: true,
+	event.EventRevealVotes: true,
+	event.EventKickMember:  true,
+	event.EventMuteMember:  true,
+
+	event.EventSetFinalEstimate: true,
+}
+
+// mutedMemberAllowedEventTypes are the incoming events which a muted member is still allowed to send
+var mutedMemberAllowedEventTypes = map[event.EventType]bool{
+	event.EventJoinRoom:     true,
+	event.EventReplayEvents: true,
+	event.EventGetRoomState: true,
+}
+
+var (
+	ErrInvalidRoomCredentials = errors.New("invalid room password or invite token")
+	ErrInviteRevoked          = errors.New("invite token has been revoked")
+	ErrInviteForAnotherRoom   = errors.New("invite token does not belong to this room")
+	ErrMemberNotFound         = errors.New("member is not present in the room")
+	ErrCannotKickAdmin        = errors.New("the admin cannot be kicked, close the room instead")
+	ErrCannotMuteAdmin        = errors.New("the admin cannot be muted")
+	ErrRoomFull               = errors.New("the room is full")
+)
+
+// RoomPhase: the step of the estimation the room is in
+type RoomPhase string
+
+const (
+	// RoomPhaseWaitingForMembers: the room has not reached its capacity yet
+	RoomPhaseWaitingForMembers RoomPhase = "WAITING_FOR_MEMBERS"
+
+	// RoomPhaseAwaitingVoteStart: the admin has to enter the next ticket id
+	RoomPhaseAwaitingVoteStart RoomPhase = "AWAITING_VOTE_START"
+
+	// RoomPhaseVoting: the members are voting for the current ticket
+	RoomPhaseVoting RoomPhase = "VOTING"
+
+	// RoomPhaseAwaitingReveal: every member has voted, and the admin has to reveal the votes
+	RoomPhaseAwaitingReveal RoomPhase = "AWAITING_REVEAL"
+)
+
+type EventHanlder func(ctx context.Context, member *Member, event event.Event) error
+
+// FieldLimits: the maximum lengths, in characters, of the free text fields of the incoming events
+type FieldLimits struct {
+	TicketID int
+	Vote     int
+}
 
 type Room struct {
 	ID            string
 	MaxCapacity   int
+	CreatedAt     time.Time
 	EventHandlers map[event.EventType]EventHanlder
 
+	// Phase and CurrentTicketID describe where the room is in the estimation, CurrentTicketID
+	// is empty when no voting is in progress
+	Phase           RoomPhase
+	CurrentTicketID string
+	StateMutex      sync.RWMutex
+
 	// Key: MemberID, Value: *Member
 	Members sync.Map
 
+	// seatsMutex makes the capacity check and the seating of a member atomic, so that concurrent joins
+	// cannot go over the capacity of the room
+	seatsMutex sync.Mutex
+
 	// Key: TicketID, Value: slice of Vote
 	TicketVotesMap      map[string][]*Vote
 	TicketVotesMapMutex sync.Mutex
 
 	// Key: MemberID, Value: Vote
 	MemberVoteMap map[string]*Vote
+
+	// PasswordHash is the bcrypt hash of the room password, it is nil when the room is not password protected
+	PasswordHash []byte
+
+	// InviteSigner is used to mint and verify the invite tokens for the room
+	InviteSigner *invite.Signer
+
+	// Key: InviteID, Value: struct{}
+	RevokedInvites sync.Map
+
+	// Key: RemoteIP, Value: struct{}
+	BannedIPs sync.Map
+
+	// MemberEventLimiter limits the incoming events per member, it is keyed by the member id
+	MemberEventLimiter *ratelimit.Limiter
+
+	// BroadcastLimiter limits the incoming events which are broadcast to the whole room, it is keyed by the room id
+	BroadcastLimiter *ratelimit.Limiter
+
+	FieldLimits FieldLimits
+
+	// Webhooks delivers the webhook events of the room to the server wide subscriptions and to WebhookSubscriptions
+	Webhooks             *webhook.Dispatcher
+	WebhookSubscriptions []webhook.Subscription
+
+	// Tracker is the issue tracker of the tickets, the tracker integration is disabled when it is nil
+	Tracker tracker.Tracker
+
+	// Key: MemberID, Value: *DetachedMember, the members restored from a snapshot who have not reconnected yet
+	DetachedMembers sync.Map
+
+	// Results are the latest revealed tickets, from the oldest to the latest
+	Results      []TicketResult
+	ResultsMutex sync.Mutex
+
+	// Events numbers the events sent to the members of the room and keeps the latest ones, so that they can be replayed
+	Events *EventLog
+
+	// OnChange is called when the state of the room changes, so that its snapshot is written again.
+	// The snapshots are disabled when it is nil.
+	OnChange func()
 }
 
 func (r *Room) SetupEventHandlers() {
@@ -32,9 +163,16 @@ func (r *Room) SetupEventHandlers() {
 	r.EventHandlers[event.EventBeginVoting] = r.BeginVotingEventHandler
 	r.EventHandlers[event.EventMemberVoted] = r.MemberVotedEventHandler
 	r.EventHandlers[event.EventRevealVotes] = r.RevealVotesEventHandler
+	r.EventHandlers[event.EventCreateInvite] = r.CreateInviteEventHandler
+	r.EventHandlers[event.EventRevokeInvite] = r.RevokeInviteEventHandler
+	r.EventHandlers[event.EventKickMember] = r.KickMemberEventHandler
+	r.EventHandlers[event.EventMuteMember] = r.MuteMemberEventHandler
+	r.EventHandlers[event.EventSetFinalEstimate] = r.SetFinalEstimateEventHandler
+	r.EventHandlers[event.EventReplayEvents] = r.ReplayEventsEventHandler
+	r.EventHandlers[event.EventGetRoomState] = r.GetRoomStateEventHandler
 }
 
-func (r *Room) JoinRoomEventHandler(member *Member, receivedEvent event.Event) error {
+func (r *Room) JoinRoomEventHandler(ctx context.Context, member *Member, receivedEvent event.Event) error {
 	/*
 		When a member joins the room, the following things need to be done:
 
@@ -45,11 +183,22 @@ func (r *Room) JoinRoomEventHandler(member *Member, receivedEvent event.Event) e
 		3. The member's name needs to be logged which would indicate that they have joined the room
 
 		4. The existing members need to be informed that a new member has joined the room
+
+		5. The member needs to get the state of the room, so that it can render the room from where it is
 	*/
 
+	eventLogger := r.eventLogger(member, receivedEvent)
+
+	// a member who has resumed its seat after a restart has already been announced
+	if member.joined.Swap(true) {
+		eventLogger.Debug("Ignoring a JOIN_ROOM event, the member has already joined the room")
+		return nil
+	}
+	eventLogger.Info("Member joined the room", "is_room_admin", member.IsRoomAdmin)
+
 	// satisfies requirement 1
 	messageToBeSentToMember := fmt.Sprintf("🧠 You are now present in the room: %+v", r.ID)
-	member.SendRoomJoinUpdatesEvent(messageToBeSentToMember)
+	member.SendRoomJoinUpdatesEvent(ctx, messageToBeSentToMember, nil)
 
 	// satisfies requirement 2
 	alreadyPresentMembers := r.GetMembers()
@@ -61,7 +210,7 @@ func (r *Room) JoinRoomEventHandler(member *Member, receivedEvent event.Event) e
 				messageToBeSentToMember = fmt.Sprintf("👑👤 %s (ADMIN) joined", alreadyPresentMember.Name)
 			}
 
-			member.SendRoomJoinUpdatesEvent(messageToBeSentToMember)
+			member.SendRoomJoinUpdatesEvent(ctx, messageToBeSentToMember, alreadyPresentMember)
 		}
 	}
 
@@ -70,140 +219,761 @@ func (r *Room) JoinRoomEventHandler(member *Member, receivedEvent event.Event) e
 	if member.IsRoomAdmin {
 		messageToBeSentToMember = fmt.Sprintf("👑👤 %s (ADMIN) joined", member.Name)
 	}
-	member.SendRoomJoinUpdatesEvent(messageToBeSentToMember)
+	member.SendRoomJoinUpdatesEvent(ctx, messageToBeSentToMember, member)
 
-	// satisfies requirement 4
-	for _, alreadyPresentMember := range alreadyPresentMembers {
-		if alreadyPresentMember.ID != member.ID {
-			// a member who joins a room later cannot be an admin, hence only a single message type is needed here
-			messageToBeSentToAlreadyPresentMember := fmt.Sprintf("👤 %s joined", member.Name)
-			alreadyPresentMember.SendRoomJoinUpdatesEvent(messageToBeSentToAlreadyPresentMember)
-		}
-	}
+	// satisfies requirement 4, a member who joins a room later cannot be an admin, hence only a single message type is needed here
+	messageToBeSentToAlreadyPresentMembers := fmt.Sprintf("👤 %s joined", member.Name)
+	r.broadcastTo(ctx, alreadyPresentMembers, event.EventRoomJoinUpdates,
+		r.sharedPayload(event.EventRoomJoinUpdates, roomJoinUpdatesEventData(messageToBeSentToAlreadyPresentMembers, member)),
+		func(alreadyPresentMember *Member) bool { return alreadyPresentMember.ID != member.ID },
+	)
+
+	// satisfies requirement 5
+	r.sendRoomStateOnJoin(ctx, member)
 
 	// when a room's capacity is reached, the voting for the ticket needs to begin
 	if r.GetRoomMembersCount() == r.MaxCapacity {
+		eventLogger.Info("Room capacity reached", "max_capacity", r.MaxCapacity)
+		r.setState(RoomPhaseAwaitingVoteStart, "")
+
 		for _, member := range alreadyPresentMembers {
 			if member.IsRoomAdmin {
-				member.SendRoomCapacityReachedEvent("🟢 Room capacity reached. You will now be prompted to begin voting.")
-				member.SendBeginVotingPromptEvent("📝 Enter the ticket id for which you want to start voting:")
-				continue
+				member.SendRoomCapacityReachedEvent(ctx, "🟢 Room capacity reached. You will now be prompted to begin voting.")
+				member.SendBeginVotingPromptEvent(ctx, "📝 Enter the ticket id for which you want to start voting:")
 			}
-			member.SendRoomCapacityReachedEvent("🟢 Room capacity reached. Waiting for the admin to begin voting.")
 		}
+		r.broadcastTo(ctx, alreadyPresentMembers, event.EventRoomCapacityReached,
+			r.sharedPayload(event.EventRoomCapacityReached, event.RoomCapacityReachedEventData{Message: "🟢 Room capacity reached. Waiting for the admin to begin voting."}),
+			isNotRoomAdmin,
+		)
 	}
 
 	return nil
 }
 
-func (r *Room) BeginVotingEventHandler(member *Member, receivedEvent event.Event) error {
+func (r *Room) BeginVotingEventHandler(ctx context.Context, member *Member, receivedEvent event.Event) error {
+	eventLogger := r.eventLogger(member, receivedEvent)
+
 	var beginVotingEventData event.BeginVotingEventData
 	err := json.Unmarshal(receivedEvent.Data, &beginVotingEventData)
 	if err != nil {
-		log.Printf("unable to handle %+v event\n", receivedEvent.Type)
+		eventLogger.Warn("Unable to unmarshal the event data", logger.KeyError, err)
 		return err
 	}
 
-	// we got the ticket id for which the admin wants to begin voting
-	// now, we need to send a broadcast message to everyone in the room to ask for their vote
-	for _, member := range r.GetMembers() {
-		member.SendAskForVoteEvent(beginVotingEventData.TicketID)
+	if !r.validateField(ctx, member, receivedEvent, "ticket_id", beginVotingEventData.TicketID, r.FieldLimits.TicketID) {
+		return nil
 	}
 
+	metrics.RoomVoteRounds.WithLabelValues(r.ID).Inc()
+	r.setState(RoomPhaseVoting, beginVotingEventData.TicketID)
+	eventLogger.Info("Voting started", "ticket_id", beginVotingEventData.TicketID)
+
+	ticket := r.fetchTicket(ctx, eventLogger, beginVotingEventData.TicketID)
+	r.PublishWebhook(webhook.EventVotingStarted, webhook.VotingStartedData{
+		TicketID:    beginVotingEventData.TicketID,
+		TicketTitle: ticket.Title,
+		TicketURL:   ticket.URL,
+	})
+
+	// we got the ticket id for which the admin wants to begin voting
+	// now, we need to send a broadcast message to everyone in the room to ask for their vote,
+	// a muted member cannot vote, hence it is not asked to
+	r.Broadcast(ctx, event.EventAskForVote, askForVoteEventData(ticket), func(member *Member) bool {
+		return !member.IsMuted()
+	})
+
 	return nil
 }
 
-func (r *Room) MemberVotedEventHandler(member *Member, receivedEvent event.Event) error {
+func (r *Room) MemberVotedEventHandler(ctx context.Context, member *Member, receivedEvent event.Event) error {
+	eventLogger := r.eventLogger(member, receivedEvent)
+
 	var memberVotedEventData event.MemberVotedEventData
 	err := json.Unmarshal(receivedEvent.Data, &memberVotedEventData)
 	if err != nil {
-		log.Println("unable to handle MEMBER_VOTED event")
+		eventLogger.Warn("Unable to unmarshal the event data", logger.KeyError, err)
 		return err
 	}
 
+	if !r.validateField(ctx, member, receivedEvent, "ticket_id", memberVotedEventData.TicketID, r.FieldLimits.TicketID) ||
+		!r.validateField(ctx, member, receivedEvent, "vote", memberVotedEventData.Vote, r.FieldLimits.Vote) {
+		return nil
+	}
+
 	r.SaveTicketVote(member, memberVotedEventData.TicketID, memberVotedEventData.Vote)
+	eventLogger.Debug("Member voted", "ticket_id", memberVotedEventData.TicketID)
 
 	membersInRoom := r.GetMembers()
+	message := fmt.Sprintf("%v voted for the ticket id %v", member.Name, memberVotedEventData.TicketID)
 
+	// the clients of the first version of the protocol get a plain text message instead of an event
 	for _, memberInRoom := range membersInRoom {
-		memberInRoom.MessageChannel <- fmt.Sprintf("%v voted for the ticket id %v", member.Name, memberVotedEventData.TicketID)
-	}
-
-	if len(r.TicketVotesMap[memberVotedEventData.TicketID]) == r.GetRoomMembersCount() {
-		r.SaveAllMemberVotes(memberVotedEventData.TicketID)
-
-		for _, memberInRoom := range membersInRoom {
-			if memberInRoom.IsRoomAdmin {
-				messageToBeSentToAdminMember := fmt.Sprintf("✅ Voting has completed for the ticket id: %s\n> 👉 You will now be prompted for confirmation to reveal the votes.", memberVotedEventData.TicketID)
-				memberInRoom.SendVotingCompletedEvent(messageToBeSentToAdminMember)
-				memberInRoom.SendRevealVotesPromptEvent("", memberVotedEventData.TicketID)
-				continue
-			}
-			messageToBeSentToNonAdminMember := fmt.Sprintf("✅ Voting has completed for the ticket id: %s\n> ⏳ Waiting for the admin to reveal the votes.", memberVotedEventData.TicketID)
-			memberInRoom.SendVotingCompletedEvent(messageToBeSentToNonAdminMember)
+		if memberInRoom.ProtocolVersion < event.ProtocolVersion2 {
+			memberInRoom.sendPlainText(message)
 		}
 	}
+	r.broadcastTo(ctx, membersInRoom, event.EventMemberVoted,
+		r.sharedPayload(event.EventMemberVoted, event.MemberVoteCastEventData{
+			TicketID:   memberVotedEventData.TicketID,
+			MemberID:   member.ID,
+			MemberName: member.Name,
+			Message:    message,
+		}),
+		func(memberInRoom *Member) bool { return memberInRoom.ProtocolVersion >= event.ProtocolVersion2 },
+	)
+
+	r.completeVotingIfDone(ctx, eventLogger)
 
 	return nil
 }
 
-func (r *Room) RevealVotesEventHandler(member *Member, receivedEvent event.Event) error {
+func (r *Room) RevealVotesEventHandler(ctx context.Context, member *Member, receivedEvent event.Event) error {
+	eventLogger := r.eventLogger(member, receivedEvent)
+
 	var revealVotesEventData event.RevealVotesEventData
 	err := json.Unmarshal(receivedEvent.Data, &revealVotesEventData)
 	if err != nil {
-		log.Println("unable to handle REVEAL_VOTES event")
+		eventLogger.Warn("Unable to unmarshal the event data", logger.KeyError, err)
 		return err
 	}
 
+	if !r.validateField(ctx, member, receivedEvent, "ticket_id", revealVotesEventData.TicketID, r.FieldLimits.TicketID) {
+		return nil
+	}
+
 	// event received to reveal votesm broadcast a message to all participants, including the admin,
 	// and reveal the votes for the given ticket ID
-	memberVotesMapInterface := make(map[string]interface{}, len(r.MemberVoteMap))
+	r.TicketVotesMapMutex.Lock()
+	memberVotes := make(map[string]*Vote, len(r.MemberVoteMap))
+	revealedVotes := make([]webhook.Vote, 0, len(r.MemberVoteMap))
 	for memberID, vote := range r.MemberVoteMap {
-		memberVotesMapInterface[memberID] = vote
+		memberVotes[memberID] = vote
+		revealedVotes = append(revealedVotes, webhook.Vote{MemberID: vote.MemberID, MemberName: vote.MemberName, Value: vote.Value})
 	}
+	r.TicketVotesMapMutex.Unlock()
+	r.saveResult(revealVotesEventData.TicketID, memberVotes)
 
-	for _, memberInRoom := range r.GetMembers() {
-		if memberInRoom.IsRoomAdmin {
-			memberInRoom.SendVotesRevealedEvent(revealVotesEventData.TicketID, memberVotesMapInterface)
+	membersInRoom := r.GetMembers()
+
+	r.broadcastTo(ctx, membersInRoom, event.EventVotesRevealed,
+		r.versionedPayload(event.EventVotesRevealed, func(protocolVersion int) any {
+			return votesRevealedEventData(protocolVersion, revealVotesEventData.TicketID, memberVotes)
+		}),
+		nil,
+	)
 
+	for _, memberInRoom := range membersInRoom {
+		if memberInRoom.IsRoomAdmin {
 			// send another prompt to the admin to enter the ticket id for the next vote
-			memberInRoom.SendBeginVotingPromptEvent("📝 Enter the ticket id for which you want to start voting next:")
-			continue
+			memberInRoom.SendBeginVotingPromptEvent(ctx, "📝 Enter the ticket id for which you want to start voting next:")
 		}
-
-		memberInRoom.SendVotesRevealedEvent(revealVotesEventData.TicketID, memberVotesMapInterface)
-
-		// also send message to the member that they need to wait for the admin to begin voting for the next ticket
-		memberInRoom.SendAwaitingAdminVoteStartEvent("⏳ Waiting for the admin to begin voting for next ticket")
 	}
 
+	// also send message to the members that they need to wait for the admin to begin voting for the next ticket
+	r.broadcastTo(ctx, membersInRoom, event.EventAwaitingAdminVoteStart,
+		r.sharedPayload(event.EventAwaitingAdminVoteStart, event.AwaitingAdminVoteStartEventData{Message: "⏳ Waiting for the admin to begin voting for next ticket"}),
+		isNotRoomAdmin,
+	)
+
 	// delete the TicketID entry from the TicketVotesMap
+	r.TicketVotesMapMutex.Lock()
 	delete(r.TicketVotesMap, revealVotesEventData.TicketID)
+	r.TicketVotesMapMutex.Unlock()
+	r.setState(RoomPhaseAwaitingVoteStart, "")
+
+	eventLogger.Info("Votes revealed", "ticket_id", revealVotesEventData.TicketID)
+	r.PublishWebhook(webhook.EventVotesRevealed, webhook.VotesRevealedData{TicketID: revealVotesEventData.TicketID, Votes: revealedVotes})
+
+	return nil
+}
+
+func (r *Room) CreateInviteEventHandler(ctx context.Context, member *Member, receivedEvent event.Event) error {
+	eventLogger := r.eventLogger(member, receivedEvent)
+
+	if !r.requireAdmin(ctx, member, receivedEvent) {
+		return nil
+	}
+
+	var createInviteEventData event.CreateInviteEventData
+	err := json.Unmarshal(receivedEvent.Data, &createInviteEventData)
+	if err != nil {
+		eventLogger.Warn("Unable to unmarshal the event data", logger.KeyError, err)
+		return err
+	}
+
+	inviteTTL := defaultInviteTTL
+	if createInviteEventData.TTLSeconds > 0 {
+		inviteTTL = min(time.Duration(createInviteEventData.TTLSeconds)*time.Second, maxInviteTTL)
+	}
 
+	token, claims := r.InviteSigner.Mint(r.ID, inviteTTL)
+	eventLogger.Info("Invite created", "invite_id", claims.ID, "expires_at", claims.ExpiresAt)
+	member.SendInviteCreatedEvent(ctx, claims.ID, token, claims.ExpiresAt)
+
+	return nil
+}
+
+func (r *Room) RevokeInviteEventHandler(ctx context.Context, member *Member, receivedEvent event.Event) error {
+	eventLogger := r.eventLogger(member, receivedEvent)
+
+	if !r.requireAdmin(ctx, member, receivedEvent) {
+		return nil
+	}
+
+	var revokeInviteEventData event.RevokeInviteEventData
+	err := json.Unmarshal(receivedEvent.Data, &revokeInviteEventData)
+	if err != nil {
+		eventLogger.Warn("Unable to unmarshal the event data", logger.KeyError, err)
+		return err
+	}
+
+	r.RevokedInvites.Store(revokeInviteEventData.InviteID, struct{}{})
+	eventLogger.Info("Invite revoked", "invite_id", revokeInviteEventData.InviteID)
+	member.SendInviteRevokedEvent(ctx, revokeInviteEventData.InviteID)
+
+	return nil
+}
+
+func (r *Room) KickMemberEventHandler(ctx context.Context, member *Member, receivedEvent event.Event) error {
+	eventLogger := r.eventLogger(member, receivedEvent)
+
+	if !r.requireAdmin(ctx, member, receivedEvent) {
+		return nil
+	}
+
+	var kickMemberEventData event.KickMemberEventData
+	err := json.Unmarshal(receivedEvent.Data, &kickMemberEventData)
+	if err != nil {
+		eventLogger.Warn("Unable to unmarshal the event data", logger.KeyError, err)
+		return err
+	}
+
+	kickedMember, err := r.Kick(ctx, kickMemberEventData.MemberID, kickMemberEventData.Ban, "")
+	if err != nil {
+		r.sendTargetError(ctx, member, receivedEvent, err)
+		return nil
+	}
+
+	eventLogger.Info("Member kicked by the admin", "kicked_member_id", kickedMember.ID, "banned", kickMemberEventData.Ban)
+	return nil
+}
+
+func (r *Room) MuteMemberEventHandler(ctx context.Context, member *Member, receivedEvent event.Event) error {
+	eventLogger := r.eventLogger(member, receivedEvent)
+
+	if !r.requireAdmin(ctx, member, receivedEvent) {
+		return nil
+	}
+
+	var muteMemberEventData event.MuteMemberEventData
+	err := json.Unmarshal(receivedEvent.Data, &muteMemberEventData)
+	if err != nil {
+		eventLogger.Warn("Unable to unmarshal the event data", logger.KeyError, err)
+		return err
+	}
+
+	mutedMember, err := r.Mute(ctx, muteMemberEventData.MemberID, muteMemberEventData.Muted)
+	if err != nil {
+		r.sendTargetError(ctx, member, receivedEvent, err)
+		return nil
+	}
+
+	eventLogger.Info("Member muted by the admin", "muted_member_id", mutedMember.ID, "muted", muteMemberEventData.Muted)
+	return nil
+}
+
+func (r *Room) SetFinalEstimateEventHandler(ctx context.Context, member *Member, receivedEvent event.Event) error {
+	eventLogger := r.eventLogger(member, receivedEvent)
+
+	if !r.requireAdmin(ctx, member, receivedEvent) {
+		return nil
+	}
+
+	var setFinalEstimateEventData event.SetFinalEstimateEventData
+	err := json.Unmarshal(receivedEvent.Data, &setFinalEstimateEventData)
+	if err != nil {
+		eventLogger.Warn("Unable to unmarshal the event data", logger.KeyError, err)
+		return err
+	}
+
+	if !r.validateField(ctx, member, receivedEvent, "ticket_id", setFinalEstimateEventData.TicketID, r.FieldLimits.TicketID) ||
+		!r.validateField(ctx, member, receivedEvent, "estimate", setFinalEstimateEventData.Estimate, r.FieldLimits.Vote) {
+		return nil
+	}
+
+	eventLogger.Info("Final estimate set", "ticket_id", setFinalEstimateEventData.TicketID, "estimate", setFinalEstimateEventData.Estimate)
+	r.setFinalEstimate(setFinalEstimateEventData.TicketID, setFinalEstimateEventData.Estimate)
+	syncedToTracker := r.syncEstimateToTracker(ctx, member, receivedEvent, setFinalEstimateEventData.TicketID, setFinalEstimateEventData.Estimate)
+	r.PublishWebhook(webhook.EventEstimateFinalized, webhook.EstimateFinalizedData{
+		TicketID: setFinalEstimateEventData.TicketID,
+		Estimate: setFinalEstimateEventData.Estimate,
+	})
+
+	messageToBeSentToMembers := fmt.Sprintf("🎯 The final estimate for the ticket id %s is %s", setFinalEstimateEventData.TicketID, setFinalEstimateEventData.Estimate)
+
+	r.Broadcast(ctx, event.EventFinalEstimateSet, event.FinalEstimateSetEventData{
+		TicketID:        setFinalEstimateEventData.TicketID,
+		Estimate:        setFinalEstimateEventData.Estimate,
+		Message:         messageToBeSentToMembers,
+		SyncedToTracker: syncedToTracker,
+	}, nil)
+
+	return nil
+}
+
+func (r *Room) ReplayEventsEventHandler(ctx context.Context, member *Member, receivedEvent event.Event) error {
+	var replayEventsEventData event.ReplayEventsEventData
+	err := json.Unmarshal(receivedEvent.Data, &replayEventsEventData)
+	if err != nil {
+		r.eventLogger(member, receivedEvent).Warn("Unable to unmarshal the event data", logger.KeyError, err)
+		return err
+	}
+
+	r.replayEvents(ctx, member, receivedEvent.Type, replayEventsEventData.SinceSeq, math.MaxUint64)
 	return nil
 }
 
-func (r *Room) HandleEvent(member *Member, receivedEvent event.Event) error {
+// ReplayMissedEvents: sends a member who resumes its seat the events sent to its seat after the given sequence
+// number, and before it reconnected
+func (r *Room) ReplayMissedEvents(ctx context.Context, member *Member, sinceSeq uint64) {
+	r.replayEvents(ctx, member, string(event.EventJoinRoom), sinceSeq, member.seatSeq)
+}
+
+// replayEvents: the member is informed with an ERROR event when some of the events are not in the log of the room anymore
+func (r *Room) replayEvents(ctx context.Context, member *Member, eventType string, sinceSeq uint64, untilSeq uint64) {
+	missedEvents, ok := r.Events.Between(member.ID, sinceSeq, untilSeq)
+	if !ok {
+		member.Logger.Info("The events to replay are not available anymore", "since_seq", sinceSeq, logger.KeyErrorCode, event.ErrorCodeReplayUnavailable)
+		member.SendErrorEvent(ctx, event.ErrorCodeReplayUnavailable, eventType, fmt.Sprintf("⚠️ The events sent after the sequence number %d are not available anymore", sinceSeq))
+		return
+	}
+
+	member.Logger.Debug("Replaying the events", "since_seq", sinceSeq, "events", len(missedEvents))
+	member.replayEvents(missedEvents)
+}
+
+// HandleEvent: dispatches an incoming event to its handler, every incoming event starts a new trace
+func (r *Room) HandleEvent(ctx context.Context, member *Member, receivedEvent event.Event) error {
+	// the event type is provided by the client, hence the unsupported ones share a single label
+	// so that a client cannot create an unbounded number of metric series
+	eventTypeLabel := "UNKNOWN"
+	if event.IsIncomingEventTypeValid(receivedEvent.Type) {
+		eventTypeLabel = receivedEvent.Type
+	}
+	metrics.EventsReceived.WithLabelValues(eventTypeLabel).Inc()
+
+	ctx, span := tracing.Start(ctx, "handle "+eventTypeLabel,
+		tracing.AttributeRoomID.String(r.ID),
+		tracing.AttributeMemberID.String(member.ID),
+		tracing.AttributeEventType.String(eventTypeLabel),
+	)
+	defer span.End()
+
+	if !r.allowEvent(ctx, member, receivedEvent) {
+		// the event is dropped, but the member stays connected
+		return nil
+	}
+
+	if member.IsMuted() && !mutedMemberAllowedEventTypes[event.EventType(receivedEvent.Type)] {
+		r.eventLogger(member, receivedEvent).Info("Dropping an event sent by a muted member", logger.KeyErrorCode, event.ErrorCodeMemberMuted)
+		member.SendErrorEvent(ctx, event.ErrorCodeMemberMuted, receivedEvent.Type, "🔇 You have been muted by the admin.")
+		return nil
+	}
+
+	if !r.validatePayload(ctx, member, receivedEvent) {
+		return nil
+	}
+
 	if event.IsIncomingEventTypeValid(receivedEvent.Type) {
 		eventHandler, ok := r.EventHandlers[event.EventType(receivedEvent.Type)]
 		if ok {
-			err := eventHandler(member, receivedEvent)
+			startedAt := time.Now()
+			err := eventHandler(ctx, member, receivedEvent)
+			metrics.EventHandlerDuration.WithLabelValues(eventTypeLabel).Observe(time.Since(startedAt).Seconds())
 			if err != nil {
-				return err
+				metrics.Errors.WithLabelValues("HANDLER_ERROR").Inc()
+				tracing.RecordError(span, err)
+				return r.rejectFailedEvent(ctx, member, receivedEvent, err)
 			}
+			r.changed()
 			return nil
 		}
 
-		log.Printf("the handler for the %+v event is not set", receivedEvent.Type)
-		return event.EventHandlerNotSetError
+		r.eventLogger(member, receivedEvent).Error("The handler for the event is not set")
+		tracing.RecordError(span, event.EventHandlerNotSetError)
+		return r.rejectFailedEvent(ctx, member, receivedEvent, event.EventHandlerNotSetError)
+	}
+
+	r.eventLogger(member, receivedEvent).Warn("The event is not supported", logger.KeyErrorCode, event.ErrorCodeUnsupportedEvent)
+	tracing.RecordError(span, event.EventNotSupportedError)
+	member.SendErrorEvent(ctx, event.ErrorCodeUnsupportedEvent, receivedEvent.Type, fmt.Sprintf("⚠️ %s is not a supported event", receivedEvent.Type))
+	return nil
+}
+
+// rejectFailedEvent: informs the member that its event could not be handled. The payloads which cannot be read are the
+// fault of the client, hence its connection stays open, the other errors are returned so that the connection is closed.
+func (r *Room) rejectFailedEvent(ctx context.Context, member *Member, receivedEvent event.Event, err error) error {
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	if errors.As(err, &syntaxError) || errors.As(err, &unmarshalTypeError) {
+		member.SendErrorEvent(ctx, event.ErrorCodeInvalidPayload, receivedEvent.Type, "⚠️ The payload of the event cannot be read")
+		return nil
+	}
+
+	member.SendErrorEvent(ctx, event.ErrorCodeInternal, receivedEvent.Type, "⚠️ Something went wrong on the server, please reconnect")
+	return err
+}
+
+// allowEvent: applies the per member and the per room rate limits to an incoming event, the member is
+// informed with a RATE_LIMITED event when the event is dropped
+func (r *Room) allowEvent(ctx context.Context, member *Member, receivedEvent event.Event) bool {
+	if !r.MemberEventLimiter.Allow(member.ID) {
+		r.eventLogger(member, receivedEvent).Warn("Member is sending too many events, dropping the event", logger.KeyErrorCode, event.EventRateLimited)
+		member.SendRateLimitedEvent(ctx, rateLimitScopeMember, receivedEvent.Type, "⏳ You are sending events too quickly. Please slow down and try again.")
+		return false
+	}
+
+	if broadcastEventTypes[event.EventType(receivedEvent.Type)] && !r.BroadcastLimiter.Allow(r.ID) {
+		r.eventLogger(member, receivedEvent).Warn("Room is broadcasting too many events, dropping the event", logger.KeyErrorCode, event.EventRateLimited)
+		member.SendRateLimitedEvent(ctx, rateLimitScopeRoom, receivedEvent.Type, "⏳ This room is too busy right now. Please wait a moment and try again.")
+		return false
 	}
 
-	log.Printf("the %+v event is not supported", receivedEvent.Type)
-	return event.EventNotSupportedError
+	return true
 }
 
-func (r *Room) AddMember(member *Member) {
+// validatePayload: checks the payload of an incoming event against the schema of the protocol before it is handled,
+// the member is informed with an ERROR event when it does not match
+func (r *Room) validatePayload(ctx context.Context, member *Member, receivedEvent event.Event) bool {
+	validationError := schema.Protocol().ValidateIncoming(receivedEvent.Type, receivedEvent.Data)
+	if validationError == nil {
+		return true
+	}
+
+	errorCode := event.ErrorCodeInvalidPayload
+	if validationError.Missing {
+		errorCode = event.ErrorCodeFieldRequired
+	}
+
+	r.eventLogger(member, receivedEvent).Warn("Got an event whose payload does not match the schema", "field", validationError.Field, logger.KeyError, validationError, logger.KeyErrorCode, errorCode)
+	member.SendErrorEvent(ctx, errorCode, receivedEvent.Type, fmt.Sprintf("⚠️ %s", validationError.Message))
+	return false
+}
+
+// requireAdmin: checks that the event has been sent by the admin of the room, the member is
+// informed with an ERROR event when it has not
+func (r *Room) requireAdmin(ctx context.Context, member *Member, receivedEvent event.Event) bool {
+	if member.IsRoomAdmin {
+		return true
+	}
+
+	r.eventLogger(member, receivedEvent).Warn("Member sent an admin only event", logger.KeyErrorCode, event.ErrorCodeNotRoomAdmin)
+	member.SendErrorEvent(ctx, event.ErrorCodeNotRoomAdmin, receivedEvent.Type, "⛔ Only the admin of the room can do this.")
+	return false
+}
+
+// sendTargetError: informs the admin that the member targeted by a KICK_MEMBER or a MUTE_MEMBER event cannot be found or acted upon
+func (r *Room) sendTargetError(ctx context.Context, member *Member, receivedEvent event.Event, err error) {
+	errorCode := event.ErrorCodeInvalidTarget
+	if errors.Is(err, ErrMemberNotFound) {
+		errorCode = event.ErrorCodeMemberNotFound
+	}
+
+	r.eventLogger(member, receivedEvent).Warn("Unable to act on the member", logger.KeyError, err, logger.KeyErrorCode, errorCode)
+	member.SendErrorEvent(ctx, errorCode, receivedEvent.Type, fmt.Sprintf("⚠️ %s", err.Error()))
+}
+
+// fetchTicket: returns the details of the ticket from the issue tracker. The voting is not blocked by the tracker,
+// hence when the ticket cannot be fetched, the returned ticket only has its id.
+func (r *Room) fetchTicket(ctx context.Context, eventLogger *slog.Logger, ticketID string) tracker.Ticket {
+	ticket := tracker.Ticket{ID: ticketID}
+	if r.Tracker == nil {
+		return ticket
+	}
+
+	trackerCtx, cancel := context.WithTimeout(ctx, trackerTimeout)
+	defer cancel()
+
+	fetchedTicket, err := r.Tracker.FetchTicket(trackerCtx, ticketID)
+	if err != nil {
+		eventLogger.Warn("Unable to fetch the ticket from the tracker", "ticket_id", ticketID, logger.KeyError, err)
+		return ticket
+	}
+
+	// the id entered by the admin is kept, so that the votes of the members match it
+	fetchedTicket.ID = ticketID
+	return fetchedTicket
+}
+
+// syncEstimateToTracker: writes the final estimate to the issue tracker, the admin is told when it fails
+func (r *Room) syncEstimateToTracker(ctx context.Context, member *Member, receivedEvent event.Event, ticketID string, estimate string) bool {
+	if r.Tracker == nil {
+		return false
+	}
+
+	trackerCtx, cancel := context.WithTimeout(ctx, trackerTimeout)
+	defer cancel()
+
+	err := r.Tracker.SetEstimate(trackerCtx, ticketID, estimate)
+	if err != nil {
+		r.eventLogger(member, receivedEvent).Warn("Unable to write the estimate to the tracker", "ticket_id", ticketID, logger.KeyError, err)
+		member.SendErrorEvent(ctx, event.ErrorCodeTrackerSyncFailed, receivedEvent.Type,
+			fmt.Sprintf("❌ The final estimate could not be written to the issue tracker: %v", err))
+		return false
+	}
+
+	return true
+}
+
+// PublishWebhook: queues a webhook event of the room, it never blocks
+func (r *Room) PublishWebhook(eventType webhook.EventType, data any) {
+	r.Webhooks.Publish(r.WebhookSubscriptions, webhook.Event{
+		Type:   eventType,
+		RoomID: r.ID,
+		Data:   data,
+	})
+}
+
+// eventLogger: returns the member's logger with the type of the event being handled attached to it
+func (r *Room) eventLogger(member *Member, receivedEvent event.Event) *slog.Logger {
+	return member.Logger.With(logger.KeyEventType, receivedEvent.Type)
+}
+
+// validateField: checks that a free text field of an incoming event is present and not too long, the member
+// is informed with an ERROR event when it is not
+func (r *Room) validateField(ctx context.Context, member *Member, receivedEvent event.Event, fieldName string, value string, maxLength int) bool {
+	if value == "" {
+		r.eventLogger(member, receivedEvent).Warn("Got an empty field", "field", fieldName, logger.KeyErrorCode, event.ErrorCodeFieldRequired)
+		member.SendErrorEvent(ctx, event.ErrorCodeFieldRequired, receivedEvent.Type, fmt.Sprintf("⚠️ %s cannot be empty", fieldName))
+		return false
+	}
+
+	if utf8.RuneCountInString(value) > maxLength {
+		r.eventLogger(member, receivedEvent).Warn("Got a field which is too long", "field", fieldName, "max_length", maxLength, logger.KeyErrorCode, event.ErrorCodeFieldTooLong)
+		member.SendErrorEvent(ctx, event.ErrorCodeFieldTooLong, receivedEvent.Type, fmt.Sprintf("⚠️ %s cannot be longer than %d characters", fieldName, maxLength))
+		return false
+	}
+
+	return true
+}
+
+// State: returns the phase of the room and the ticket being estimated, if any
+func (r *Room) State() (RoomPhase, string) {
+	r.StateMutex.RLock()
+	defer r.StateMutex.RUnlock()
+
+	return r.Phase, r.CurrentTicketID
+}
+
+// transitionState: moves the room to the given phase, only if it is still in the expected phase for the ticket
+func (r *Room) transitionState(expectedPhase RoomPhase, phase RoomPhase, currentTicketID string) bool {
+	r.StateMutex.Lock()
+	defer r.StateMutex.Unlock()
+
+	if r.Phase != expectedPhase || r.CurrentTicketID != currentTicketID {
+		return false
+	}
+
+	r.Phase = phase
+	return true
+}
+
+func (r *Room) setState(phase RoomPhase, currentTicketID string) {
+	r.StateMutex.Lock()
+	defer r.StateMutex.Unlock()
+
+	r.Phase = phase
+	r.CurrentTicketID = currentTicketID
+}
+
+// Close: informs every member that the room has been closed and disconnects them, the admin is
+// disconnected last so that the other members receive the event before the room is torn down
+func (r *Room) Close(ctx context.Context, message string) {
+	membersInRoom := r.GetMembers()
+
+	r.broadcastTo(ctx, membersInRoom, event.EventRoomClosed,
+		r.sharedPayload(event.EventRoomClosed, event.RoomClosedEventData{Message: message}),
+		nil,
+	)
+
+	var admin *Member
+	for _, memberInRoom := range membersInRoom {
+		if memberInRoom.IsRoomAdmin {
+			admin = memberInRoom
+			continue
+		}
+
+		// the member is removed first, so that the admin's disconnection does not close its connection
+		// before the ROOM_CLOSED event is written
+		r.RemoveMember(memberInRoom.ID)
+		memberInRoom.Disconnect(transport.CloseNormalClosure, "room closed")
+	}
+
+	if admin != nil {
+		admin.Disconnect(transport.CloseNormalClosure, "room closed")
+	}
+}
+
+// Evict: disconnects every member with the 1012 (service restart) close code, without closing the room,
+// so that they reconnect to the instance which owns the room now
+func (r *Room) Evict(reason string) {
+	for _, memberInRoom := range r.GetMembers() {
+		r.RemoveMember(memberInRoom.ID)
+		memberInRoom.Disconnect(transport.CloseServiceRestart, reason)
+	}
+}
+
+// Kick: removes a member from the room and closes their connection, every member of the room, including the removed one,
+// is informed with a MEMBER_KICKED event. A banned member cannot join the room again from the same IP address.
+// When the message is empty, a default one is sent.
+func (r *Room) Kick(ctx context.Context, memberID string, ban bool, message string) (*Member, error) {
+	value, ok := r.Members.Load(memberID)
+	if !ok {
+		return nil, ErrMemberNotFound
+	}
+
+	kickedMember := value.(*Member)
+	if kickedMember.IsRoomAdmin {
+		return nil, ErrCannotKickAdmin
+	}
+
+	if ban {
+		r.BannedIPs.Store(kickedMember.RemoteIP, struct{}{})
+	}
+
+	if message == "" {
+		message = fmt.Sprintf("👢 %s has been removed from the room.", kickedMember.Name)
+		if ban {
+			message = fmt.Sprintf("⛔ %s has been banned from the room.", kickedMember.Name)
+		}
+	}
+
+	r.RemoveMember(kickedMember.ID)
+	r.removeVotes(kickedMember.ID)
+	membersInRoom := r.GetMembers()
+
+	r.broadcastTo(ctx, append([]*Member{kickedMember}, membersInRoom...), event.EventMemberKicked,
+		r.sharedPayload(event.EventMemberKicked, event.MemberKickedEventData{
+			MemberID:   kickedMember.ID,
+			MemberName: kickedMember.Name,
+			Banned:     ban,
+			Message:    message,
+		}),
+		nil,
+	)
+
+	closeReason := "removed from the room"
+	if ban {
+		closeReason = "banned from the room"
+	}
+	kickedMember.Disconnect(transport.ClosePolicyViolation, closeReason)
+
+	// the kicked member may have been the last one the room was waiting for
+	r.completeVotingIfDone(ctx, kickedMember.Logger)
+
+	return kickedMember, nil
+}
+
+// Mute: silences or unsilences a member, a muted member stays in the room but cannot vote, and the voting
+// does not wait for them. Every member of the room is informed with a MEMBER_MUTED event.
+func (r *Room) Mute(ctx context.Context, memberID string, muted bool) (*Member, error) {
+	value, ok := r.Members.Load(memberID)
+	if !ok {
+		return nil, ErrMemberNotFound
+	}
+
+	mutedMember := value.(*Member)
+	if mutedMember.IsRoomAdmin {
+		return nil, ErrCannotMuteAdmin
+	}
+
+	mutedMember.SetMuted(muted)
+	r.changed()
+
+	message := fmt.Sprintf("🔇 %s has been muted.", mutedMember.Name)
+	if !muted {
+		message = fmt.Sprintf("🔊 %s has been unmuted.", mutedMember.Name)
+	}
+
+	r.Broadcast(ctx, event.EventMemberMuted, event.MemberMutedEventData{
+		MemberID:   mutedMember.ID,
+		MemberName: mutedMember.Name,
+		Muted:      muted,
+		Message:    message,
+	}, nil)
+
+	if muted {
+		// the muted member may have been the last one the room was waiting for
+		r.completeVotingIfDone(ctx, mutedMember.Logger)
+	}
+
+	return mutedMember, nil
+}
+
+// IsBanned: reports whether the clients connecting from the IP address have been banned from the room
+func (r *Room) IsBanned(remoteIP string) bool {
+	_, isBanned := r.BannedIPs.Load(remoteIP)
+	return isBanned
+}
+
+// completeVotingIfDone: ends the voting for the current ticket once every member who is not muted has voted,
+// the admin is then prompted to reveal the votes
+func (r *Room) completeVotingIfDone(ctx context.Context, eventLogger *slog.Logger) {
+	phase, ticketID := r.State()
+	if phase != RoomPhaseVoting {
+		return
+	}
+
+	membersInRoom := r.GetMembers()
+	for _, memberInRoom := range membersInRoom {
+		if !memberInRoom.IsMuted() && !r.HasVoted(ticketID, memberInRoom.ID) {
+			return
+		}
+	}
+
+	// the last two votes can be handled at the same time, only one of them completes the voting
+	if !r.transitionState(RoomPhaseVoting, RoomPhaseAwaitingReveal, ticketID) {
+		return
+	}
+
+	r.SaveAllMemberVotes(ticketID)
+	eventLogger.Info("Voting completed", "ticket_id", ticketID)
+
+	for _, memberInRoom := range membersInRoom {
+		if memberInRoom.IsRoomAdmin {
+			messageToBeSentToAdminMember := fmt.Sprintf("✅ Voting has completed for the ticket id: %s\n> 👉 You will now be prompted for confirmation to reveal the votes.", ticketID)
+			memberInRoom.SendVotingCompletedEvent(ctx, messageToBeSentToAdminMember)
+			memberInRoom.SendRevealVotesPromptEvent(ctx, "", ticketID)
+		}
+	}
+	messageToBeSentToNonAdminMembers := fmt.Sprintf("✅ Voting has completed for the ticket id: %s\n> ⏳ Waiting for the admin to reveal the votes.", ticketID)
+	r.broadcastTo(ctx, membersInRoom, event.EventVotingCompleted,
+		r.sharedPayload(event.EventVotingCompleted, event.VotingCompletedEventData{Message: messageToBeSentToNonAdminMembers}),
+		isNotRoomAdmin,
+	)
+}
+
+// AddMember: seats the member in the room, it returns ErrRoomFull when every seat is taken, by the connected members
+// and by the detached members who may come back. A member who resumes its seat already holds one, its detached seat.
+func (r *Room) AddMember(member *Member) error {
+	r.seatsMutex.Lock()
+	defer r.seatsMutex.Unlock()
+
+	if member.resumed {
+		r.DetachedMembers.Delete(member.ID)
+	} else if r.GetRoomMembersCount()+r.DetachedMembersCount() >= r.MaxCapacity {
+		return ErrRoomFull
+	}
+
+	// a member who resumes its seat carries on from the latest event sent to its seat
+	member.eventLog = r.Events
+	member.lastSeq = r.Events.LastSeqOf(member.ID)
+	member.seatSeq = member.lastSeq
+
 	r.Members.Store(member.ID, member)
+	r.changed()
+	return nil
 }
 
 func (r *Room) GetRoomMembersCount() int {
@@ -219,6 +989,7 @@ func (r *Room) GetRoomMembersCount() int {
 
 func (r *Room) RemoveMember(memberID string) {
 	r.Members.Delete(memberID)
+	r.changed()
 }
 
 func (r *Room) GetMembers() []*Member {
@@ -246,7 +1017,39 @@ func (r *Room) SaveTicketVote(member *Member, ticketID string, voteValue string)
 	})
 }
 
+// removeVotes: removes the votes of a member who has left the room
+func (r *Room) removeVotes(memberID string) {
+	r.TicketVotesMapMutex.Lock()
+	defer r.TicketVotesMapMutex.Unlock()
+
+	for ticketID, votes := range r.TicketVotesMap {
+		remainingVotes := votes[:0]
+		for _, vote := range votes {
+			if vote.MemberID != memberID {
+				remainingVotes = append(remainingVotes, vote)
+			}
+		}
+		r.TicketVotesMap[ticketID] = remainingVotes
+	}
+}
+
+// HasVoted: reports whether the member has voted for the ticket
+func (r *Room) HasVoted(ticketID string, memberID string) bool {
+	r.TicketVotesMapMutex.Lock()
+	defer r.TicketVotesMapMutex.Unlock()
+
+	for _, vote := range r.TicketVotesMap[ticketID] {
+		if vote.MemberID == memberID {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Room) SaveAllMemberVotes(ticketID string) {
+	r.TicketVotesMapMutex.Lock()
+	defer r.TicketVotesMapMutex.Unlock()
+
 	for _, vote := range r.TicketVotesMap[ticketID] {
 		r.MemberVoteMap[vote.MemberID] = &Vote{
 			Value:      vote.Value,
@@ -255,3 +1058,63 @@ func (r *Room) SaveAllMemberVotes(ticketID string) {
 		}
 	}
 }
+
+// SetPassword: protects the room with the given password, only its bcrypt hash is kept in memory
+func (r *Room) SetPassword(password string) error {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	r.PasswordHash = passwordHash
+	return nil
+}
+
+func (r *Room) IsPasswordProtected() bool {
+	return len(r.PasswordHash) > 0
+}
+
+// Authorize: checks the credentials provided by a client who wants to join the room.
+// A valid invite token is always accepted, otherwise the password must match when the room is password protected.
+func (r *Room) Authorize(password string, inviteToken string) error {
+	if inviteToken != "" {
+		return r.verifyInviteToken(inviteToken)
+	}
+
+	if !r.IsPasswordProtected() {
+		return nil
+	}
+
+	err := bcrypt.CompareHashAndPassword(r.PasswordHash, []byte(password))
+	if err != nil {
+		return ErrInvalidRoomCredentials
+	}
+	return nil
+}
+
+func (r *Room) verifyInviteToken(inviteToken string) error {
+	claims, err := r.InviteSigner.Verify(inviteToken)
+	if err != nil {
+		return err
+	}
+
+	if claims.RoomID != r.ID {
+		return ErrInviteForAnotherRoom
+	}
+
+	return r.CheckInvite(claims.ID)
+}
+
+// CheckInvite: returns ErrInviteRevoked when the invite has been revoked by the admin, the invite id is empty when
+// the client has not joined with an invite token
+func (r *Room) CheckInvite(inviteID string) error {
+	if inviteID == "" {
+		return nil
+	}
+
+	_, isRevoked := r.RevokedInvites.Load(inviteID)
+	if isRevoked {
+		return ErrInviteRevoked
+	}
+
+	return nil
+}
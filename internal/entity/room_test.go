@@ -0,0 +1,535 @@
+package entity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skamranahmed/estimatex-server/internal/event"
+	"github.com/skamranahmed/estimatex-server/internal/transport"
+)
+
+// newTestRoom: a room set up like the rooms of the session manager, without the limiters, the webhooks and the tracker
+func newTestRoom(maxCapacity int) *Room {
+	room := &Room{
+		ID:             "ROOM01",
+		MaxCapacity:    maxCapacity,
+		Phase:          RoomPhaseWaitingForMembers,
+		EventHandlers:  make(map[event.EventType]EventHanlder),
+		TicketVotesMap: make(map[string][]*Vote),
+		MemberVoteMap:  make(map[string]*Vote),
+		FieldLimits:    FieldLimits{TicketID: 128, Vote: 16},
+		Events:         NewEventLog(1024),
+	}
+	room.SetupEventHandlers()
+	return room
+}
+
+func TestAddMemberDoesNotGoOverTheCapacity(t *testing.T) {
+	const maxCapacity = 3
+	room := newTestRoom(maxCapacity)
+
+	var mutex sync.Mutex
+	seatedCount := 0
+
+	var joins sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		joins.Add(1)
+		go func() {
+			defer joins.Done()
+
+			member := NewMember(fmt.Sprintf("member-%d", i), transport.NewMemoryConnection("10.0.0.1"), room.ID, false, slog.Default())
+			err := room.AddMember(member)
+			if err != nil && !errors.Is(err, ErrRoomFull) {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if err == nil {
+				mutex.Lock()
+				seatedCount++
+				mutex.Unlock()
+			}
+		}()
+	}
+	joins.Wait()
+
+	if seatedCount != maxCapacity {
+		t.Fatalf("got %d seated members, want %d", seatedCount, maxCapacity)
+	}
+	if count := room.GetRoomMembersCount(); count != maxCapacity {
+		t.Fatalf("got %d members in the room, want %d", count, maxCapacity)
+	}
+}
+
+func TestAddMemberKeepsTheSeatsOfTheDetachedMembers(t *testing.T) {
+	room := newTestRoom(2)
+	detachedMember := &DetachedMember{ID: "detached", Name: "bob", resumeTokenHash: hashResumeToken("token")}
+	room.DetachedMembers.Store(detachedMember.ID, detachedMember)
+
+	err := room.AddMember(NewMember("alice", transport.NewMemoryConnection("10.0.0.1"), room.ID, true, slog.Default()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the seat of the detached member is kept for it, from the moment it is resumed until it is added to the room
+	resumedMember, err := room.ResumeMember(detachedMember.ID, "token")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = room.AddMember(NewMember("carol", transport.NewMemoryConnection("10.0.0.2"), room.ID, false, slog.Default()))
+	if !errors.Is(err, ErrRoomFull) {
+		t.Fatalf("got %v, want ErrRoomFull", err)
+	}
+
+	_, err = room.ResumeMember(detachedMember.ID, "token")
+	if !errors.Is(err, ErrInvalidResumeToken) {
+		t.Fatalf("got %v, want ErrInvalidResumeToken for a seat resumed twice", err)
+	}
+
+	err = room.AddMember(NewResumedMember(resumedMember, transport.NewMemoryConnection("10.0.0.3"), room.ID, slog.Default()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count := room.GetRoomMembersCount() + room.DetachedMembersCount(); count != 2 {
+		t.Fatalf("got %d seats taken, want 2", count)
+	}
+}
+
+func TestABroadcastIsKeptOnceInTheEventLog(t *testing.T) {
+	room := newTestRoom(3)
+	room.Events = NewEventLog(2)
+
+	var connections []*transport.MemoryConnection
+	for _, name := range []string{"alice", "bob", "carol"} {
+		connection := transport.NewMemoryConnection("10.0.0.1")
+		member := NewMember(name, connection, room.ID, name == "alice", slog.Default())
+		err := room.AddMember(member)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		done := make(chan bool)
+		go member.WriteMessages(done)
+		t.Cleanup(func() {
+			close(done)
+		})
+		connections = append(connections, connection)
+	}
+
+	for i := 0; i < 2; i++ {
+		room.Broadcast(context.Background(), event.EventRoomJoinUpdates, event.RoomJoinUpdatesEventData{Message: "hello"}, nil)
+	}
+
+	// every member is sent both events, with the same numbers
+	for _, connection := range connections {
+		for seq := uint64(1); seq <= 2; seq++ {
+			message, err := connection.Receive(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var receivedEvent event.Event
+			err = json.Unmarshal(message, &receivedEvent)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if receivedEvent.Seq != seq || receivedEvent.PrevSeq != seq-1 {
+				t.Fatalf("got seq %d and prev_seq %d, want %d and %d", receivedEvent.Seq, receivedEvent.PrevSeq, seq, seq-1)
+			}
+		}
+	}
+
+	// a log of 2 events still holds both broadcasts, whatever the number of members
+	roomSnapshot := room.Snapshot()
+	if len(roomSnapshot.Events) != 2 || roomSnapshot.DroppedEventSeq != 0 {
+		t.Fatalf("got %d events and %d dropped, want both broadcasts to be kept", len(roomSnapshot.Events), roomSnapshot.DroppedEventSeq)
+	}
+	if recipients := roomSnapshot.Events[1].Recipients; len(recipients) != 3 {
+		t.Fatalf("got %d recipients, want 3", len(recipients))
+	}
+
+	for _, member := range room.GetMembers() {
+		missedEvents, ok := room.Events.Between(member.ID, 0, 2)
+		if !ok || len(missedEvents) != 2 {
+			t.Fatalf("got %d events to replay, want 2", len(missedEvents))
+		}
+	}
+}
+
+// testClient: a client of the second version of the protocol connected to the room through a memory connection,
+// its events are handled by the room as the events of a websocket client
+type testClient struct {
+	t          *testing.T
+	member     *Member
+	connection *transport.MemoryConnection
+}
+
+// joinTestClient: seats the member in the room and sends its JOIN_ROOM event, it returns once the member has got the
+// state of the room, which is the last event of a join
+func joinTestClient(t *testing.T, room *Room, name string, isRoomAdmin bool, remoteAddr string) *testClient {
+	t.Helper()
+
+	connection := transport.NewMemoryConnection(remoteAddr)
+	member := NewMember(name, connection, room.ID, isRoomAdmin, slog.Default())
+	member.ProtocolVersion = event.ProtocolVersion2
+	err := room.AddMember(member)
+	if err != nil {
+		t.Fatalf("unable to add %s to the room: %v", name, err)
+	}
+
+	done := make(chan bool)
+	go member.ReadMessages(room, done)
+	go member.WriteMessages(done)
+	t.Cleanup(func() {
+		connection.Close(transport.CloseNormalClosure, "test done")
+	})
+
+	client := &testClient{t: t, member: member, connection: connection}
+	client.send(event.EventJoinRoom, nil)
+	client.expect(event.EventRoomState)
+	return client
+}
+
+// send: the client sends an event with the given data
+func (c *testClient) send(eventType event.EventType, data any) {
+	c.t.Helper()
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		c.t.Fatalf("unexpected error: %v", err)
+	}
+	c.sendEvent(event.Event{Type: string(eventType), Data: jsonData})
+}
+
+// sendEvent: the client sends the event as it is, e.g. with its id
+func (c *testClient) sendEvent(eventToBeSent event.Event) {
+	c.t.Helper()
+
+	payload, err := json.Marshal(eventToBeSent)
+	if err != nil {
+		c.t.Fatalf("unexpected error: %v", err)
+	}
+	c.sendRaw(string(payload))
+}
+
+// sendRaw: the client sends a message which may not be an event
+func (c *testClient) sendRaw(payload string) {
+	c.t.Helper()
+
+	err := c.connection.Send(context.Background(), []byte(payload))
+	if err != nil {
+		c.t.Fatalf("unable to send %s: %v", payload, err)
+	}
+}
+
+// expect: returns the next event of the given type received by the client, the events of other types are skipped
+func (c *testClient) expect(eventType event.EventType) event.Event {
+	c.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	for {
+		message, err := c.connection.Receive(ctx)
+		if err != nil {
+			c.t.Fatalf("%s has not received %s: %v", c.member.Name, eventType, err)
+		}
+
+		var receivedEvent event.Event
+		err = json.Unmarshal(message, &receivedEvent)
+		if err != nil {
+			c.t.Fatalf("got %s, want an event: %v", message, err)
+		}
+		if receivedEvent.Type == string(eventType) {
+			return receivedEvent
+		}
+	}
+}
+
+// expectData: unmarshals the data of the next event of the given type
+func (c *testClient) expectData(eventType event.EventType, data any) {
+	c.t.Helper()
+
+	receivedEvent := c.expect(eventType)
+	err := json.Unmarshal(receivedEvent.Data, data)
+	if err != nil {
+		c.t.Fatalf("unable to unmarshal the data of %s: %v", eventType, err)
+	}
+}
+
+func TestJoinRoomEventHandler(t *testing.T) {
+	room := newTestRoom(2)
+
+	alice := joinTestClient(t, room, "alice", true, "10.0.0.1")
+	bob := joinTestClient(t, room, "bob", false, "10.0.0.2")
+
+	// the members already present are told who has joined
+	var joinUpdates event.RoomJoinUpdatesEventData
+	alice.expectData(event.EventRoomJoinUpdates, &joinUpdates)
+	if joinUpdates.MemberID != bob.member.ID || joinUpdates.MemberName != "bob" {
+		t.Fatalf("got %+v, want the join of bob", joinUpdates)
+	}
+
+	// the room is full, the admin is prompted to begin voting and the other members wait for it
+	alice.expect(event.EventRoomCapacityReached)
+	alice.expect(event.EventBeginVotingPrompt)
+	bob.expect(event.EventRoomCapacityReached)
+
+	if phase, _ := room.State(); phase != RoomPhaseAwaitingVoteStart {
+		t.Fatalf("got the %s phase, want %s", phase, RoomPhaseAwaitingVoteStart)
+	}
+}
+
+func TestVotesAreRevealedOnceEveryMemberHasVoted(t *testing.T) {
+	room := newTestRoom(2)
+
+	alice := joinTestClient(t, room, "alice", true, "10.0.0.1")
+	bob := joinTestClient(t, room, "bob", false, "10.0.0.2")
+
+	alice.send(event.EventBeginVoting, event.BeginVotingEventData{TicketID: "ABC-1"})
+	for _, client := range []*testClient{alice, bob} {
+		var askForVote event.AskForVoteEventData
+		client.expectData(event.EventAskForVote, &askForVote)
+		if askForVote.TicketID != "ABC-1" {
+			t.Fatalf("%s got asked to vote for %q, want ABC-1", client.member.Name, askForVote.TicketID)
+		}
+	}
+
+	bob.send(event.EventMemberVoted, event.MemberVotedEventData{TicketID: "ABC-1", Vote: "5"})
+	var voteCast event.MemberVoteCastEventData
+	alice.expectData(event.EventMemberVoted, &voteCast)
+	if voteCast.MemberID != bob.member.ID || voteCast.TicketID != "ABC-1" {
+		t.Fatalf("got %+v, want the vote of bob", voteCast)
+	}
+	if phase, _ := room.State(); phase != RoomPhaseVoting {
+		t.Fatalf("got the %s phase, want the voting to wait for alice", phase)
+	}
+
+	alice.send(event.EventMemberVoted, event.MemberVotedEventData{TicketID: "ABC-1", Vote: "8"})
+	alice.expect(event.EventVotingCompleted)
+	alice.expect(event.EventRevealVotesPrompt)
+	bob.expect(event.EventVotingCompleted)
+
+	alice.send(event.EventRevealVotes, event.RevealVotesEventData{TicketID: "ABC-1"})
+	for _, client := range []*testClient{alice, bob} {
+		var votesRevealed event.VotesRevealedV2EventData
+		client.expectData(event.EventVotesRevealed, &votesRevealed)
+
+		votes := make(map[string]string, len(votesRevealed.Votes))
+		for _, vote := range votesRevealed.Votes {
+			votes[vote.MemberName] = vote.Vote
+		}
+		if votesRevealed.TicketID != "ABC-1" || len(votes) != 2 || votes["alice"] != "8" || votes["bob"] != "5" {
+			t.Fatalf("%s got %+v, want the votes of alice and bob", client.member.Name, votesRevealed)
+		}
+	}
+	alice.expect(event.EventBeginVotingPrompt)
+	bob.expect(event.EventAwaitingAdminVoteStart)
+
+	if phase, _ := room.State(); phase != RoomPhaseAwaitingVoteStart {
+		t.Fatalf("got the %s phase, want %s", phase, RoomPhaseAwaitingVoteStart)
+	}
+	if results := room.RecentResults(); len(results) != 1 || results[0].TicketID != "ABC-1" {
+		t.Fatalf("got %+v, want the result of ABC-1", results)
+	}
+}
+
+func TestKickMemberEventHandler(t *testing.T) {
+	room := newTestRoom(3)
+
+	alice := joinTestClient(t, room, "alice", true, "10.0.0.1")
+	bob := joinTestClient(t, room, "bob", false, "10.0.0.2")
+	carol := joinTestClient(t, room, "carol", false, "10.0.0.3")
+
+	// only the admin can kick a member
+	carol.send(event.EventKickMember, event.KickMemberEventData{MemberID: bob.member.ID})
+	var errorData event.ErrorEventData
+	carol.expectData(event.EventError, &errorData)
+	if errorData.Code != event.ErrorCodeNotRoomAdmin {
+		t.Fatalf("got the %s error code, want %s", errorData.Code, event.ErrorCodeNotRoomAdmin)
+	}
+
+	alice.send(event.EventKickMember, event.KickMemberEventData{MemberID: "unknown"})
+	alice.expectData(event.EventError, &errorData)
+	if errorData.Code != event.ErrorCodeMemberNotFound {
+		t.Fatalf("got the %s error code, want %s", errorData.Code, event.ErrorCodeMemberNotFound)
+	}
+
+	alice.send(event.EventKickMember, event.KickMemberEventData{MemberID: bob.member.ID, Ban: true})
+
+	// every member, including the kicked one, is told about the kick
+	for _, client := range []*testClient{alice, bob, carol} {
+		var memberKicked event.MemberKickedEventData
+		client.expectData(event.EventMemberKicked, &memberKicked)
+		if memberKicked.MemberID != bob.member.ID || !memberKicked.Banned {
+			t.Fatalf("%s got %+v, want the ban of bob", client.member.Name, memberKicked)
+		}
+	}
+
+	// the kicked member is disconnected once it has got the event
+	_, err := bob.connection.Receive(context.Background())
+	if !errors.Is(err, transport.ErrClosed) {
+		t.Fatalf("got %v, want the connection of bob to be closed", err)
+	}
+	closeCode, reason, _ := bob.connection.CloseStatus()
+	if closeCode != transport.ClosePolicyViolation || reason != "banned from the room" {
+		t.Fatalf("got the %d close code (%s), want %d", closeCode, reason, transport.ClosePolicyViolation)
+	}
+
+	if !room.IsBanned("10.0.0.2") {
+		t.Fatal("the IP address of bob has not been banned")
+	}
+	if count := room.GetRoomMembersCount(); count != 2 {
+		t.Fatalf("got %d members, want 2", count)
+	}
+}
+
+func TestAMemberWhoDoesNotReadItsMessagesIsDisconnected(t *testing.T) {
+	room := newTestRoom(2)
+
+	// the client never receives its messages, hence its connection stops taking them once its outbox is full
+	connection := transport.NewMemoryConnection("10.0.0.1")
+	member := NewMember("alice", connection, room.ID, true, slog.Default())
+	err := room.AddMember(member)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan bool)
+	go member.WriteMessages(done)
+	t.Cleanup(func() {
+		connection.Close(transport.CloseNormalClosure, "test done")
+	})
+
+	// the room never waits for the member, whatever the number of events it is sent
+	broadcasted := make(chan struct{})
+	go func() {
+		defer close(broadcasted)
+		for i := 0; i < 4*memberQueueSize; i++ {
+			room.Broadcast(context.Background(), event.EventRoomJoinUpdates, event.RoomJoinUpdatesEventData{Message: "hello"}, nil)
+		}
+	}()
+
+	select {
+	case <-broadcasted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the broadcasts wait for the member to read its messages")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		closeCode, _, closed := connection.CloseStatus()
+		if closed {
+			if closeCode != transport.ClosePolicyViolation {
+				t.Fatalf("got the %d close code, want %d", closeCode, transport.ClosePolicyViolation)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the member has not been disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// expectAck: the next ACK received by the client acknowledges the event with the given id
+func (c *testClient) expectAck(eventID string, duplicate bool) {
+	c.t.Helper()
+
+	var ack event.AckEventData
+	c.expectData(event.EventAck, &ack)
+	if ack.ID != eventID || ack.Duplicate != duplicate {
+		c.t.Fatalf("got %+v, want the acknowledgement of %s (duplicate: %t)", ack, eventID, duplicate)
+	}
+}
+
+// expectError: the next ERROR received by the client has the given code and the id of the rejected event
+func (c *testClient) expectError(code event.ErrorCode, eventID string) {
+	c.t.Helper()
+
+	var errorData event.ErrorEventData
+	c.expectData(event.EventError, &errorData)
+	if errorData.Code != code || errorData.ID != eventID {
+		c.t.Fatalf("got %+v, want the %s error of %q", errorData, code, eventID)
+	}
+}
+
+// countLoggedEvents: the number of events of the given type in the log of the room
+func countLoggedEvents(room *Room, eventType event.EventType) int {
+	count := 0
+	for _, loggedEvent := range room.Snapshot().Events {
+		if loggedEvent.Type == string(eventType) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestAnEventWithAnIDIsAcknowledgedOnce(t *testing.T) {
+	room := newTestRoom(2)
+	alice := joinTestClient(t, room, "alice", true, "10.0.0.1")
+
+	beginVoting, _ := json.Marshal(event.BeginVotingEventData{TicketID: "ABC-1"})
+	alice.sendEvent(event.Event{Type: string(event.EventBeginVoting), ID: "e0", Data: beginVoting})
+	alice.expectAck("e0", false)
+
+	// the event sent again is acknowledged again without being handled a second time
+	alice.sendEvent(event.Event{Type: string(event.EventBeginVoting), ID: "e0", Data: beginVoting})
+	alice.expectAck("e0", true)
+	if count := countLoggedEvents(room, event.EventAskForVote); count != 1 {
+		t.Fatalf("got %d ASK_FOR_VOTE events, want the voting to begin once", count)
+	}
+
+	// the oldest id is forgotten once the member has acknowledged acknowledgedEventIDsSize other events
+	for i := 1; i <= acknowledgedEventIDsSize; i++ {
+		eventID := fmt.Sprintf("e%d", i)
+		alice.sendEvent(event.Event{Type: string(event.EventGetRoomState), ID: eventID, Data: json.RawMessage("{}")})
+		alice.expectAck(eventID, false)
+	}
+
+	lastEventID := fmt.Sprintf("e%d", acknowledgedEventIDsSize)
+	alice.sendEvent(event.Event{Type: string(event.EventGetRoomState), ID: lastEventID, Data: json.RawMessage("{}")})
+	alice.expectAck(lastEventID, true)
+
+	alice.sendEvent(event.Event{Type: string(event.EventBeginVoting), ID: "e0", Data: beginVoting})
+	alice.expectAck("e0", false)
+	if count := countLoggedEvents(room, event.EventAskForVote); count != 2 {
+		t.Fatalf("got %d ASK_FOR_VOTE events, want the forgotten event to be handled again", count)
+	}
+}
+
+func TestARejectedEventGetsAnErrorWithItsID(t *testing.T) {
+	room := newTestRoom(3)
+	alice := joinTestClient(t, room, "alice", true, "10.0.0.1")
+	bob := joinTestClient(t, room, "bob", false, "10.0.0.2")
+
+	kickMember, _ := json.Marshal(event.KickMemberEventData{MemberID: alice.member.ID})
+	bob.sendEvent(event.Event{Type: string(event.EventKickMember), ID: "k1", Data: kickMember})
+	bob.expectError(event.ErrorCodeNotRoomAdmin, "k1")
+
+	bob.sendEvent(event.Event{Type: "DANCE", ID: "d1", Data: json.RawMessage("{}")})
+	bob.expectError(event.ErrorCodeUnsupportedEvent, "d1")
+
+	bob.sendRaw(`{"type":"MEMBER_VOTED","id":"v1","data":{"ticket_id":"ABC-1","vote":5}}`)
+	bob.expectError(event.ErrorCodeInvalidPayload, "v1")
+
+	// a message which is not an event has no id
+	bob.sendRaw(`not an event`)
+	bob.expectError(event.ErrorCodeInvalidPayload, "")
+
+	// the rejected events are not acknowledged, and the connection stays open
+	bob.sendEvent(event.Event{Type: string(event.EventGetRoomState), ID: "s1", Data: json.RawMessage("{}")})
+	bob.expectAck("s1", false)
+	if _, _, closed := bob.connection.CloseStatus(); closed {
+		t.Fatal("the connection of bob has been closed")
+	}
+	if count := room.GetRoomMembersCount(); count != 2 {
+		t.Fatalf("got %d members, want bob to still be in the room", count)
+	}
+}
@@ -0,0 +1,291 @@
+package entity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/skamranahmed/estimatex-server/internal/event"
+	"github.com/skamranahmed/estimatex-server/internal/snapshot"
+	"github.com/skamranahmed/estimatex-server/internal/tracker"
+)
+
+var ErrInvalidResumeToken = errors.New("invalid member id or resume token")
+
+// Snapshot: returns the state of the room, the connected members and the detached ones are both part of it
+func (r *Room) Snapshot() snapshot.Room {
+	phase, currentTicketID := r.State()
+
+	roomSnapshot := snapshot.Room{
+		Version:              snapshot.Version,
+		SavedAt:              time.Now().UTC(),
+		ID:                   r.ID,
+		MaxCapacity:          r.MaxCapacity,
+		CreatedAt:            r.CreatedAt,
+		Phase:                string(phase),
+		CurrentTicketID:      currentTicketID,
+		PasswordHash:         r.PasswordHash,
+		WebhookSubscriptions: r.WebhookSubscriptions,
+	}
+
+	for _, member := range r.GetMembers() {
+		roomSnapshot.Members = append(roomSnapshot.Members, snapshot.Member{
+			ID:              member.ID,
+			Name:            member.Name,
+			IsRoomAdmin:     member.IsRoomAdmin,
+			RemoteIP:        member.RemoteIP,
+			Muted:           member.IsMuted(),
+			JoinedAt:        member.JoinedAt,
+			ResumeTokenHash: hashResumeToken(member.ResumeToken),
+		})
+	}
+
+	r.DetachedMembers.Range(func(key interface{}, value interface{}) bool {
+		detachedMember := value.(*DetachedMember)
+		roomSnapshot.Members = append(roomSnapshot.Members, snapshot.Member{
+			ID:              detachedMember.ID,
+			Name:            detachedMember.Name,
+			IsRoomAdmin:     detachedMember.IsRoomAdmin,
+			RemoteIP:        detachedMember.RemoteIP,
+			Muted:           detachedMember.Muted,
+			JoinedAt:        detachedMember.JoinedAt,
+			ResumeTokenHash: detachedMember.resumeTokenHash,
+		})
+		return true
+	})
+
+	r.TicketVotesMapMutex.Lock()
+	roomSnapshot.TicketVotes = make(map[string][]snapshot.Vote, len(r.TicketVotesMap))
+	for ticketID, votes := range r.TicketVotesMap {
+		for _, vote := range votes {
+			roomSnapshot.TicketVotes[ticketID] = append(roomSnapshot.TicketVotes[ticketID], snapshot.Vote(*vote))
+		}
+	}
+	roomSnapshot.MemberVotes = make(map[string]snapshot.Vote, len(r.MemberVoteMap))
+	for memberID, vote := range r.MemberVoteMap {
+		roomSnapshot.MemberVotes[memberID] = snapshot.Vote(*vote)
+	}
+	r.TicketVotesMapMutex.Unlock()
+
+	// the resume tokens are not written to the snapshots, only their hashes are
+	loggedEvents, lastEventSeq, droppedEventSeq := r.Events.snapshot()
+	for _, loggedEvent := range loggedEvents {
+		if loggedEvent.Type == string(event.EventResumeToken) {
+			continue
+		}
+		roomSnapshot.Events = append(roomSnapshot.Events, snapshot.Event{
+			Seq:        loggedEvent.Seq,
+			Type:       loggedEvent.Type,
+			Data:       loggedEvent.Data.JSON,
+			SentAt:     loggedEvent.SentAt,
+			TraceID:    loggedEvent.TraceID,
+			Recipients: loggedEvent.Recipients,
+		})
+	}
+	roomSnapshot.LastEventSeq = lastEventSeq
+	roomSnapshot.DroppedEventSeq = droppedEventSeq
+
+	for _, result := range r.RecentResults() {
+		votes := make([]snapshot.Vote, 0, len(result.Votes))
+		for _, vote := range result.Votes {
+			votes = append(votes, snapshot.Vote(vote))
+		}
+		roomSnapshot.Results = append(roomSnapshot.Results, snapshot.Result{
+			TicketID:      result.TicketID,
+			Votes:         votes,
+			FinalEstimate: result.FinalEstimate,
+			RevealedAt:    result.RevealedAt,
+		})
+	}
+
+	r.RevokedInvites.Range(func(key interface{}, value interface{}) bool {
+		roomSnapshot.RevokedInvites = append(roomSnapshot.RevokedInvites, key.(string))
+		return true
+	})
+
+	r.BannedIPs.Range(func(key interface{}, value interface{}) bool {
+		roomSnapshot.BannedIPs = append(roomSnapshot.BannedIPs, key.(string))
+		return true
+	})
+
+	return roomSnapshot
+}
+
+// Restore: brings the room back to the state of its snapshot, every member is detached until it reconnects
+func (r *Room) Restore(roomSnapshot snapshot.Room) {
+	r.ID = roomSnapshot.ID
+	r.MaxCapacity = roomSnapshot.MaxCapacity
+	r.CreatedAt = roomSnapshot.CreatedAt
+	r.PasswordHash = roomSnapshot.PasswordHash
+	r.WebhookSubscriptions = roomSnapshot.WebhookSubscriptions
+	r.setState(RoomPhase(roomSnapshot.Phase), roomSnapshot.CurrentTicketID)
+
+	for _, member := range roomSnapshot.Members {
+		r.DetachedMembers.Store(member.ID, &DetachedMember{
+			ID:              member.ID,
+			Name:            member.Name,
+			IsRoomAdmin:     member.IsRoomAdmin,
+			RemoteIP:        member.RemoteIP,
+			Muted:           member.Muted,
+			JoinedAt:        member.JoinedAt,
+			resumeTokenHash: member.ResumeTokenHash,
+		})
+	}
+
+	r.TicketVotesMapMutex.Lock()
+	for ticketID, votes := range roomSnapshot.TicketVotes {
+		for _, vote := range votes {
+			r.TicketVotesMap[ticketID] = append(r.TicketVotesMap[ticketID], &Vote{Value: vote.Value, MemberID: vote.MemberID, MemberName: vote.MemberName})
+		}
+	}
+	for memberID, vote := range roomSnapshot.MemberVotes {
+		r.MemberVoteMap[memberID] = &Vote{Value: vote.Value, MemberID: vote.MemberID, MemberName: vote.MemberName}
+	}
+	r.TicketVotesMapMutex.Unlock()
+
+	r.ResultsMutex.Lock()
+	for _, result := range roomSnapshot.Results {
+		votes := make([]Vote, 0, len(result.Votes))
+		for _, vote := range result.Votes {
+			votes = append(votes, Vote(vote))
+		}
+		r.Results = append(r.Results, TicketResult{TicketID: result.TicketID, Votes: votes, FinalEstimate: result.FinalEstimate, RevealedAt: result.RevealedAt})
+	}
+	r.ResultsMutex.Unlock()
+
+	loggedEvents := make([]LoggedEvent, 0, len(roomSnapshot.Events))
+	for _, loggedEvent := range roomSnapshot.Events {
+		loggedEvents = append(loggedEvents, LoggedEvent{
+			Seq:        loggedEvent.Seq,
+			Type:       loggedEvent.Type,
+			Data:       event.NewSharedData(loggedEvent.Data),
+			SentAt:     loggedEvent.SentAt,
+			TraceID:    loggedEvent.TraceID,
+			Recipients: loggedEvent.Recipients,
+		})
+	}
+	r.Events.restore(loggedEvents, roomSnapshot.LastEventSeq, roomSnapshot.DroppedEventSeq)
+
+	for _, inviteID := range roomSnapshot.RevokedInvites {
+		r.RevokedInvites.Store(inviteID, struct{}{})
+	}
+	for _, remoteIP := range roomSnapshot.BannedIPs {
+		r.BannedIPs.Store(remoteIP, struct{}{})
+	}
+}
+
+// ResumeMember: gives the seat of a detached member back to the client holding its resume token,
+// the seat can only be taken back once. The seat stays detached until the member is added to the room,
+// so that it is not given to a new member meanwhile.
+func (r *Room) ResumeMember(memberID string, resumeToken string) (*DetachedMember, error) {
+	value, ok := r.DetachedMembers.Load(memberID)
+	if !ok || !value.(*DetachedMember).verifyResumeToken(resumeToken) {
+		return nil, ErrInvalidResumeToken
+	}
+
+	return r.ClaimDetachedMember(memberID)
+}
+
+// ClaimDetachedMember: gives the seat of a detached member to a client whose resume token has already been
+// verified, by the instance which relays the client
+func (r *Room) ClaimDetachedMember(memberID string) (*DetachedMember, error) {
+	value, ok := r.DetachedMembers.Load(memberID)
+	if !ok {
+		return nil, ErrInvalidResumeToken
+	}
+
+	// two clients may resume the same member at the same time, only one of them gets the seat
+	detachedMember := value.(*DetachedMember)
+	if !detachedMember.claimed.CompareAndSwap(false, true) {
+		return nil, ErrInvalidResumeToken
+	}
+
+	return detachedMember, nil
+}
+
+// DetachedMembersCount: the seats of the detached members are kept for them, hence they are not given to new members
+func (r *Room) DetachedMembersCount() int {
+	count := 0
+
+	r.DetachedMembers.Range(func(key interface{}, value interface{}) bool {
+		count++
+		return true
+	})
+
+	return count
+}
+
+// DropDetachedMembers: gives up on the members who have not reconnected, their votes are removed
+func (r *Room) DropDetachedMembers() []*DetachedMember {
+	var droppedMembers []*DetachedMember
+
+	r.DetachedMembers.Range(func(key interface{}, value interface{}) bool {
+		if _, loaded := r.DetachedMembers.LoadAndDelete(key); loaded {
+			droppedMembers = append(droppedMembers, value.(*DetachedMember))
+		}
+		return true
+	})
+
+	for _, droppedMember := range droppedMembers {
+		r.removeVotes(droppedMember.ID)
+	}
+	if len(droppedMembers) > 0 {
+		r.changed()
+	}
+
+	return droppedMembers
+}
+
+// AnnounceResumedMember: tells the room that the member is back, and sends the member the members of the room
+// and the prompt of the step the estimation is in, so that it carries on where it stopped
+func (r *Room) AnnounceResumedMember(ctx context.Context, member *Member) {
+	member.Logger.Info("Member resumed its seat in the room", "is_room_admin", member.IsRoomAdmin)
+
+	member.SendRoomJoinUpdatesEvent(ctx, fmt.Sprintf("🔄 You are back in the room: %s", r.ID), nil)
+
+	membersInRoom := r.GetMembers()
+	for _, memberInRoom := range membersInRoom {
+		messageToBeSentToMember := fmt.Sprintf("👤 %s joined", memberInRoom.Name)
+		if memberInRoom.IsRoomAdmin {
+			messageToBeSentToMember = fmt.Sprintf("👑👤 %s (ADMIN) joined", memberInRoom.Name)
+		}
+		member.SendRoomJoinUpdatesEvent(ctx, messageToBeSentToMember, memberInRoom)
+	}
+
+	r.broadcastTo(ctx, membersInRoom, event.EventRoomJoinUpdates,
+		r.sharedPayload(event.EventRoomJoinUpdates, roomJoinUpdatesEventData(fmt.Sprintf("🔄 %s is back", member.Name), member)),
+		func(memberInRoom *Member) bool { return memberInRoom.ID != member.ID },
+	)
+
+	r.sendRoomStateOnJoin(ctx, member)
+
+	phase, ticketID := r.State()
+	switch phase {
+	case RoomPhaseAwaitingVoteStart:
+		if member.IsRoomAdmin {
+			member.SendBeginVotingPromptEvent(ctx, "📝 Enter the ticket id for which you want to start voting next:")
+			return
+		}
+		member.SendAwaitingAdminVoteStartEvent(ctx, "⏳ Waiting for the admin to begin voting for next ticket")
+
+	case RoomPhaseVoting:
+		if !member.IsMuted() && !r.HasVoted(ticketID, member.ID) {
+			member.SendAskForVoteEvent(ctx, tracker.Ticket{ID: ticketID})
+		}
+
+	case RoomPhaseAwaitingReveal:
+		if member.IsRoomAdmin {
+			member.SendRevealVotesPromptEvent(ctx, "", ticketID)
+			return
+		}
+		member.SendVotingCompletedEvent(ctx, fmt.Sprintf("✅ Voting has completed for the ticket id: %s\n> ⏳ Waiting for the admin to reveal the votes.", ticketID))
+	}
+}
+
+// changed: asks for a new snapshot of the room
+func (r *Room) changed() {
+	if r.OnChange != nil {
+		r.OnChange()
+	}
+}
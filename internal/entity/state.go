@@ -0,0 +1,168 @@
+package entity
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/skamranahmed/estimatex-server/internal/event"
+)
+
+// maxRecentResults is the number of revealed tickets a room keeps for the "ROOM_STATE" event
+const maxRecentResults = 10
+
+// TicketResult: the revealed votes of a ticket, FinalEstimate is empty until the admin sets it
+type TicketResult struct {
+	TicketID      string
+	Votes         []Vote
+	FinalEstimate string
+	RevealedAt    time.Time
+}
+
+func (r *Room) GetRoomStateEventHandler(ctx context.Context, member *Member, receivedEvent event.Event) error {
+	member.SendRoomStateEvent(ctx, r.RoomState())
+	return nil
+}
+
+// sendRoomStateOnJoin: a member who joins the room, or who resumes its seat, gets the whole state of the room so that it
+// can render it from any point of the estimation. The clients of the first version of the protocol do not know the
+// "ROOM_STATE" event, hence they only get it when they ask for it.
+func (r *Room) sendRoomStateOnJoin(ctx context.Context, member *Member) {
+	if member.ProtocolVersion < event.ProtocolVersion2 {
+		return
+	}
+	member.SendRoomStateEvent(ctx, r.RoomState())
+}
+
+// RoomState: describes the settings of the room, its members, where it is in the estimation and the latest results
+func (r *Room) RoomState() event.RoomStateEventData {
+	phase, currentTicketID := r.State()
+
+	roomState := event.RoomStateEventData{
+		RoomID:              r.ID,
+		MaxCapacity:         r.MaxCapacity,
+		IsPasswordProtected: r.IsPasswordProtected(),
+		CreatedAt:           r.CreatedAt.UnixMilli(),
+		Phase:               string(phase),
+		CurrentTicketID:     currentTicketID,
+		Members:             []event.RoomStateMember{},
+		RecentResults:       []event.RoomStateResult{},
+		LastSeq:             r.Events.LastSeq(),
+	}
+
+	type rosterEntry struct {
+		member   event.RoomStateMember
+		joinedAt time.Time
+	}
+
+	var roster []rosterEntry
+	for _, member := range r.GetMembers() {
+		roster = append(roster, rosterEntry{
+			member: event.RoomStateMember{
+				MemberID:    member.ID,
+				MemberName:  member.Name,
+				IsRoomAdmin: member.IsRoomAdmin,
+				Muted:       member.IsMuted(),
+				Connected:   true,
+				HasVoted:    currentTicketID != "" && r.HasVoted(currentTicketID, member.ID),
+			},
+			joinedAt: member.JoinedAt,
+		})
+	}
+
+	r.DetachedMembers.Range(func(key interface{}, value interface{}) bool {
+		detachedMember := value.(*DetachedMember)
+		roster = append(roster, rosterEntry{
+			member: event.RoomStateMember{
+				MemberID:    detachedMember.ID,
+				MemberName:  detachedMember.Name,
+				IsRoomAdmin: detachedMember.IsRoomAdmin,
+				Muted:       detachedMember.Muted,
+				HasVoted:    currentTicketID != "" && r.HasVoted(currentTicketID, detachedMember.ID),
+			},
+			joinedAt: detachedMember.JoinedAt,
+		})
+		return true
+	})
+
+	sort.Slice(roster, func(i, j int) bool {
+		return roster[i].joinedAt.Before(roster[j].joinedAt)
+	})
+	for _, entry := range roster {
+		roomState.Members = append(roomState.Members, entry.member)
+	}
+
+	for _, result := range r.RecentResults() {
+		votes := make([]event.RevealedVote, 0, len(result.Votes))
+		for _, vote := range result.Votes {
+			votes = append(votes, event.RevealedVote{MemberID: vote.MemberID, MemberName: vote.MemberName, Vote: vote.Value})
+		}
+		roomState.RecentResults = append(roomState.RecentResults, event.RoomStateResult{
+			TicketID:      result.TicketID,
+			Votes:         votes,
+			FinalEstimate: result.FinalEstimate,
+			RevealedAt:    result.RevealedAt.UnixMilli(),
+		})
+	}
+
+	return roomState
+}
+
+// RecentResults: returns the latest revealed tickets, from the oldest to the latest
+func (r *Room) RecentResults() []TicketResult {
+	r.ResultsMutex.Lock()
+	defer r.ResultsMutex.Unlock()
+
+	recentResults := make([]TicketResult, len(r.Results))
+	copy(recentResults, r.Results)
+	return recentResults
+}
+
+// saveResult: keeps the revealed votes of a ticket, the oldest result is forgotten once the room has maxRecentResults
+// results. A ticket which is revealed again replaces its previous result.
+func (r *Room) saveResult(ticketID string, memberVotes map[string]*Vote) {
+	votes := make([]Vote, 0, len(memberVotes))
+	for _, vote := range memberVotes {
+		votes = append(votes, *vote)
+	}
+	sort.Slice(votes, func(i, j int) bool {
+		return votes[i].MemberName < votes[j].MemberName
+	})
+
+	r.ResultsMutex.Lock()
+	defer r.ResultsMutex.Unlock()
+
+	results := r.Results[:0]
+	for _, result := range r.Results {
+		if result.TicketID != ticketID {
+			results = append(results, result)
+		}
+	}
+	results = append(results, TicketResult{TicketID: ticketID, Votes: votes, RevealedAt: time.Now().UTC()})
+	if len(results) > maxRecentResults {
+		results = results[len(results)-maxRecentResults:]
+	}
+	r.Results = results
+}
+
+// setFinalEstimate: records the final estimate of a ticket whose votes are among the recent results
+func (r *Room) setFinalEstimate(ticketID string, estimate string) {
+	r.ResultsMutex.Lock()
+	defer r.ResultsMutex.Unlock()
+
+	for i := range r.Results {
+		if r.Results[i].TicketID == ticketID {
+			r.Results[i].FinalEstimate = estimate
+		}
+	}
+}
+
+func (m *Member) SendRoomStateEvent(ctx context.Context, roomState event.RoomStateEventData) {
+	roomStateEventJsonData, _ := json.Marshal(roomState)
+	eventToBeSent := event.Event{
+		Type: string(event.EventRoomState),
+		Data: json.RawMessage(roomStateEventJsonData),
+	}
+	m.sendEvent(ctx, eventToBeSent)
+}
@@ -0,0 +1,93 @@
+package event
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+)
+
+// AppendJSON: appends the JSON encoding of the event to dst. It writes the same fields as json.Marshal, except
+// that the data is copied as it is instead of being validated and compacted again, so that the payload of a
+// broadcast event is marshalled once and its bytes are only copied into the event of every member.
+// The data is expected to be valid JSON, as written by json.Marshal.
+func (e Event) AppendJSON(dst []byte) []byte {
+	dst = append(dst, `{"type":`...)
+	dst = appendString(dst, e.Type)
+
+	dst = append(dst, `,"data":`...)
+	if len(e.Data) == 0 {
+		dst = append(dst, "null"...)
+	} else {
+		dst = append(dst, e.Data...)
+	}
+
+	if e.ID != "" {
+		dst = append(dst, `,"id":`...)
+		dst = appendString(dst, e.ID)
+	}
+	if e.Seq != 0 {
+		dst = append(dst, `,"seq":`...)
+		dst = strconv.AppendUint(dst, e.Seq, 10)
+	}
+	if e.PrevSeq != 0 {
+		dst = append(dst, `,"prev_seq":`...)
+		dst = strconv.AppendUint(dst, e.PrevSeq, 10)
+	}
+	if e.SentAt != 0 {
+		dst = append(dst, `,"sent_at":`...)
+		dst = strconv.AppendInt(dst, e.SentAt, 10)
+	}
+	if e.TraceID != "" {
+		dst = append(dst, `,"trace_id":`...)
+		dst = appendString(dst, e.TraceID)
+	}
+
+	return append(dst, '}')
+}
+
+// SharedData: the data of an event sent to several members, e.g. a broadcast event. It is marshalled once,
+// and it is shared by the events of every member and by the log of the room. It is converted to a wire encoding
+// once as well, the first time a member speaking the encoding is sent the event.
+type SharedData struct {
+	JSON json.RawMessage
+
+	mutex sync.Mutex
+
+	// Key: the name of the wire encoding, Value: the data in the encoding
+	encodings map[string][]byte
+}
+
+// NewSharedData: the data is expected to be valid JSON, as written by json.Marshal. A missing data is null.
+func NewSharedData(jsonData json.RawMessage) *SharedData {
+	if len(jsonData) == 0 {
+		jsonData = json.RawMessage("null")
+	}
+	return &SharedData{JSON: jsonData}
+}
+
+// Encoding: returns the data in the named wire encoding, it is converted with the encode function the first time
+func (d *SharedData) Encoding(name string, encode func(jsonData []byte) ([]byte, error)) ([]byte, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if encodedData, ok := d.encodings[name]; ok {
+		return encodedData, nil
+	}
+
+	encodedData, err := encode(d.JSON)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.encodings == nil {
+		d.encodings = make(map[string][]byte, 1)
+	}
+	d.encodings[name] = encodedData
+	return encodedData, nil
+}
+
+// appendString: the strings are escaped by encoding/json, so that they are written as json.Marshal writes them
+func appendString(dst []byte, value string) []byte {
+	encodedValue, _ := json.Marshal(value)
+	return append(dst, encodedValue...)
+}
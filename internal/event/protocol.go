@@ -0,0 +1,72 @@
+package event
+
+// The versions of the protocol, i.e. of the shapes of the event payloads. A client picks the version it speaks with
+// the `protocol_version` query parameter when it connects, the clients which do not send it speak the first version.
+const (
+	// ProtocolVersion1 is the protocol of the first clients: the votes of the members are announced with plain text
+	// messages, and the revealed votes are keyed by member id
+	ProtocolVersion1 = 1
+
+	// ProtocolVersion2 announces the votes with "MEMBER_VOTED" events, reveals the votes as a list,
+	// greets the client with a "WELCOME" event and sends it the "ROOM_STATE" event when it joins the room
+	ProtocolVersion2 = 2
+
+	LatestProtocolVersion = ProtocolVersion2
+)
+
+// The capabilities of the server, they are listed in the "WELCOME" event so that a client only offers the features
+// which are available
+const (
+	CapabilityInvites       = "invites"
+	CapabilityModeration    = "moderation"
+	CapabilityFinalEstimate = "final_estimate"
+	CapabilityIssueTracker  = "issue_tracker"
+	CapabilityResume        = "resume"
+	CapabilityReplay        = "replay"
+	CapabilityRoomState     = "room_state"
+	CapabilityMessagePack   = "msgpack"
+)
+
+// SupportedProtocolVersions: returns the versions from the oldest one still served by the server to the latest one
+func SupportedProtocolVersions(minProtocolVersion int) []int {
+	versions := make([]int, 0, LatestProtocolVersion-minProtocolVersion+1)
+	for version := minProtocolVersion; version <= LatestProtocolVersion; version++ {
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+// WelcomeEventData represents data specific to the "WELCOME" event, which is the first event sent to a client
+// speaking the second version of the protocol or a later one
+type WelcomeEventData struct {
+	ProtocolVersion           int   `json:"protocol_version"`
+	SupportedProtocolVersions []int `json:"supported_protocol_versions"`
+
+	// DeprecatedProtocolVersions are still served, but will stop being served by a later release of the server
+	DeprecatedProtocolVersions []int `json:"deprecated_protocol_versions"`
+
+	ServerVersion string   `json:"server_version"`
+	Capabilities  []string `json:"capabilities"`
+}
+
+// MemberVoteCastEventData represents data specific to the outgoing "MEMBER_VOTED" event, which tells the room that
+// a member has voted without revealing the vote. The first version of the protocol gets the message as plain text instead.
+type MemberVoteCastEventData struct {
+	TicketID   string `json:"ticket_id"`
+	MemberID   string `json:"member_id"`
+	MemberName string `json:"member_name"`
+	Message    string `json:"message"`
+}
+
+// VotesRevealedV2EventData represents data specific to the "VOTES_REVEALED" event from the second version of the protocol,
+// see VotesRevealedEventData for the first version
+type VotesRevealedV2EventData struct {
+	TicketID string         `json:"ticket_id"`
+	Votes    []RevealedVote `json:"votes"`
+}
+
+type RevealedVote struct {
+	MemberID   string `json:"member_id"`
+	MemberName string `json:"member_name"`
+	Vote       string `json:"vote"`
+}
@@ -13,16 +13,57 @@ var (
 type Event struct {
 	Type string          `json:"type"`
 	Data json.RawMessage `json:"data"`
+
+	// ID is optionally set by the client on the incoming events, the server then replies to the event
+	// with an "ACK" event, or with an "ERROR" or "RATE_LIMITED" event carrying the same id
+	ID string `json:"id,omitempty"`
+
+	// Seq numbers the outgoing events of a room, it increases with every event sent to a member of the room.
+	// PrevSeq is the number of the previous event sent to the same member, so that the member can tell when it
+	// has missed an event, and SentAt is the time at which the event was sent, in unix milliseconds.
+	Seq     uint64 `json:"seq,omitempty"`
+	PrevSeq uint64 `json:"prev_seq,omitempty"`
+	SentAt  int64  `json:"sent_at,omitempty"`
+
+	// TraceID is set on the outgoing events when tracing is enabled, it is the id of the trace
+	// of the incoming event (or the websocket upgrade) which caused the event to be sent
+	TraceID string `json:"trace_id,omitempty"`
 }
 
 type EventType string
 
+// ErrorCode identifies the reason of an "ERROR" event
+type ErrorCode string
+
+const (
+	ErrorCodeFieldTooLong   ErrorCode = "FIELD_TOO_LONG"
+	ErrorCodeFieldRequired  ErrorCode = "FIELD_REQUIRED"
+	ErrorCodeNotRoomAdmin   ErrorCode = "NOT_ROOM_ADMIN"
+	ErrorCodeMemberNotFound ErrorCode = "MEMBER_NOT_FOUND"
+	ErrorCodeInvalidTarget  ErrorCode = "INVALID_TARGET"
+	ErrorCodeMemberMuted    ErrorCode = "MEMBER_MUTED"
+	ErrorCodeInvalidPayload ErrorCode = "INVALID_PAYLOAD"
+
+	ErrorCodeUnsupportedEvent ErrorCode = "UNSUPPORTED_EVENT"
+	ErrorCodeInternal         ErrorCode = "INTERNAL_ERROR"
+
+	ErrorCodeTrackerSyncFailed ErrorCode = "TRACKER_SYNC_FAILED"
+	ErrorCodeReplayUnavailable ErrorCode = "REPLAY_UNAVAILABLE"
+)
+
 const (
 	// Incoming Events
-	EventJoinRoom    EventType = "JOIN_ROOM"
-	EventBeginVoting EventType = "BEGIN_VOTING"
-	EventMemberVoted EventType = "MEMBER_VOTED"
-	EventRevealVotes EventType = "REVEAL_VOTES"
+	EventJoinRoom     EventType = "JOIN_ROOM"
+	EventBeginVoting  EventType = "BEGIN_VOTING"
+	EventRevealVotes  EventType = "REVEAL_VOTES"
+	EventCreateInvite EventType = "CREATE_INVITE"
+	EventRevokeInvite EventType = "REVOKE_INVITE"
+	EventKickMember   EventType = "KICK_MEMBER"
+	EventMuteMember   EventType = "MUTE_MEMBER"
+
+	EventSetFinalEstimate EventType = "SET_FINAL_ESTIMATE"
+	EventReplayEvents     EventType = "REPLAY_EVENTS"
+	EventGetRoomState     EventType = "GET_ROOM_STATE"
 
 	// Outgoing Events
 	EventRoomJoinUpdates        EventType = "ROOM_JOIN_UPDATES"
@@ -33,14 +74,27 @@ const (
 	EventRevealVotesPrompt      EventType = "REVEAL_VOTES_PROMPT"
 	EventVotesRevealed          EventType = "VOTES_REVEALED"
 	EventAwaitingAdminVoteStart EventType = "AWAITING_ADMIN_VOTE_START"
+	EventInviteCreated          EventType = "INVITE_CREATED"
+	EventInviteRevoked          EventType = "INVITE_REVOKED"
+	EventRateLimited            EventType = "RATE_LIMITED"
+	EventError                  EventType = "ERROR"
+	EventRoomClosed             EventType = "ROOM_CLOSED"
+	EventMemberKicked           EventType = "MEMBER_KICKED"
+	EventMemberMuted            EventType = "MEMBER_MUTED"
+	EventFinalEstimateSet       EventType = "FINAL_ESTIMATE_SET"
+	EventResumeToken            EventType = "RESUME_TOKEN"
+	EventWelcome                EventType = "WELCOME"
+	EventAck                    EventType = "ACK"
+	EventRoomState              EventType = "ROOM_STATE"
 
 	// Incoming + Outgoing Events
-	EventCreateRoom EventType = "CREATE_ROOM"
+	EventCreateRoom  EventType = "CREATE_ROOM"
+	EventMemberVoted EventType = "MEMBER_VOTED"
 )
 
 func IsIncomingEventTypeValid(input string) bool {
 	switch EventType(input) {
-	case EventCreateRoom, EventJoinRoom, EventBeginVoting, EventMemberVoted, EventRevealVotes:
+	case EventCreateRoom, EventJoinRoom, EventBeginVoting, EventMemberVoted, EventRevealVotes, EventCreateInvite, EventRevokeInvite, EventKickMember, EventMuteMember, EventSetFinalEstimate, EventReplayEvents, EventGetRoomState:
 		return true
 	default:
 		return false
@@ -52,9 +106,12 @@ type CreateRoomEventData struct {
 	RoomID string `json:"room_id"`
 }
 
-// RoomJoinUpdatesEventData represents data specific to the "ROOM_JOIN_UPDATES" event
+// RoomJoinUpdatesEventData represents data specific to the "ROOM_JOIN_UPDATES" event, MemberID and MemberName
+// identify the member who joined, they are empty when the update is not about a member
 type RoomJoinUpdatesEventData struct {
-	Message string `json:"message"`
+	Message    string `json:"message"`
+	MemberID   string `json:"member_id,omitempty"`
+	MemberName string `json:"member_name,omitempty"`
 }
 
 // RoomCapacityReachedEventData represents data specific to the "ROOM_CAPACITY_REACHED" event
@@ -75,6 +132,11 @@ type BeginVotingEventData struct {
 // AskForVoteEventData represents data specific to the "ASK_FOR_VOTE" event
 type AskForVoteEventData struct {
 	TicketID string `json:"ticket_id"`
+
+	// the details of the ticket are only sent when the ticket has been found in the issue tracker
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url,omitempty"`
 }
 
 // MemberVotedEventData represents data specific to the "MEMBER_VOTED" event
@@ -100,7 +162,7 @@ type RevealVotesEventData struct {
 	TicketID string `json:"ticket_id"`
 }
 
-// VotesRevealedEventData represents data specific to the "VOTES_REVEALED" event
+// VotesRevealedEventData represents data specific to the "VOTES_REVEALED" event of the first version of the protocol
 type VotesRevealedEventData struct {
 	TicketID            string                 `json:"ticket_id"`
 	MemberVoteChoiceMap map[string]interface{} `json:"client_vote_choice_map"`
@@ -110,3 +172,164 @@ type VotesRevealedEventData struct {
 type AwaitingAdminVoteStartEventData struct {
 	Message string `json:"message"`
 }
+
+// CreateInviteEventData represents data specific to the "CREATE_INVITE" event
+type CreateInviteEventData struct {
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+}
+
+// InviteCreatedEventData represents data specific to the "INVITE_CREATED" event
+type InviteCreatedEventData struct {
+	InviteID  string `json:"invite_id"`
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// RevokeInviteEventData represents data specific to the "REVOKE_INVITE" event
+type RevokeInviteEventData struct {
+	InviteID string `json:"invite_id"`
+}
+
+// InviteRevokedEventData represents data specific to the "INVITE_REVOKED" event
+type InviteRevokedEventData struct {
+	InviteID string `json:"invite_id"`
+}
+
+// RateLimitedEventData represents data specific to the "RATE_LIMITED" event
+type RateLimitedEventData struct {
+	// Scope is either "member" when the member is sending too many events,
+	// or "room" when the room is broadcasting too many events
+	Scope     string `json:"scope"`
+	EventType string `json:"event_type"`
+	Message   string `json:"message"`
+
+	// ID is the id of the dropped event, when the client has set one
+	ID string `json:"id,omitempty"`
+}
+
+// ErrorEventData represents data specific to the "ERROR" event, which is sent when an incoming event is rejected
+type ErrorEventData struct {
+	Code      ErrorCode `json:"code"`
+	EventType string    `json:"event_type"`
+	Message   string    `json:"message"`
+
+	// ID is the id of the rejected event, when the client has set one
+	ID string `json:"id,omitempty"`
+}
+
+// RoomClosedEventData represents data specific to the "ROOM_CLOSED" event, which is sent when the room is closed by an operator
+type RoomClosedEventData struct {
+	Message string `json:"message"`
+}
+
+// KickMemberEventData represents data specific to the "KICK_MEMBER" event, a banned member cannot join the room again
+type KickMemberEventData struct {
+	MemberID string `json:"member_id"`
+	Ban      bool   `json:"ban,omitempty"`
+}
+
+// MemberKickedEventData represents data specific to the "MEMBER_KICKED" event, which is sent to every member of the room,
+// including the one who has been removed
+type MemberKickedEventData struct {
+	MemberID   string `json:"member_id"`
+	MemberName string `json:"member_name"`
+	Banned     bool   `json:"banned"`
+	Message    string `json:"message"`
+}
+
+// MuteMemberEventData represents data specific to the "MUTE_MEMBER" event, the member is unmuted when Muted is false
+type MuteMemberEventData struct {
+	MemberID string `json:"member_id"`
+	Muted    bool   `json:"muted"`
+}
+
+// MemberMutedEventData represents data specific to the "MEMBER_MUTED" event, which is sent to every member of the room
+type MemberMutedEventData struct {
+	MemberID   string `json:"member_id"`
+	MemberName string `json:"member_name"`
+	Muted      bool   `json:"muted"`
+	Message    string `json:"message"`
+}
+
+// SetFinalEstimateEventData represents data specific to the "SET_FINAL_ESTIMATE" event, which is sent by the admin
+// once the room has agreed on the estimate of a ticket
+type SetFinalEstimateEventData struct {
+	TicketID string `json:"ticket_id"`
+	Estimate string `json:"estimate"`
+}
+
+// FinalEstimateSetEventData represents data specific to the "FINAL_ESTIMATE_SET" event
+type FinalEstimateSetEventData struct {
+	TicketID string `json:"ticket_id"`
+	Estimate string `json:"estimate"`
+	Message  string `json:"message"`
+
+	// SyncedToTracker reports whether the estimate has been written to the issue tracker
+	SyncedToTracker bool `json:"synced_to_tracker"`
+}
+
+// ResumeTokenEventData represents data specific to the "RESUME_TOKEN" event, the member can take back its seat
+// in the room with its id and the token if the server restarts
+type ResumeTokenEventData struct {
+	MemberID    string `json:"member_id"`
+	ResumeToken string `json:"resume_token"`
+}
+
+// AckEventData represents data specific to the "ACK" event, which is sent once an incoming event with an id
+// has been handled. Duplicate is set when the event had already been handled, in which case it is not handled again.
+type AckEventData struct {
+	ID        string `json:"id"`
+	EventType string `json:"event_type"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
+
+// ReplayEventsEventData represents data specific to the "REPLAY_EVENTS" event, the member gets again the events
+// it has been sent after the SinceSeq sequence number
+type ReplayEventsEventData struct {
+	SinceSeq uint64 `json:"since_seq"`
+}
+
+// RoomStateEventData represents data specific to the "ROOM_STATE" event, which describes the whole room so that
+// a client can render it from any point of the estimation
+type RoomStateEventData struct {
+	RoomID              string `json:"room_id"`
+	MaxCapacity         int    `json:"max_capacity"`
+	IsPasswordProtected bool   `json:"is_password_protected"`
+
+	// CreatedAt is the time at which the room was created, in unix milliseconds
+	CreatedAt int64 `json:"created_at"`
+
+	Phase           string `json:"phase"`
+	CurrentTicketID string `json:"current_ticket_id,omitempty"`
+
+	Members []RoomStateMember `json:"members"`
+
+	// RecentResults are the latest revealed votes, from the oldest to the latest
+	RecentResults []RoomStateResult `json:"recent_results"`
+
+	// LastSeq is the sequence number of the latest event of the room when the state was taken, the events
+	// with a greater sequence number come after the state
+	LastSeq uint64 `json:"last_seq"`
+}
+
+// RoomStateMember: a member of the room, a member who has not reconnected after a restart of the server is not connected
+type RoomStateMember struct {
+	MemberID    string `json:"member_id"`
+	MemberName  string `json:"member_name"`
+	IsRoomAdmin bool   `json:"is_room_admin"`
+	Muted       bool   `json:"muted"`
+	Connected   bool   `json:"connected"`
+
+	// HasVoted reports whether the member has voted for the current ticket, the vote itself is only revealed by the admin
+	HasVoted bool `json:"has_voted"`
+}
+
+// RoomStateResult: the revealed votes of a ticket, along with its final estimate once the admin has set it
+type RoomStateResult struct {
+	TicketID      string         `json:"ticket_id"`
+	Votes         []RevealedVote `json:"votes"`
+	FinalEstimate string         `json:"final_estimate,omitempty"`
+
+	// RevealedAt is the time at which the votes were revealed, in unix milliseconds
+	RevealedAt int64 `json:"revealed_at"`
+}
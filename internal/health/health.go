@@ -0,0 +1,144 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Version and Commit are set at build time with:
+//
+//	go build -ldflags "-X github.com/skamranahmed/estimatex-server/internal/health.Version=v1.2.3 -X github.com/skamranahmed/estimatex-server/internal/health.Commit=abc1234"
+//
+// When Commit is not set, the VCS revision embedded by the go toolchain is used instead.
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// readinessCheckTimeout is the time given to all the readiness checks to complete
+const readinessCheckTimeout = 2 * time.Second
+
+var errDraining = errors.New("the server is draining")
+
+// ReadinessCheck: reports whether a dependency of the server (e.g. a storage) is available
+type ReadinessCheck func(ctx context.Context) error
+
+// Stats: the counters reported by the version endpoint
+type Stats struct {
+	ActiveRooms       int `json:"active_rooms"`
+	ActiveConnections int `json:"active_connections"`
+}
+
+// Checker: serves the liveness, readiness and build info endpoints
+type Checker struct {
+	startedAt time.Time
+	draining  atomic.Bool
+	stats     func() Stats
+
+	mutex  sync.RWMutex
+	checks map[string]ReadinessCheck
+}
+
+func NewChecker(stats func() Stats) *Checker {
+	return &Checker{
+		startedAt: time.Now(),
+		stats:     stats,
+		checks:    make(map[string]ReadinessCheck),
+	}
+}
+
+// AddReadinessCheck: registers a check which must pass for the server to be ready
+func (c *Checker) AddReadinessCheck(name string, check ReadinessCheck) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.checks[name] = check
+}
+
+// StartDraining: makes the readiness endpoint fail, so that the load balancer stops sending new clients
+func (c *Checker) StartDraining() {
+	c.draining.Store(true)
+}
+
+// HealthzHandler: reports that the process is alive
+func (c *Checker) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadyzHandler: reports whether the server can accept new clients, it fails while the server is draining
+// or when one of the readiness checks fails
+func (c *Checker) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	failedChecks := make(map[string]string)
+
+	if c.draining.Load() {
+		failedChecks["draining"] = errDraining.Error()
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+	defer cancel()
+
+	c.mutex.RLock()
+	for name, check := range c.checks {
+		err := check(ctx)
+		if err != nil {
+			failedChecks[name] = err.Error()
+		}
+	}
+	c.mutex.RUnlock()
+
+	if len(failedChecks) > 0 {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "unavailable", "failed_checks": failedChecks})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// VersionHandler: reports the build info, the uptime and the current counters of the server
+func (c *Checker) VersionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"version":        Version,
+		"commit":         commit(),
+		"go_version":     goVersion(),
+		"started_at":     c.startedAt.UTC().Format(time.RFC3339),
+		"uptime_seconds": int64(time.Since(c.startedAt).Seconds()),
+		"stats":          c.stats(),
+	})
+}
+
+func commit() string {
+	if Commit != "" {
+		return Commit
+	}
+
+	buildInfo, ok := debug.ReadBuildInfo()
+	if ok {
+		for _, setting := range buildInfo.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+func goVersion() string {
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	return buildInfo.GoVersion
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,50 @@
+package logger
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// The keys of the fields which are attached to the log records, they are shared by all the packages
+// so that the records of a room or a member can be filtered in the same way everywhere
+const (
+	KeyRoomID     = "room_id"
+	KeyMemberID   = "member_id"
+	KeyMemberName = "member_name"
+	KeyEventType  = "event_type"
+	KeyRemoteAddr = "remote_addr"
+	KeyErrorCode  = "error_code"
+	KeyError      = "error"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Setup: configures the default slog logger, which is also used by the standard library's log package,
+// with the given output format (text or json) and minimum level (debug, info, warn or error)
+func Setup(format string, level string) error {
+	var slogLevel slog.Level
+	err := slogLevel.UnmarshalText([]byte(level))
+	if err != nil {
+		return fmt.Errorf("invalid log level: %q, expected one of debug, info, warn or error", level)
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: slogLevel}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case FormatText:
+		handler = slog.NewTextHandler(os.Stderr, handlerOptions)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(os.Stderr, handlerOptions)
+	default:
+		return fmt.Errorf("invalid log format: %q, expected one of text or json", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "estimatex"
+
+// registry holds all the metrics of the server, a dedicated registry is used instead of the global
+// default one so that only the metrics registered here are exposed
+var registry = prometheus.NewRegistry()
+
+var (
+	ActiveRooms = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_rooms",
+		Help:      "Number of rooms which are currently open.",
+	})
+
+	ConnectedMembers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connected_members",
+		Help:      "Number of members which are currently connected, by role.",
+	}, []string{"role"})
+
+	EventsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_received_total",
+		Help:      "Number of events received from the clients, by event type.",
+	}, []string{"type"})
+
+	EventsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_sent_total",
+		Help:      "Number of events sent to the clients, by event type.",
+	}, []string{"type"})
+
+	EventHandlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "event_handler_duration_seconds",
+		Help:      "Time taken by the room to handle an incoming event, by event type.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"type"})
+
+	WebsocketWriteDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "websocket_write_duration_seconds",
+		Help:      "Time taken to write a message to a client's websocket connection.",
+		Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+	})
+
+	WebsocketWriteErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_write_errors_total",
+		Help:      "Number of messages which could not be written to a client's websocket connection.",
+	})
+
+	WebsocketUpgradeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_upgrade_failures_total",
+		Help:      "Number of websocket upgrade requests which were refused or failed, by reason.",
+	}, []string{"reason"})
+
+	Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_total",
+		Help:      "Number of errors reported to the clients, by error code.",
+	}, []string{"code"})
+
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Number of webhook deliveries, by result: delivered, failed after all the attempts, or dropped because the queue was full.",
+	}, []string{"result"})
+
+	ProtocolVersionConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "protocol_version_connections_total",
+		Help:      "Number of clients which joined a room, by version of the protocol they speak.",
+	}, []string{"protocol_version"})
+
+	RoomVoteRounds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "room_vote_rounds",
+		Help:      "Number of voting rounds started in a room, the series is removed when the room is closed.",
+	}, []string{"room_id"})
+
+	SnapshotWriteDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "snapshot_write_duration_seconds",
+		Help:      "Time taken to write the snapshot of a room.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	})
+
+	SnapshotErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "snapshot_errors_total",
+		Help:      "Number of room snapshots which could not be written or deleted.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ActiveRooms,
+		ConnectedMembers,
+		EventsReceived,
+		EventsSent,
+		EventHandlerDuration,
+		WebsocketWriteDuration,
+		WebsocketWriteErrors,
+		WebsocketUpgradeFailures,
+		Errors,
+		WebhookDeliveries,
+		ProtocolVersionConnections,
+		RoomVoteRounds,
+		SnapshotWriteDuration,
+		SnapshotErrors,
+	)
+}
+
+// RegisterRejectedCounter: exposes the number of requests rejected by a rate limiter, the value is read
+// from the counter function every time the metrics are scraped
+func RegisterRejectedCounter(limiter string, counter func() uint64) {
+	registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Name:        "rate_limited_total",
+		Help:        "Number of requests rejected by a rate limiter, by limiter.",
+		ConstLabels: prometheus.Labels{"limiter": limiter},
+	}, func() float64 {
+		return float64(counter())
+	}))
+}
+
+// MemberRole: returns the value of the role label for a member
+func MemberRole(isRoomAdmin bool) string {
+	if isRoomAdmin {
+		return "admin"
+	}
+	return "member"
+}
+
+// Handler: serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
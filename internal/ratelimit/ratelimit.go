@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// idleBucketTTL is the time after which a bucket that has not been touched is removed from the limiter
+const idleBucketTTL = 10 * time.Minute
+
+// Limiter: a keyed token bucket rate limiter, safe for concurrent use.
+// Every key (e.g. an IP address or a member id) gets its own bucket which starts full.
+// A nil *Limiter is valid and never limits anything.
+type Limiter struct {
+	// rejected counts the calls which were refused because the bucket was empty
+	rejected atomic.Uint64
+
+	mutex sync.Mutex
+
+	// ratePerSecond is the number of tokens added to a bucket every second
+	ratePerSecond float64
+	burst         float64
+
+	// Key: caller provided key, Value: *bucket
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// New: creates a limiter which refills ratePerSecond tokens every second, up to a maximum of burst tokens
+func New(ratePerSecond float64, burst int) *Limiter {
+	return &Limiter{
+		ratePerSecond: ratePerSecond,
+		burst:         float64(burst),
+		buckets:       make(map[string]*bucket),
+		lastSweep:     time.Now(),
+	}
+}
+
+// PerMinute: creates a limiter which refills perMinute tokens every minute, up to a maximum of burst tokens.
+// It returns a nil limiter, which allows everything, when perMinute is zero.
+func PerMinute(perMinute int, burst int) *Limiter {
+	if perMinute == 0 {
+		return nil
+	}
+	return New(float64(perMinute)/60, burst)
+}
+
+// Allow: takes a token from the key's bucket, it returns false if the bucket is empty
+func (l *Limiter) Allow(key string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	b := l.refill(key)
+	if b.tokens < 1 {
+		l.rejected.Add(1)
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// Blocked: reports whether the key's bucket is empty, without taking a token from it
+func (l *Limiter) Blocked(key string) bool {
+	if l == nil {
+		return false
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.refill(key).tokens < 1 {
+		l.rejected.Add(1)
+		return true
+	}
+	return false
+}
+
+// Rejected: returns the number of calls which have been refused since the limiter was created
+func (l *Limiter) Rejected() uint64 {
+	if l == nil {
+		return 0
+	}
+	return l.rejected.Load()
+}
+
+// refill: returns the bucket for the key after adding the tokens accumulated since its last refill.
+// The caller must hold the mutex.
+func (l *Limiter) refill(key string) *bucket {
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, lastRefill: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.lastRefill).Seconds()*l.ratePerSecond)
+	b.lastRefill = now
+	return b
+}
+
+// sweep: removes the buckets which have not been used for a while, so that the map does not grow forever.
+// The caller must hold the mutex.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleBucketTTL {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if now.Sub(b.lastRefill) >= idleBucketTTL {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
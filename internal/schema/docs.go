@@ -0,0 +1,93 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Markdown: documents the events of the protocol along with the fields of their payloads
+func (d *Document) Markdown() string {
+	var builder strings.Builder
+
+	builder.WriteString("# EstimateX protocol\n\n")
+	builder.WriteString("Generated from the schema of the protocol, which is served at `/schema`.\n")
+
+	d.writeEvents(&builder, "Incoming Events", d.Root.Defs["IncomingEvent"])
+	d.writeEvents(&builder, "Outgoing Events", d.Root.Defs["OutgoingEvent"])
+
+	return builder.String()
+}
+
+func (d *Document) writeEvents(builder *strings.Builder, title string, events *Schema) {
+	fmt.Fprintf(builder, "\n## %s\n", title)
+
+	for _, message := range events.OneOf {
+		fmt.Fprintf(builder, "\n### `%s`\n\n%s.\n\n", message.Title, message.Description)
+
+		versions := make([]string, 0, len(message.ProtocolVersions))
+		for _, version := range message.ProtocolVersions {
+			versions = append(versions, fmt.Sprintf("`%d`", version))
+		}
+		fmt.Fprintf(builder, "Protocol versions: %s\n", strings.Join(versions, ", "))
+
+		dataSchema := message.Properties["data"]
+		if len(dataSchema.Properties) == 0 {
+			builder.WriteString("\nNo payload.\n")
+			continue
+		}
+
+		builder.WriteString("\n| Field | Type | Required |\n|-------|------|----------|\n")
+		d.writeFields(builder, dataSchema, "")
+	}
+}
+
+// writeFields: lists the fields of an object, the fields of the nested objects are prefixed with the name of their parent
+func (d *Document) writeFields(builder *strings.Builder, objectSchema *Schema, prefix string) {
+	names := make([]string, 0, len(objectSchema.Properties))
+	for name := range objectSchema.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fieldSchema := objectSchema.Properties[name]
+		required := "no"
+		for _, requiredName := range objectSchema.Required {
+			if requiredName == name {
+				required = "yes"
+			}
+		}
+		fmt.Fprintf(builder, "| `%s%s` | %s | %s |\n", prefix, name, d.typeName(fieldSchema), required)
+
+		nestedSchema := d.resolve(fieldSchema)
+		if nestedSchema.Type == "array" {
+			nestedSchema = d.resolve(nestedSchema.Items)
+			if nestedSchema.Type == "object" {
+				d.writeFields(builder, nestedSchema, prefix+name+"[].")
+			}
+			continue
+		}
+		if nestedSchema.Type == "object" && len(nestedSchema.Properties) > 0 {
+			d.writeFields(builder, nestedSchema, prefix+name+".")
+		}
+	}
+}
+
+func (d *Document) typeName(fieldSchema *Schema) string {
+	fieldSchema = d.resolve(fieldSchema)
+	switch fieldSchema.Type {
+	case "":
+		return "any"
+	case "array":
+		return "array of " + d.typeName(fieldSchema.Items)
+	}
+	return fieldSchema.Type
+}
+
+func (d *Document) resolve(fieldSchema *Schema) *Schema {
+	if fieldSchema.Ref != "" {
+		return d.Root.Defs[strings.TrimPrefix(fieldSchema.Ref, "#/$defs/")]
+	}
+	return fieldSchema
+}
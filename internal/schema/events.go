@@ -0,0 +1,71 @@
+package schema
+
+import (
+	"github.com/skamranahmed/estimatex-server/internal/event"
+)
+
+// eventSchema: an event of the protocol along with its payload, Data is nil when the event has no payload.
+// The event is part of the versions of the protocol from Since to Until, Until is 0 when it is part of the latest one.
+type eventSchema struct {
+	Type        event.EventType
+	Description string
+	Data        any
+	Since       int
+	Until       int
+}
+
+// incomingEvents are the events sent by the clients, their payloads are validated before being handled
+var incomingEvents = []eventSchema{
+	{Type: event.EventJoinRoom, Description: "Sent by a client once it is connected, to join the room"},
+	{Type: event.EventBeginVoting, Description: "Sent by the admin to start the voting of a ticket", Data: event.BeginVotingEventData{}},
+	{Type: event.EventMemberVoted, Description: "Sent by a member to vote for a ticket", Data: event.MemberVotedEventData{}},
+	{Type: event.EventRevealVotes, Description: "Sent by the admin to reveal the votes of a ticket", Data: event.RevealVotesEventData{}},
+	{Type: event.EventCreateInvite, Description: "Sent by the admin to mint an invite token, ttl_seconds defaults to 24 hours and is at most 7 days", Data: event.CreateInviteEventData{}},
+	{Type: event.EventRevokeInvite, Description: "Sent by the admin to revoke an invite token", Data: event.RevokeInviteEventData{}},
+	{Type: event.EventKickMember, Description: "Sent by the admin to remove a member from the room, and to ban its IP address when ban is true", Data: event.KickMemberEventData{}},
+	{Type: event.EventMuteMember, Description: "Sent by the admin to mute or unmute a member", Data: event.MuteMemberEventData{}},
+	{Type: event.EventSetFinalEstimate, Description: "Sent by the admin to set the final estimate of a ticket", Data: event.SetFinalEstimateEventData{}},
+	{Type: event.EventReplayEvents, Description: "Sent by a member to get again the events it has been sent after since_seq", Data: event.ReplayEventsEventData{}},
+	{Type: event.EventGetRoomState, Description: "Sent by a member to get a ROOM_STATE event"},
+}
+
+// outgoingEvents are the events sent by the server
+var outgoingEvents = []eventSchema{
+	{Type: event.EventCreateRoom, Description: "The room has been created, sent to its admin", Data: event.CreateRoomEventData{}},
+	{Type: event.EventRoomJoinUpdates, Description: "A member has joined the room", Data: event.RoomJoinUpdatesEventData{}},
+	{Type: event.EventRoomCapacityReached, Description: "The room is full", Data: event.RoomCapacityReachedEventData{}},
+	{Type: event.EventBeginVotingPrompt, Description: "Prompts the admin to start the voting of a ticket", Data: event.BeginVotingPromptEventData{}},
+	{Type: event.EventAskForVote, Description: "Asks a member to vote, with the details of the ticket when it has been found in the issue tracker", Data: event.AskForVoteEventData{}},
+	{Type: event.EventMemberVoted, Description: "A member has voted, the vote is not revealed", Data: event.MemberVoteCastEventData{}, Since: event.ProtocolVersion2},
+	{Type: event.EventVotingCompleted, Description: "Every member has voted", Data: event.VotingCompletedEventData{}},
+	{Type: event.EventRevealVotesPrompt, Description: "Prompts the admin to reveal the votes", Data: event.RevealVotesPromptEventData{}},
+	{Type: event.EventVotesRevealed, Description: "The votes of a ticket, keyed by member id", Data: event.VotesRevealedEventData{}, Until: event.ProtocolVersion1},
+	{Type: event.EventVotesRevealed, Description: "The votes of a ticket", Data: event.VotesRevealedV2EventData{}, Since: event.ProtocolVersion2},
+	{Type: event.EventAwaitingAdminVoteStart, Description: "Waiting for the admin to start the voting of the next ticket", Data: event.AwaitingAdminVoteStartEventData{}},
+	{Type: event.EventInviteCreated, Description: "An invite token has been minted for the admin", Data: event.InviteCreatedEventData{}},
+	{Type: event.EventInviteRevoked, Description: "An invite token has been revoked by the admin", Data: event.InviteRevokedEventData{}},
+	{Type: event.EventRateLimited, Description: "An event has been dropped because the member or the room exceeded its rate limit", Data: event.RateLimitedEventData{}},
+	{Type: event.EventError, Description: "An event has been rejected", Data: event.ErrorEventData{}},
+	{Type: event.EventRoomClosed, Description: "The room has been closed by an operator, the connection is closed right after", Data: event.RoomClosedEventData{}},
+	{Type: event.EventMemberKicked, Description: "A member has been removed from the room", Data: event.MemberKickedEventData{}},
+	{Type: event.EventMemberMuted, Description: "A member has been muted or unmuted by the admin", Data: event.MemberMutedEventData{}},
+	{Type: event.EventFinalEstimateSet, Description: "The admin has set the final estimate of a ticket", Data: event.FinalEstimateSetEventData{}},
+	{Type: event.EventResumeToken, Description: "The credentials with which the member resumes its seat after a restart of the server", Data: event.ResumeTokenEventData{}},
+	{Type: event.EventWelcome, Description: "The first event sent to a client, with the versions of the protocol and the capabilities of the server", Data: event.WelcomeEventData{}, Since: event.ProtocolVersion2},
+	{Type: event.EventAck, Description: "An incoming event with an id has been accepted", Data: event.AckEventData{}},
+	{Type: event.EventRoomState, Description: "The whole state of the room, sent on join from the version 2 of the protocol and on GET_ROOM_STATE", Data: event.RoomStateEventData{}},
+}
+
+// protocolVersions: the versions of the protocol the event is part of
+func (e eventSchema) protocolVersions() []int {
+	since, until := max(e.Since, event.ProtocolVersion1), e.Until
+	if until == 0 {
+		until = event.LatestProtocolVersion
+	}
+
+	versions := make([]int, 0, until-since+1)
+	for version := since; version <= until; version++ {
+		versions = append(versions, version)
+	}
+	return versions
+}
@@ -0,0 +1,201 @@
+// Package schema describes the event protocol with a JSON Schema document, which is generated from the payloads of
+// the events, and validates the payloads of the incoming events against it
+package schema
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/skamranahmed/estimatex-server/internal/event"
+)
+
+const dialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema: the subset of JSON Schema which is needed to describe the events
+type Schema struct {
+	Dialect     string `json:"$schema,omitempty"`
+	Ref         string `json:"$ref,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	Type                 string             `json:"type,omitempty"`
+	Const                string             `json:"const,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+
+	// ProtocolVersions are the versions of the protocol an event is part of
+	ProtocolVersions []int `json:"x-protocol-versions,omitempty"`
+
+	Defs map[string]*Schema `json:"$defs,omitempty"`
+}
+
+// Document: the schema of the protocol, along with the schemas of the payloads of the incoming events
+type Document struct {
+	Root *Schema
+
+	// Key: EventType, Value: the schema of the payload of the incoming event
+	incomingData map[event.EventType]*Schema
+}
+
+var protocol = sync.OnceValue(func() *Document {
+	return generate(incomingEvents, outgoingEvents)
+})
+
+// Protocol: returns the schema of the protocol, it is generated once
+func Protocol() *Document {
+	return protocol()
+}
+
+// generate: builds the schema of the given events, the payloads are described from the json tags of their fields.
+// A field is required unless it is tagged with omitempty.
+func generate(incoming []eventSchema, outgoing []eventSchema) *Document {
+	g := &generator{defs: map[string]*Schema{}}
+	document := &Document{incomingData: map[event.EventType]*Schema{}}
+
+	envelope := g.schemaOf(reflect.TypeOf(event.Event{}), true)
+
+	incomingEvent := &Schema{Description: "An event sent by a client"}
+	for _, incomingEventSchema := range incoming {
+		message, dataSchema := g.message(envelope, incomingEventSchema, "id")
+		incomingEvent.OneOf = append(incomingEvent.OneOf, message)
+		document.incomingData[incomingEventSchema.Type] = dataSchema
+	}
+
+	outgoingEvent := &Schema{Description: "An event sent by the server"}
+	for _, outgoingEventSchema := range outgoing {
+		message, _ := g.message(envelope, outgoingEventSchema, "seq", "prev_seq", "sent_at", "trace_id")
+		outgoingEvent.OneOf = append(outgoingEvent.OneOf, message)
+	}
+
+	g.defs["IncomingEvent"] = incomingEvent
+	g.defs["OutgoingEvent"] = outgoingEvent
+
+	document.Root = &Schema{
+		Dialect:     dialect,
+		Title:       "EstimateX protocol",
+		Description: "The events exchanged over the websocket connection, every event is a JSON object with its type and its data",
+		OneOf:       []*Schema{{Ref: "#/$defs/IncomingEvent"}, {Ref: "#/$defs/OutgoingEvent"}},
+		Defs:        g.defs,
+	}
+	return document
+}
+
+// ServeHTTP: serves the schema of the protocol
+func (d *Document) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(d.Root)
+}
+
+type generator struct {
+	// Key: the name of a struct, Value: its schema
+	defs map[string]*Schema
+}
+
+// message: describes an event with its envelope, only the given fields of the envelope are kept besides its type and its data
+func (g *generator) message(envelope *Schema, eventSchema eventSchema, envelopeFields ...string) (*Schema, *Schema) {
+	dataSchema := &Schema{Type: "object"}
+	if eventSchema.Data != nil {
+		dataSchema = g.schemaOf(reflect.TypeOf(eventSchema.Data), true)
+	}
+
+	message := &Schema{
+		Title:            string(eventSchema.Type),
+		Description:      eventSchema.Description,
+		Type:             "object",
+		Properties:       map[string]*Schema{"type": {Const: string(eventSchema.Type)}, "data": dataSchema},
+		Required:         []string{"type"},
+		ProtocolVersions: eventSchema.protocolVersions(),
+	}
+	if eventSchema.Data != nil {
+		message.Required = append(message.Required, "data")
+	}
+
+	for _, envelopeField := range envelopeFields {
+		message.Properties[envelopeField] = envelope.Properties[envelopeField]
+	}
+	return message, dataSchema
+}
+
+// schemaOf: describes a Go type, the structs are described once in the definitions and referenced,
+// unless inline is set
+func (g *generator) schemaOf(t reflect.Type, inline bool) *Schema {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		minimum := 0
+		return &Schema{Type: "integer", Minimum: &minimum}
+
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: g.schemaOf(t.Elem(), false)}
+
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem(), false)}
+
+	case reflect.Struct:
+		if !inline {
+			if _, ok := g.defs[t.Name()]; !ok {
+				// the definition is reserved before the fields are described, so that a struct can refer to itself
+				g.defs[t.Name()] = &Schema{}
+				*g.defs[t.Name()] = *g.structSchema(t)
+			}
+			return &Schema{Ref: "#/$defs/" + t.Name()}
+		}
+		return g.structSchema(t)
+	}
+
+	// the interfaces can hold any value
+	return &Schema{}
+}
+
+func (g *generator) structSchema(t reflect.Type) *Schema {
+	structSchema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		structSchema.Properties[name] = g.schemaOf(field.Type, false)
+		if !strings.Contains(options, "omitempty") {
+			structSchema.Required = append(structSchema.Required, name)
+		}
+	}
+
+	return structSchema
+}
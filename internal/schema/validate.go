@@ -0,0 +1,139 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/skamranahmed/estimatex-server/internal/event"
+)
+
+// ValidationError: describes why the payload of an incoming event does not match its schema. Missing is set when
+// a required field is absent, as opposed to a field whose value does not have the expected type.
+type ValidationError struct {
+	Field   string
+	Message string
+	Missing bool
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// ValidateIncoming: checks the payload of an incoming event against its schema, the events which are not part of
+// the protocol are not validated. A missing payload is validated as an empty object, since the clients which have
+// nothing to send omit it.
+func (d *Document) ValidateIncoming(eventType string, data json.RawMessage) *ValidationError {
+	dataSchema, ok := d.incomingData[event.EventType(eventType)]
+	if !ok {
+		return nil
+	}
+
+	trimmedData := bytes.TrimSpace(data)
+	if len(trimmedData) == 0 || bytes.Equal(trimmedData, []byte("null")) {
+		trimmedData = []byte("{}")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(trimmedData))
+	decoder.UseNumber()
+
+	var value any
+	err := decoder.Decode(&value)
+	if err != nil {
+		return &ValidationError{Field: "data", Message: "data is not valid JSON"}
+	}
+
+	return d.validate(dataSchema, value, "data")
+}
+
+// validate: checks a decoded JSON value against the schema, the properties which are not described by the schema
+// are accepted so that the clients can send the fields of later versions of the protocol
+func (d *Document) validate(schema *Schema, value any, path string) *ValidationError {
+	if schema.Ref != "" {
+		schema = d.Root.Defs[strings.TrimPrefix(schema.Ref, "#/$defs/")]
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return typeError(path, "an object")
+		}
+
+		for _, required := range schema.Required {
+			if _, ok := object[required]; !ok {
+				field := path + "." + required
+				return &ValidationError{Field: field, Message: fmt.Sprintf("%s is required", field), Missing: true}
+			}
+		}
+
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			propertySchema, ok := schema.Properties[key]
+			if !ok {
+				propertySchema = schema.AdditionalProperties
+			}
+			if propertySchema == nil {
+				continue
+			}
+
+			if validationError := d.validate(propertySchema, object[key], path+"."+key); validationError != nil {
+				return validationError
+			}
+		}
+
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return typeError(path, "an array")
+		}
+
+		for i, item := range array {
+			if validationError := d.validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i)); validationError != nil {
+				return validationError
+			}
+		}
+
+	case "string":
+		if _, ok := value.(string); !ok {
+			return typeError(path, "a string")
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return typeError(path, "a boolean")
+		}
+
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return typeError(path, "an integer")
+		}
+
+		// the unsigned integers do not fit in an int64, hence they are parsed on their own
+		if schema.Minimum != nil && *schema.Minimum == 0 {
+			if _, err := strconv.ParseUint(number.String(), 10, 64); err != nil {
+				return typeError(path, "a non-negative integer")
+			}
+			return nil
+		}
+
+		if _, err := strconv.ParseInt(number.String(), 10, 64); err != nil {
+			return typeError(path, "an integer")
+		}
+	}
+
+	return nil
+}
+
+func typeError(path string, expected string) *ValidationError {
+	return &ValidationError{Field: path, Message: fmt.Sprintf("%s must be %s", path, expected)}
+}
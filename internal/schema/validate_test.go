@@ -0,0 +1,104 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/skamranahmed/estimatex-server/internal/event"
+)
+
+func TestValidateIncoming(t *testing.T) {
+	testCases := []struct {
+		name      string
+		eventType event.EventType
+		data      string
+
+		// wantField is the field reported by the validation error, it is empty when the payload is valid
+		wantField   string
+		wantMissing bool
+	}{
+		{name: "join room without payload", eventType: event.EventJoinRoom, data: ""},
+		{name: "join room with a null payload", eventType: event.EventJoinRoom, data: "null"},
+
+		{name: "begin voting", eventType: event.EventBeginVoting, data: `{"ticket_id":"ABC-1"}`},
+		{name: "begin voting without ticket id", eventType: event.EventBeginVoting, data: `{}`, wantField: "data.ticket_id", wantMissing: true},
+		{name: "begin voting with a numeric ticket id", eventType: event.EventBeginVoting, data: `{"ticket_id":1}`, wantField: "data.ticket_id"},
+		{name: "begin voting with an array payload", eventType: event.EventBeginVoting, data: `["ABC-1"]`, wantField: "data"},
+		{name: "begin voting with invalid JSON", eventType: event.EventBeginVoting, data: `{"ticket_id":`, wantField: "data"},
+
+		{name: "member voted", eventType: event.EventMemberVoted, data: `{"ticket_id":"ABC-1","vote":"5"}`},
+		{name: "member voted without vote", eventType: event.EventMemberVoted, data: `{"ticket_id":"ABC-1"}`, wantField: "data.vote", wantMissing: true},
+		{name: "member voted with a numeric vote", eventType: event.EventMemberVoted, data: `{"ticket_id":"ABC-1","vote":5}`, wantField: "data.vote"},
+
+		{name: "reveal votes", eventType: event.EventRevealVotes, data: `{"ticket_id":"ABC-1"}`},
+		{name: "reveal votes without ticket id", eventType: event.EventRevealVotes, data: `{}`, wantField: "data.ticket_id", wantMissing: true},
+		{name: "reveal votes with a null ticket id", eventType: event.EventRevealVotes, data: `{"ticket_id":null}`, wantField: "data.ticket_id"},
+
+		// the ttl is optional, it defaults to 24 hours
+		{name: "create invite without ttl", eventType: event.EventCreateInvite, data: `{}`},
+		{name: "create invite with a ttl", eventType: event.EventCreateInvite, data: `{"ttl_seconds":3600}`},
+		{name: "create invite with a string ttl", eventType: event.EventCreateInvite, data: `{"ttl_seconds":"3600"}`, wantField: "data.ttl_seconds"},
+		{name: "create invite with a fractional ttl", eventType: event.EventCreateInvite, data: `{"ttl_seconds":1.5}`, wantField: "data.ttl_seconds"},
+		{name: "create invite with a ttl out of range", eventType: event.EventCreateInvite, data: `{"ttl_seconds":9223372036854775808}`, wantField: "data.ttl_seconds"},
+
+		{name: "revoke invite", eventType: event.EventRevokeInvite, data: `{"invite_id":"abc"}`},
+		{name: "revoke invite without invite id", eventType: event.EventRevokeInvite, data: `{}`, wantField: "data.invite_id", wantMissing: true},
+
+		// the ban is optional, the member is only removed by default
+		{name: "kick member without ban", eventType: event.EventKickMember, data: `{"member_id":"abc"}`},
+		{name: "kick member with ban", eventType: event.EventKickMember, data: `{"member_id":"abc","ban":true}`},
+		{name: "kick member without member id", eventType: event.EventKickMember, data: `{"ban":true}`, wantField: "data.member_id", wantMissing: true},
+		{name: "kick member with a string ban", eventType: event.EventKickMember, data: `{"member_id":"abc","ban":"true"}`, wantField: "data.ban"},
+
+		{name: "mute member", eventType: event.EventMuteMember, data: `{"member_id":"abc","muted":false}`},
+		{name: "mute member without muted", eventType: event.EventMuteMember, data: `{"member_id":"abc"}`, wantField: "data.muted", wantMissing: true},
+		{name: "mute member with a numeric muted", eventType: event.EventMuteMember, data: `{"member_id":"abc","muted":1}`, wantField: "data.muted"},
+
+		{name: "set final estimate", eventType: event.EventSetFinalEstimate, data: `{"ticket_id":"ABC-1","estimate":"8"}`},
+		{name: "set final estimate without estimate", eventType: event.EventSetFinalEstimate, data: `{"ticket_id":"ABC-1"}`, wantField: "data.estimate", wantMissing: true},
+
+		{name: "replay events", eventType: event.EventReplayEvents, data: `{"since_seq":0}`},
+		{name: "replay events since the largest seq", eventType: event.EventReplayEvents, data: `{"since_seq":18446744073709551615}`},
+		{name: "replay events since a seq out of range", eventType: event.EventReplayEvents, data: `{"since_seq":18446744073709551616}`, wantField: "data.since_seq"},
+		{name: "replay events since a negative seq", eventType: event.EventReplayEvents, data: `{"since_seq":-1}`, wantField: "data.since_seq"},
+		{name: "replay events since a fractional seq", eventType: event.EventReplayEvents, data: `{"since_seq":1.5}`, wantField: "data.since_seq"},
+		{name: "replay events without seq", eventType: event.EventReplayEvents, data: `{}`, wantField: "data.since_seq", wantMissing: true},
+
+		{name: "get room state", eventType: event.EventGetRoomState, data: `{}`},
+
+		// the fields of a later version of the protocol, and the events which are not part of it, are not rejected
+		{name: "unknown field", eventType: event.EventBeginVoting, data: `{"ticket_id":"ABC-1","priority":1}`},
+		{name: "unknown event", eventType: "DANCE", data: `{"ticket_id":1}`},
+	}
+
+	document := Protocol()
+	testedEventTypes := make(map[event.EventType]bool)
+
+	for _, testCase := range testCases {
+		testedEventTypes[testCase.eventType] = true
+
+		t.Run(testCase.name, func(t *testing.T) {
+			validationError := document.ValidateIncoming(string(testCase.eventType), json.RawMessage(testCase.data))
+
+			if testCase.wantField == "" {
+				if validationError != nil {
+					t.Fatalf("got %v, want the payload to be valid", validationError)
+				}
+				return
+			}
+
+			if validationError == nil {
+				t.Fatalf("got a valid payload, want an error on %s", testCase.wantField)
+			}
+			if validationError.Field != testCase.wantField || validationError.Missing != testCase.wantMissing {
+				t.Fatalf("got an error on %s (missing: %t), want %s (missing: %t)", validationError.Field, validationError.Missing, testCase.wantField, testCase.wantMissing)
+			}
+		})
+	}
+
+	for _, incomingEvent := range incomingEvents {
+		if !testedEventTypes[incomingEvent.Type] {
+			t.Errorf("the payload of %s is not tested", incomingEvent.Type)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package session
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	// RoomIDStyleAlphanumeric generates ids like `aZ3kQ9` using upper case letters, lower case letters and digits
+	RoomIDStyleAlphanumeric = "alphanumeric"
+
+	// RoomIDStyleUnambiguous generates ids like `k7wq3m` without the characters that are easy to mix up when
+	// reading an id out loud or copying it by hand (0/o, 1/l/i, upper case letters)
+	RoomIDStyleUnambiguous = "unambiguous"
+
+	// RoomIDStyleWords generates ids like `brave-otter-plum` using a list of short, distinct english words
+	RoomIDStyleWords = "words"
+
+	alphanumericAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	unambiguousAlphabet  = "23456789abcdefghjkmnpqrstuvwxyz"
+	wordSeparator        = "-"
+)
+
+// RoomIDGenerator: generates the ids of new rooms. The SessionManager takes care of collisions, so an
+// implementation only needs to produce ids which are hard to guess.
+type RoomIDGenerator interface {
+	Generate() (string, error)
+}
+
+// NewRoomIDGenerator: creates the generator for the given style. The length is the number of characters for
+// the character based styles and the number of words for the words style. A non empty alphabet overrides the
+// characters used by the character based styles.
+func NewRoomIDGenerator(style string, length int, alphabet string) (RoomIDGenerator, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("room id length must be greater than zero, got: %d", length)
+	}
+
+	switch style {
+	case RoomIDStyleAlphanumeric, RoomIDStyleUnambiguous:
+		if alphabet == "" {
+			alphabet = alphanumericAlphabet
+			if style == RoomIDStyleUnambiguous {
+				alphabet = unambiguousAlphabet
+			}
+		}
+		if len(alphabet) < 2 {
+			return nil, fmt.Errorf("room id alphabet must have at least two characters, got: %q", alphabet)
+		}
+		return &characterRoomIDGenerator{length: length, alphabet: alphabet}, nil
+
+	case RoomIDStyleWords:
+		return &wordRoomIDGenerator{count: length}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported room id style: %q", style)
+	}
+}
+
+type characterRoomIDGenerator struct {
+	length   int
+	alphabet string
+}
+
+func (g *characterRoomIDGenerator) Generate() (string, error) {
+	b := make([]byte, g.length)
+	for i := range b {
+		index, err := randomIndex(len(g.alphabet))
+		if err != nil {
+			return "", err
+		}
+		b[i] = g.alphabet[index]
+	}
+	return string(b), nil
+}
+
+type wordRoomIDGenerator struct {
+	count int
+}
+
+func (g *wordRoomIDGenerator) Generate() (string, error) {
+	words := make([]string, g.count)
+	for i := range words {
+		index, err := randomIndex(len(roomIDWords))
+		if err != nil {
+			return "", err
+		}
+		words[i] = roomIDWords[index]
+	}
+	return strings.Join(words, wordSeparator), nil
+}
+
+// randomIndex: returns a uniformly distributed random number in [0, n) read from crypto/rand
+func randomIndex(n int) (int, error) {
+	index, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("unable to read random bytes: %w", err)
+	}
+	return int(index.Int64()), nil
+}
+
+// roomIDWords are 256 short and common words which are easy to tell apart, each word adds 8 bits to a room id
+var roomIDWords = [...]string{
+	"acorn", "amber", "anchor", "angle", "apple", "apron", "arrow", "aspen",
+	"atlas", "badge", "bagel", "bamboo", "banjo", "barley", "basil", "beach",
+	"beacon", "berry", "birch", "bison", "blaze", "bloom", "bonsai", "brave",
+	"breeze", "brick", "bridge", "brook", "bubble", "cabin", "cactus", "camel",
+	"candle", "canoe", "canyon", "carbon", "cargo", "carrot", "castle", "cedar",
+	"cello", "chalk", "cherry", "chess", "cider", "cinder", "citrus", "clover",
+	"cobalt", "cocoa", "comet", "coral", "cotton", "cougar", "crane", "crater",
+	"cricket", "crystal", "cumin", "daisy", "delta", "denim", "desert", "dingo",
+	"dolphin", "dragon", "drift", "eagle", "earth", "easel", "echo", "ember",
+	"emerald", "falcon", "fable", "fennel", "fern", "fiddle", "field", "finch",
+	"flame", "flint", "forest", "fossil", "fox", "frost", "galaxy", "garden",
+	"garnet", "gecko", "ginger", "glacier", "glider", "goblin", "granite", "grape",
+	"gravel", "guitar", "harbor", "hazel", "heron", "hickory", "honey", "hornet",
+	"husky", "igloo", "indigo", "island", "ivory", "jackal", "jade", "jasmine",
+	"jelly", "jersey", "jungle", "juniper", "kayak", "kernel", "kettle", "kiwi",
+	"koala", "lagoon", "lantern", "larch", "lava", "lemon", "lentil", "lily",
+	"linen", "lizard", "llama", "lobster", "lotus", "lunar", "magnet", "mango",
+	"maple", "marble", "meadow", "melon", "meteor", "mint", "mocha", "monsoon",
+	"moose", "mosaic", "moss", "muffin", "nectar", "needle", "nickel", "nimbus",
+	"noodle", "nutmeg", "oasis", "ocean", "olive", "onyx", "opal", "orbit",
+	"orchid", "otter", "oyster", "paddle", "panda", "papaya", "parrot", "peach",
+	"pebble", "pepper", "pigeon", "pillow", "pine", "pixel", "planet", "plum",
+	"polar", "poppy", "prairie", "prism", "puffin", "pumpkin", "quartz", "quill",
+	"rabbit", "radar", "radish", "rain", "raven", "reef", "ribbon", "ripple",
+	"river", "robin", "rocket", "rose", "ruby", "saddle", "saffron", "salmon",
+	"sand", "sapphire", "scarf", "sequoia", "shadow", "shell", "sierra", "silver",
+	"sketch", "slate", "sparrow", "spruce", "squid", "stone", "summit", "sunset",
+	"swan", "tango", "teapot", "thistle", "thunder", "tiger", "timber", "toast",
+	"topaz", "trout", "tulip", "tundra", "turtle", "umbra", "valley", "velvet",
+	"violet", "volcano", "waffle", "walnut", "walrus", "willow", "window", "winter",
+	"wizard", "wombat", "yarrow", "yeti", "zebra", "zenith", "zephyr", "zinc",
+	"acacia", "bramble", "compass", "dune", "fjord", "harvest", "mantis", "pearl",
+}
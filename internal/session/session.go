@@ -1,17 +1,35 @@
 package session
 
 import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/skamranahmed/estimatex-server/internal/cluster"
 	"github.com/skamranahmed/estimatex-server/internal/entity"
 	"github.com/skamranahmed/estimatex-server/internal/event"
-	"golang.org/x/exp/rand"
+	"github.com/skamranahmed/estimatex-server/internal/invite"
+	"github.com/skamranahmed/estimatex-server/internal/metrics"
+	"github.com/skamranahmed/estimatex-server/internal/ratelimit"
+	"github.com/skamranahmed/estimatex-server/internal/snapshot"
+	"github.com/skamranahmed/estimatex-server/internal/tracker"
+	"github.com/skamranahmed/estimatex-server/internal/webhook"
 )
 
-func init() {
-	rand.Seed(uint64(time.Now().UnixNano()))
-}
+// maxRoomIDAttempts is the number of times a new room id is generated when it collides with an existing room
+const maxRoomIDAttempts = 16
+
+var (
+	ErrRoomIDSpaceExhausted = errors.New("unable to generate a unique room id")
+	ErrMaxRoomsReached      = errors.New("the maximum number of rooms has been reached")
+
+	errRoomAlreadyExists          = errors.New("a room with the same id already exists")
+	errRoomOwnedByAnotherInstance = errors.New("the room is owned by another instance of the server")
+)
 
 type Action string
 
@@ -29,37 +47,232 @@ func IsActionValid(input string) bool {
 	}
 }
 
-const (
-	roomIDLength = 6
-	letters      = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-)
-
-var DefaultManager = NewManager()
-
 // SessionManager: manages active rooms within a session, allowing thread-safe access and operations
 type SessionManager struct {
 	// rooms stores all active rooms in a concurrent-safe map, accessible by roomID
 	rooms sync.Map
+
+	// roomsCount is the number of rooms stored in the rooms map
+	roomsCount atomic.Int64
+
+	// snapshotter writes the snapshots of the rooms, it is nil when the snapshots are disabled
+	snapshotter *snapshotter
+
+	config ManagerConfig
 }
 
-func NewManager() *SessionManager {
-	sessionManager := &SessionManager{}
+// ManagerConfig: the dependencies of the SessionManager, the signer and the limiters are shared by all the rooms
+type ManagerConfig struct {
+	// RoomIDGenerator generates the ids of the new rooms
+	RoomIDGenerator RoomIDGenerator
+
+	// InviteSigner mints and verifies the invite tokens of the rooms
+	InviteSigner *invite.Signer
+
+	// MemberEventLimiter limits the incoming events per member
+	MemberEventLimiter *ratelimit.Limiter
+
+	// RoomBroadcastLimiter limits the broadcasts per room
+	RoomBroadcastLimiter *ratelimit.Limiter
+
+	// MaxRooms is the maximum number of rooms which can exist at the same time
+	MaxRooms int
+
+	// FieldLimits are the maximum lengths of the free text fields of the events received by the rooms
+	FieldLimits entity.FieldLimits
+
+	// Webhooks delivers the webhook events of the rooms, the webhooks are disabled when it is nil
+	Webhooks *webhook.Dispatcher
+
+	// JoinURLTemplate is the link to join a room from a client, `{room_id}` is replaced by the id of the room
+	JoinURLTemplate string
+
+	// Cluster coordinates the ownership of the rooms with the other instances of the server
+	Cluster *cluster.Node
+
+	// Tracker is the issue tracker of the tickets estimated in the rooms, the integration is disabled when it is nil
+	Tracker tracker.Tracker
+
+	// Snapshots keeps the state of the rooms across restarts, the snapshots are disabled when it is nil
+	Snapshots snapshot.Store
+
+	// SnapshotInterval is how often every room is written to its snapshot, on top of the writes made when a room changes
+	SnapshotInterval time.Duration
+
+	// ResumeWindow is how long the members of a restored room have to reconnect before their seats are given up
+	ResumeWindow time.Duration
+
+	// ReplayBufferSize is the number of the latest events of every room which are kept to be replayed to its members
+	ReplayBufferSize int
+}
+
+// RoomOptions: the settings of a new room, chosen by its admin
+type RoomOptions struct {
+	MaxCapacity int
+
+	// Password is optional, when it is not empty the room can only be joined with the password
+	// or with an invite token minted by the room admin
+	Password string
+
+	// WebhookSubscriptions receive the webhook events of this room only
+	WebhookSubscriptions []webhook.Subscription
+}
+
+func NewManager(config ManagerConfig) *SessionManager {
+	sessionManager := &SessionManager{
+		config: config,
+	}
+	if config.Snapshots != nil {
+		sessionManager.snapshotter = newSnapshotter(sessionManager, config.Snapshots, config.SnapshotInterval)
+	}
+
+	// the rooms of an instance which is gone are taken over by the instances which were relaying clients to them,
+	// and the rooms whose lease has been lost are dropped, so that a room is never served by two instances
+	config.Cluster.HandleOrphanedRooms(sessionManager.takeOverOrphanedRoom)
+	config.Cluster.HandleLostRooms(sessionManager.dropLostRoom)
+
 	return sessionManager
 }
 
-func (s *SessionManager) CreateRoom(maxCapacity int) *entity.Room {
+// CreateRoom: creates a new room with the given options, the room is owned by this instance of the server
+func (s *SessionManager) CreateRoom(ctx context.Context, options RoomOptions) (*entity.Room, error) {
+	// the slot is taken before creating the room, so that concurrent calls cannot go over the limit
+	if s.roomsCount.Add(1) > int64(s.config.MaxRooms) {
+		s.roomsCount.Add(-1)
+		return nil, ErrMaxRoomsReached
+	}
+
+	room, err := s.createRoom(ctx, options)
+	if err != nil {
+		s.roomsCount.Add(-1)
+		return nil, err
+	}
+
+	// the room is written right away, so that another instance can check the clients who join it through that instance
+	if s.snapshotter != nil {
+		s.snapshotter.write(room.ID)
+	}
+
+	metrics.ActiveRooms.Inc()
+	room.PublishWebhook(webhook.EventRoomCreated, webhook.RoomCreatedData{
+		MaxCapacity:         room.MaxCapacity,
+		IsPasswordProtected: room.IsPasswordProtected(),
+		JoinURL:             s.joinURL(room.ID),
+	})
+
+	return room, nil
+}
+
+func (s *SessionManager) createRoom(ctx context.Context, options RoomOptions) (*entity.Room, error) {
+	room := s.newRoom(options)
+
+	if options.Password != "" {
+		err := room.SetPassword(options.Password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := s.reserveRoomID(ctx, room)
+	if err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// newRoom: returns a room without an id, with the dependencies shared by all the rooms
+func (s *SessionManager) newRoom(options RoomOptions) *entity.Room {
 	room := &entity.Room{
-		ID:             s.generateRoomID(),
-		MaxCapacity:    maxCapacity,
-		EventHandlers:  make(map[event.EventType]entity.EventHanlder),
-		TicketVotesMap: make(map[string][]*entity.Vote),
-		MemberVoteMap:  make(map[string]*entity.Vote),
+		MaxCapacity:          options.MaxCapacity,
+		CreatedAt:            time.Now(),
+		Phase:                entity.RoomPhaseWaitingForMembers,
+		EventHandlers:        make(map[event.EventType]entity.EventHanlder),
+		TicketVotesMap:       make(map[string][]*entity.Vote),
+		MemberVoteMap:        make(map[string]*entity.Vote),
+		InviteSigner:         s.config.InviteSigner,
+		MemberEventLimiter:   s.config.MemberEventLimiter,
+		BroadcastLimiter:     s.config.RoomBroadcastLimiter,
+		FieldLimits:          s.config.FieldLimits,
+		Webhooks:             s.config.Webhooks,
+		WebhookSubscriptions: options.WebhookSubscriptions,
+		Tracker:              s.config.Tracker,
+		Events:               entity.NewEventLog(s.config.ReplayBufferSize),
 	}
 	room.SetupEventHandlers()
-	s.rooms.Store(room.ID, room)
+
+	// the id of the room is only read once the room has been stored, hence once it has been set
+	if s.snapshotter != nil {
+		room.OnChange = func() {
+			s.snapshotter.markChanged(room.ID)
+		}
+	}
+
 	return room
 }
 
+// joinURL: returns the link to join the room, it is empty when the server does not know its clients' URL
+func (s *SessionManager) joinURL(roomID string) string {
+	if s.config.JoinURLTemplate == "" {
+		return ""
+	}
+	return strings.ReplaceAll(s.config.JoinURLTemplate, "{room_id}", url.QueryEscape(roomID))
+}
+
+// RemoveRoom: removes the room from the session, it must be called once the room has been closed. It does nothing
+// when the room has already been removed, even when a room with the same id has been taken over by this instance since.
+func (s *SessionManager) RemoveRoom(room *entity.Room) {
+	if s.forgetRoom(room) {
+		s.config.Cluster.ReleaseRoom(room.ID)
+		s.snapshotter.markChanged(room.ID)
+
+		room.PublishWebhook(webhook.EventRoomClosed, webhook.RoomClosedData{CreatedAt: room.CreatedAt.UTC()})
+	}
+}
+
+// dropLostRoom: removes a room whose lease has been lost, the room may have been taken over by another instance,
+// hence its members are asked to reconnect and its snapshot is left to the owner of the lease
+func (s *SessionManager) dropLostRoom(roomID string) {
+	room := s.FindRoom(roomID)
+	if room == nil || !s.forgetRoom(room) {
+		return
+	}
+
+	room.Evict("the room has moved to another server, please reconnect")
+}
+
+// forgetRoom: removes the room from the session, it reports false when it has already been removed
+func (s *SessionManager) forgetRoom(room *entity.Room) bool {
+	if !s.rooms.CompareAndDelete(room.ID, room) {
+		return false
+	}
+
+	s.roomsCount.Add(-1)
+	metrics.ActiveRooms.Dec()
+	metrics.RoomVoteRounds.DeleteLabelValues(room.ID)
+	return true
+}
+
+// RoomsCount: returns the number of active rooms
+func (s *SessionManager) RoomsCount() int {
+	return int(s.roomsCount.Load())
+}
+
+// Rooms: returns all the active rooms
+func (s *SessionManager) Rooms() []*entity.Room {
+	var rooms []*entity.Room
+
+	s.rooms.Range(func(key interface{}, value interface{}) bool {
+		room, ok := value.(*entity.Room)
+		if ok {
+			rooms = append(rooms, room)
+		}
+		return true
+	})
+
+	return rooms
+}
+
 func (s *SessionManager) FindRoom(roomID string) *entity.Room {
 	room, ok := s.rooms.Load(roomID)
 	if !ok {
@@ -68,24 +281,32 @@ func (s *SessionManager) FindRoom(roomID string) *entity.Room {
 	return room.(*entity.Room)
 }
 
-func (s *SessionManager) generateRoomID() string {
-	for {
-		roomID := s.randomString(roomIDLength)
-		if !s.doesRoomAlreadyExist(string(roomID)) {
-			return roomID
+// reserveRoomID: assigns a unique id to the room and stores it, the room is stored atomically with its id
+// so two rooms created at the same time can never end up with the same id. The id must not be used by
+// a room of another instance either, hence the room is claimed in the cluster.
+func (s *SessionManager) reserveRoomID(ctx context.Context, room *entity.Room) error {
+	for attempt := 0; attempt < maxRoomIDAttempts; attempt++ {
+		roomID, err := s.config.RoomIDGenerator.Generate()
+		if err != nil {
+			return err
 		}
-	}
-}
 
-func (m *SessionManager) randomString(length int) string {
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+		room.ID = roomID
+		_, alreadyExists := s.rooms.LoadOrStore(roomID, room)
+		if alreadyExists {
+			continue
+		}
+
+		claimed, err := s.config.Cluster.ClaimRoom(ctx, roomID)
+		if err != nil {
+			s.rooms.Delete(roomID)
+			return err
+		}
+		if claimed {
+			return nil
+		}
+		s.rooms.Delete(roomID)
 	}
-	return string(b)
-}
 
-func (m *SessionManager) doesRoomAlreadyExist(roomID string) bool {
-	_, ok := m.rooms.Load(roomID)
-	return ok
+	return ErrRoomIDSpaceExhausted
 }
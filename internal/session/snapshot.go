@@ -0,0 +1,330 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/skamranahmed/estimatex-server/internal/cluster"
+	"github.com/skamranahmed/estimatex-server/internal/entity"
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+	"github.com/skamranahmed/estimatex-server/internal/metrics"
+	"github.com/skamranahmed/estimatex-server/internal/snapshot"
+)
+
+const (
+	// snapshotDelay batches the changes of a room made in quick succession, e.g. the votes of the members, into a single write
+	snapshotDelay = 250 * time.Millisecond
+
+	// takeOverTimeout is the time given to the backend to load and claim a room whose owner is gone
+	takeOverTimeout = 10 * time.Second
+)
+
+// snapshotter: writes the snapshot of a room shortly after it has changed, and the snapshots of every room
+// periodically. The snapshot of a room which does not exist anymore is deleted.
+type snapshotter struct {
+	sessionManager *SessionManager
+	store          snapshot.Store
+	interval       time.Duration
+
+	mutex sync.Mutex
+
+	// Key: RoomID, the rooms whose snapshot must be written again
+	changedRooms map[string]struct{}
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+
+	// writeMutex makes sure that the snapshots of a room are never written concurrently
+	writeMutex sync.Mutex
+}
+
+func newSnapshotter(sessionManager *SessionManager, store snapshot.Store, interval time.Duration) *snapshotter {
+	return &snapshotter{
+		sessionManager: sessionManager,
+		store:          store,
+		interval:       interval,
+		changedRooms:   make(map[string]struct{}),
+		wake:           make(chan struct{}, 1),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+}
+
+// markChanged: schedules a new snapshot of the room, it never blocks. It does nothing when the snapshots are disabled.
+func (s *snapshotter) markChanged(roomID string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	s.changedRooms[roomID] = struct{}{}
+	s.mutex.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *snapshotter) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	// delay is set while the changes made since the first one of the batch are waiting to be written
+	var delay <-chan time.Time
+
+	for {
+		select {
+		case <-s.stop:
+			return
+
+		case <-s.wake:
+			if delay == nil {
+				delay = time.After(snapshotDelay)
+			}
+
+		case <-delay:
+			delay = nil
+			s.writeChangedRooms()
+
+		case <-ticker.C:
+			for _, room := range s.sessionManager.Rooms() {
+				s.markChanged(room.ID)
+			}
+			s.writeChangedRooms()
+		}
+	}
+}
+
+// close: stops the periodic snapshots, and writes the snapshots of every room a last time
+func (s *snapshotter) close() {
+	if s == nil {
+		return
+	}
+
+	close(s.stop)
+	<-s.done
+
+	for _, room := range s.sessionManager.Rooms() {
+		s.markChanged(room.ID)
+	}
+	s.writeChangedRooms()
+}
+
+func (s *snapshotter) writeChangedRooms() {
+	s.mutex.Lock()
+	roomIDs := make([]string, 0, len(s.changedRooms))
+	for roomID := range s.changedRooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+	clear(s.changedRooms)
+	s.mutex.Unlock()
+
+	for _, roomID := range roomIDs {
+		s.write(roomID)
+	}
+}
+
+func (s *snapshotter) write(roomID string) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	startedAt := time.Now()
+
+	room := s.sessionManager.FindRoom(roomID)
+	if room == nil {
+		err := s.store.Delete(roomID)
+		if err != nil {
+			slog.Error("Unable to delete the snapshot of the room", logger.KeyRoomID, roomID, logger.KeyError, err)
+			metrics.SnapshotErrors.Inc()
+		}
+		return
+	}
+
+	err := s.store.Save(room.Snapshot())
+	metrics.SnapshotWriteDuration.Observe(time.Since(startedAt).Seconds())
+	if errors.Is(err, cluster.ErrRoomNotOwned) {
+		// the room has been closed or lost while it was being written, its state now belongs to its new owner
+		slog.Debug("Skipping the snapshot of a room which is not owned anymore", logger.KeyRoomID, roomID)
+		return
+	}
+	if err != nil {
+		slog.Error("Unable to write the snapshot of the room", logger.KeyRoomID, roomID, logger.KeyError, err)
+		metrics.SnapshotErrors.Inc()
+	}
+}
+
+// StartSnapshots: starts writing the snapshots of the rooms, it does nothing when the snapshots are disabled
+func (s *SessionManager) StartSnapshots() {
+	if s.snapshotter == nil {
+		return
+	}
+	go s.snapshotter.run()
+}
+
+// StopSnapshots: writes the snapshots of the rooms which are still open, so that they are restored by the next
+// process, and stops the snapshots
+func (s *SessionManager) StopSnapshots() {
+	s.snapshotter.close()
+}
+
+// SnapshotsEnabled: reports whether the rooms survive a restart, in which case the members are given a resume token
+func (s *SessionManager) SnapshotsEnabled() bool {
+	return s.snapshotter != nil
+}
+
+// RestoreRooms: brings back the rooms of the snapshots. The members of a restored room are detached until they
+// reconnect with their resume token, and the room is closed when its admin does not come back within the resume window.
+func (s *SessionManager) RestoreRooms(ctx context.Context) (int, error) {
+	if s.snapshotter == nil {
+		return 0, nil
+	}
+
+	roomSnapshots, err := s.config.Snapshots.LoadAll()
+	if err != nil {
+		return 0, err
+	}
+
+	restoredRooms := 0
+	for _, roomSnapshot := range roomSnapshots {
+		roomLogger := slog.With(logger.KeyRoomID, roomSnapshot.ID)
+
+		if roomSnapshot.Version != snapshot.Version {
+			roomLogger.Warn("Skipping a room snapshot written in another format", "version", roomSnapshot.Version)
+			continue
+		}
+
+		_, err := s.restoreRoom(ctx, roomSnapshot)
+		if errors.Is(err, errRoomOwnedByAnotherInstance) {
+			// the snapshots of a cluster hold the rooms of every instance
+			roomLogger.Debug("Skipping a room owned by another instance")
+			continue
+		}
+		if err != nil {
+			roomLogger.Warn("Unable to restore the room", logger.KeyError, err)
+			continue
+		}
+
+		roomLogger.Info("Room restored", "members", len(roomSnapshot.Members), "phase", roomSnapshot.Phase, "saved_at", roomSnapshot.SavedAt)
+		restoredRooms++
+	}
+
+	return restoredRooms, nil
+}
+
+// TakeOverRoom: brings back the room of an instance which is gone from its latest snapshot, this instance becomes
+// its owner. The room is nil when it has no snapshot, in which case it does not exist anymore.
+func (s *SessionManager) TakeOverRoom(ctx context.Context, roomID string) (*entity.Room, error) {
+	if s.snapshotter == nil {
+		return nil, nil
+	}
+
+	roomSnapshot, err := s.config.Snapshots.Load(roomID)
+	if err != nil || roomSnapshot == nil {
+		return nil, err
+	}
+
+	roomLogger := slog.With(logger.KeyRoomID, roomID)
+	if roomSnapshot.Version != snapshot.Version {
+		roomLogger.Warn("Skipping a room snapshot written in another format", "version", roomSnapshot.Version)
+		return nil, nil
+	}
+
+	room, err := s.restoreRoom(ctx, *roomSnapshot)
+	if errors.Is(err, errRoomAlreadyExists) {
+		// another client has taken the room over on this instance meanwhile
+		return s.FindRoom(roomID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	roomLogger.Info("Room taken over", "members", len(roomSnapshot.Members), "phase", roomSnapshot.Phase, "saved_at", roomSnapshot.SavedAt)
+	return room, nil
+}
+
+// RemoteRoom: returns a room owned by another instance as of its latest snapshot, so that the clients who join it
+// through this instance are checked here. The room is nil when it has no snapshot, and it is not part of the session.
+func (s *SessionManager) RemoteRoom(roomID string) (*entity.Room, error) {
+	if s.snapshotter == nil {
+		return nil, nil
+	}
+
+	roomSnapshot, err := s.config.Snapshots.Load(roomID)
+	if err != nil || roomSnapshot == nil || roomSnapshot.Version != snapshot.Version {
+		return nil, err
+	}
+
+	room := s.newRoom(RoomOptions{})
+	room.OnChange = nil
+	room.Restore(*roomSnapshot)
+	return room, nil
+}
+
+// takeOverOrphanedRoom: takes over a room whose owner is gone, its clients relayed by this instance reconnect to it
+func (s *SessionManager) takeOverOrphanedRoom(roomID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), takeOverTimeout)
+	defer cancel()
+
+	_, err := s.TakeOverRoom(ctx, roomID)
+	if err != nil && !errors.Is(err, errRoomOwnedByAnotherInstance) {
+		slog.Warn("Unable to take over the room", logger.KeyRoomID, roomID, logger.KeyError, err)
+	}
+}
+
+func (s *SessionManager) restoreRoom(ctx context.Context, roomSnapshot snapshot.Room) (*entity.Room, error) {
+	if s.roomsCount.Add(1) > int64(s.config.MaxRooms) {
+		s.roomsCount.Add(-1)
+		return nil, ErrMaxRoomsReached
+	}
+
+	room := s.newRoom(RoomOptions{})
+	room.Restore(roomSnapshot)
+
+	_, alreadyExists := s.rooms.LoadOrStore(room.ID, room)
+	if alreadyExists {
+		s.roomsCount.Add(-1)
+		return nil, errRoomAlreadyExists
+	}
+
+	// the room may have been created again on another instance, while this one was down
+	claimed, err := s.config.Cluster.ClaimRoom(ctx, room.ID)
+	if err == nil && !claimed {
+		err = errRoomOwnedByAnotherInstance
+	}
+	if err != nil {
+		s.rooms.Delete(room.ID)
+		s.roomsCount.Add(-1)
+		return nil, err
+	}
+
+	metrics.ActiveRooms.Inc()
+
+	time.AfterFunc(s.config.ResumeWindow, func() {
+		s.closeResumeWindow(room)
+	})
+
+	return room, nil
+}
+
+// closeResumeWindow: drops the members of a restored room who have not reconnected, the room is closed
+// when its admin is one of them, like a room whose admin disconnects
+func (s *SessionManager) closeResumeWindow(room *entity.Room) {
+	droppedMembers := room.DropDetachedMembers()
+
+	for _, droppedMember := range droppedMembers {
+		slog.Info("Member did not resume its seat in the room", logger.KeyRoomID, room.ID, logger.KeyMemberID, droppedMember.ID, logger.KeyMemberName, droppedMember.Name)
+
+		if droppedMember.IsRoomAdmin {
+			room.Close(context.Background(), "⌛ The admin did not come back after the server restarted, the room has been closed.")
+			s.RemoveRoom(room)
+			return
+		}
+	}
+}
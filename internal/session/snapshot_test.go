@@ -0,0 +1,158 @@
+package session
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/skamranahmed/estimatex-server/internal/cluster"
+	"github.com/skamranahmed/estimatex-server/internal/entity"
+	"github.com/skamranahmed/estimatex-server/internal/snapshot"
+	"github.com/skamranahmed/estimatex-server/internal/transport"
+)
+
+// newTestManager: a session manager of a single instance, whose snapshots are written to the store
+func newTestManager(t *testing.T, store snapshot.Store) *SessionManager {
+	t.Helper()
+
+	roomIDGenerator, err := NewRoomIDGenerator(RoomIDStyleAlphanumeric, 6, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return NewManager(ManagerConfig{
+		RoomIDGenerator:  roomIDGenerator,
+		MaxRooms:         10,
+		FieldLimits:      entity.FieldLimits{TicketID: 128, Vote: 16},
+		Cluster:          cluster.NewNode(cluster.Config{NodeID: "node", LeaseTTL: time.Minute}, cluster.NewMemoryBackend()),
+		Snapshots:        store,
+		SnapshotInterval: time.Hour,
+		ResumeWindow:     time.Minute,
+		ReplayBufferSize: 64,
+	})
+}
+
+func TestRoomsAreRestoredFromTheirSnapshots(t *testing.T) {
+	store, err := snapshot.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sessionManager := newTestManager(t, store)
+	room, err := sessionManager.CreateRoom(context.Background(), RoomOptions{MaxCapacity: 3, Password: "secret"})
+	if err != nil {
+		t.Fatalf("unable to create the room: %v", err)
+	}
+
+	admin := entity.NewMember("alice", transport.NewMemoryConnection("10.0.0.1"), room.ID, true, slog.Default())
+	err = room.AddMember(admin)
+	if err != nil {
+		t.Fatalf("unable to add the member: %v", err)
+	}
+	room.TicketVotesMap["ABC-1"] = []*entity.Vote{{Value: "5", MemberID: admin.ID, MemberName: admin.Name}}
+	sessionManager.snapshotter.write(room.ID)
+
+	// a snapshot written in another format is skipped
+	err = store.Save(snapshot.Room{Version: snapshot.Version + 1, ID: "OLDFMT", MaxCapacity: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the next process has its own cluster node, as after a restart
+	restartedManager := newTestManager(t, store)
+	restoredRooms, err := restartedManager.RestoreRooms(context.Background())
+	if err != nil {
+		t.Fatalf("unable to restore the rooms: %v", err)
+	}
+	if restoredRooms != 1 {
+		t.Fatalf("got %d restored rooms, want 1", restoredRooms)
+	}
+	if restartedManager.FindRoom("OLDFMT") != nil {
+		t.Fatal("the room written in another format has been restored")
+	}
+
+	restoredRoom := restartedManager.FindRoom(room.ID)
+	if restoredRoom == nil {
+		t.Fatal("the room has not been restored")
+	}
+	if restoredRoom.MaxCapacity != 3 {
+		t.Fatalf("got a capacity of %d, want 3", restoredRoom.MaxCapacity)
+	}
+	if err := restoredRoom.Authorize("secret", ""); err != nil {
+		t.Fatalf("the password of the room has not been restored: %v", err)
+	}
+	if err := restoredRoom.Authorize("wrong", ""); err == nil {
+		t.Fatal("the room accepts a wrong password")
+	}
+
+	// the member is detached until it resumes its seat with its resume token
+	resumedMember, err := restoredRoom.ResumeMember(admin.ID, admin.ResumeToken)
+	if err != nil {
+		t.Fatalf("unable to resume the seat of the member: %v", err)
+	}
+	if resumedMember.Name != "alice" || !resumedMember.IsRoomAdmin {
+		t.Fatalf("got %+v, want the admin alice", resumedMember)
+	}
+
+	votes := restoredRoom.TicketVotesMap["ABC-1"]
+	if len(votes) != 1 || votes[0].Value != "5" || votes[0].MemberID != admin.ID {
+		t.Fatalf("got %v, want the vote of the admin", votes)
+	}
+}
+
+func TestStoppingTheSnapshotsWritesTheChangesWhichAreStillDelayed(t *testing.T) {
+	store, err := snapshot.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sessionManager := newTestManager(t, store)
+	sessionManager.StartSnapshots()
+
+	room, err := sessionManager.CreateRoom(context.Background(), RoomOptions{MaxCapacity: 3})
+	if err != nil {
+		t.Fatalf("unable to create the room: %v", err)
+	}
+	err = room.AddMember(entity.NewMember("alice", transport.NewMemoryConnection("10.0.0.1"), room.ID, true, slog.Default()))
+	if err != nil {
+		t.Fatalf("unable to add the member: %v", err)
+	}
+	sessionManager.snapshotter.markChanged(room.ID)
+
+	// the snapshotter has taken the change, and waits for the end of the delay to write it
+	for len(sessionManager.snapshotter.wake) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	roomSnapshot, err := store.Load(room.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the snapshot written on the creation of the room does not have the member yet
+	if roomSnapshot == nil || len(roomSnapshot.Members) != 0 {
+		t.Fatalf("got %+v, want the snapshot of the room without its member until the end of the delay", roomSnapshot)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		sessionManager.StopSnapshots()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(snapshotDelay / 2):
+		t.Fatal("stopping the snapshots waits for the end of the delay")
+	}
+
+	roomSnapshot, err = store.Load(room.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if roomSnapshot == nil {
+		t.Fatal("the delayed snapshot has not been written")
+	}
+	if len(roomSnapshot.Members) != 1 || roomSnapshot.Members[0].Name != "alice" {
+		t.Fatalf("got the members %+v, want alice", roomSnapshot.Members)
+	}
+}
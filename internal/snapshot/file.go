@@ -0,0 +1,132 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+)
+
+const snapshotFileExtension = ".json"
+
+// FileStore: keeps a JSON file per room in a directory. The files hold the password hashes and the secrets
+// of the room webhooks, hence they are only readable by the user running the server.
+type FileStore struct {
+	directory string
+}
+
+func NewFileStore(directory string) (*FileStore, error) {
+	err := os.MkdirAll(directory, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the snapshot directory: %w", err)
+	}
+
+	return &FileStore{directory: directory}, nil
+}
+
+// Save: the snapshot is written to a temporary file which then replaces the previous one,
+// so that a crash while writing never leaves a truncated snapshot behind
+func (s *FileStore) Save(room Room) error {
+	payload, err := json.Marshal(room)
+	if err != nil {
+		return err
+	}
+
+	temporaryFile, err := os.CreateTemp(s.directory, ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporaryFile.Name())
+
+	_, err = temporaryFile.Write(payload)
+	if err == nil {
+		err = temporaryFile.Sync()
+	}
+	closeErr := temporaryFile.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	return os.Rename(temporaryFile.Name(), s.path(room.ID))
+}
+
+func (s *FileStore) Delete(roomID string) error {
+	err := os.Remove(s.path(roomID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *FileStore) Load(roomID string) (*Room, error) {
+	payload, err := os.ReadFile(s.path(roomID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var room Room
+	err = json.Unmarshal(payload, &room)
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+func (s *FileStore) LoadAll() ([]Room, error) {
+	entries, err := os.ReadDir(s.directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var rooms []Room
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), snapshotFileExtension) {
+			continue
+		}
+
+		path := filepath.Join(s.directory, entry.Name())
+		payload, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn("Unable to read a room snapshot", "path", path, logger.KeyError, err)
+			continue
+		}
+
+		var room Room
+		err = json.Unmarshal(payload, &room)
+		if err != nil {
+			slog.Warn("Unable to unmarshal a room snapshot", "path", path, logger.KeyError, err)
+			continue
+		}
+
+		rooms = append(rooms, room)
+	}
+
+	return rooms, nil
+}
+
+func (s *FileStore) Ping(ctx context.Context) error {
+	probeFile, err := os.CreateTemp(s.directory, ".probe-*")
+	if err != nil {
+		return err
+	}
+	probeFile.Close()
+	return os.Remove(probeFile.Name())
+}
+
+// path: the room id is escaped, since the alphabet of the room ids is configurable
+func (s *FileStore) path(roomID string) string {
+	return filepath.Join(s.directory, url.PathEscape(roomID)+snapshotFileExtension)
+}
@@ -0,0 +1,104 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/skamranahmed/estimatex-server/internal/webhook"
+)
+
+// Version is the format of the snapshots, the snapshots written in another format are not restored.
+// The version 2 keeps the events once along with their recipients, instead of once per recipient.
+const Version = 2
+
+// Room: the state of a room which is needed to bring it back after a restart. The connections are not part of it,
+// the members are restored without them and reconnect with their resume token.
+type Room struct {
+	Version int       `json:"version"`
+	SavedAt time.Time `json:"saved_at"`
+
+	ID          string    `json:"id"`
+	MaxCapacity int       `json:"max_capacity"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	Phase           string `json:"phase"`
+	CurrentTicketID string `json:"current_ticket_id,omitempty"`
+
+	Members []Member `json:"members"`
+
+	// Key: TicketID, Value: the votes cast for the ticket which have not been revealed yet
+	TicketVotes map[string][]Vote `json:"ticket_votes,omitempty"`
+
+	// Key: MemberID, Value: the votes of the last ticket whose voting has completed
+	MemberVotes map[string]Vote `json:"member_votes,omitempty"`
+
+	// Results are the latest revealed tickets, from the oldest to the latest
+	Results []Result `json:"results,omitempty"`
+
+	PasswordHash         []byte                 `json:"password_hash,omitempty"`
+	RevokedInvites       []string               `json:"revoked_invites,omitempty"`
+	BannedIPs            []string               `json:"banned_ips,omitempty"`
+	WebhookSubscriptions []webhook.Subscription `json:"webhook_subscriptions,omitempty"`
+
+	// Events are the latest events sent to the members, so that they are replayed to the members who resume their seat.
+	// LastEventSeq and DroppedEventSeq are the sequence numbers of the latest event and of the latest dropped event.
+	Events          []Event `json:"events,omitempty"`
+	LastEventSeq    uint64  `json:"last_event_seq,omitempty"`
+	DroppedEventSeq uint64  `json:"dropped_event_seq,omitempty"`
+}
+
+// Member: a member of the room, only the hash of its resume token is kept
+type Member struct {
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	IsRoomAdmin     bool      `json:"is_room_admin"`
+	RemoteIP        string    `json:"remote_ip"`
+	Muted           bool      `json:"muted,omitempty"`
+	JoinedAt        time.Time `json:"joined_at"`
+	ResumeTokenHash []byte    `json:"resume_token_hash"`
+}
+
+// Event: an event sent to members of the room, it is kept once whatever the number of its recipients
+type Event struct {
+	Seq     uint64          `json:"seq"`
+	Type    string          `json:"type"`
+	Data    json.RawMessage `json:"data"`
+	SentAt  int64           `json:"sent_at"`
+	TraceID string          `json:"trace_id,omitempty"`
+
+	// Key: MemberID, Value: the number of the event sent to the member before this one
+	Recipients map[string]uint64 `json:"recipients"`
+}
+
+// Result: the revealed votes of a ticket, along with its final estimate once the admin has set it
+type Result struct {
+	TicketID      string    `json:"ticket_id"`
+	Votes         []Vote    `json:"votes"`
+	FinalEstimate string    `json:"final_estimate,omitempty"`
+	RevealedAt    time.Time `json:"revealed_at"`
+}
+
+type Vote struct {
+	Value      string `json:"value"`
+	MemberID   string `json:"member_id"`
+	MemberName string `json:"member_name"`
+}
+
+// Store: keeps the latest snapshot of every room
+type Store interface {
+	// Save: replaces the snapshot of the room
+	Save(room Room) error
+
+	// Delete: removes the snapshot of a room which has been closed, it does nothing when there is no snapshot
+	Delete(roomID string) error
+
+	// Load: returns the snapshot of the room, it is nil when there is no snapshot
+	Load(roomID string) (*Room, error)
+
+	// LoadAll: returns the snapshots of all the rooms, the snapshots which cannot be read are skipped
+	LoadAll() ([]Room, error)
+
+	// Ping: reports whether the snapshots can be written, it is used by the readiness endpoint
+	Ping(ctx context.Context) error
+}
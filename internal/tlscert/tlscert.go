@@ -0,0 +1,121 @@
+package tlscert
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+)
+
+// Reloader: serves a TLS certificate loaded from a certificate and key file pair, and reloads it when
+// either of the files changes on disk, so that a renewed certificate is picked up without a restart
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// NewReloader: loads the certificate from the files, it returns an error if the certificate cannot be loaded
+func NewReloader(certFile string, keyFile string) (*Reloader, error) {
+	reloader := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	err := reloader.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// GetCertificate: matches the signature of tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.certificate, nil
+}
+
+// Watch: checks the files for changes every interval and reloads the certificate when they have changed.
+// It is a blocking operation, hence it must be run as a go routine. It returns when the stop channel is closed.
+func (r *Reloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !r.filesChanged() {
+				continue
+			}
+
+			err := r.reload()
+			if err != nil {
+				// keep serving the previous certificate, the files might be in the middle of being replaced
+				slog.Error("Unable to reload the TLS certificate", logger.KeyError, err)
+				continue
+			}
+			slog.Info("Reloaded the TLS certificate", "cert_file", r.certFile)
+
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (r *Reloader) filesChanged() bool {
+	certModTime, keyModTime, err := r.modTimes()
+	if err != nil {
+		slog.Error("Unable to check the TLS certificate files for changes", logger.KeyError, err)
+		return false
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return !certModTime.Equal(r.certModTime) || !keyModTime.Equal(r.keyModTime)
+}
+
+func (r *Reloader) reload() error {
+	certModTime, keyModTime, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load the TLS certificate: %w", err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.certificate = &certificate
+	r.certModTime = certModTime
+	r.keyModTime = keyModTime
+	return nil
+}
+
+func (r *Reloader) modTimes() (certModTime time.Time, keyModTime time.Time, err error) {
+	certFileInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	keyFileInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	return certFileInfo.ModTime(), keyFileInfo.ModTime(), nil
+}
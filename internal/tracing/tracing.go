@@ -0,0 +1,112 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+
+	serviceName = "estimatex-server"
+)
+
+// The keys of the span attributes, they mirror the keys of the log fields
+const (
+	AttributeRoomID         = attribute.Key("estimatex.room_id")
+	AttributeMemberID       = attribute.Key("estimatex.member_id")
+	AttributeEventType      = attribute.Key("estimatex.event_type")
+	AttributeRecipientCount = attribute.Key("estimatex.recipient_count")
+	AttributeRemoteAddr     = attribute.Key("estimatex.remote_addr")
+)
+
+// tracer is a no-op until Setup installs a tracer provider, so the spans cost close to nothing when tracing is disabled
+var tracer = otel.Tracer("github.com/skamranahmed/estimatex-server")
+
+// Setup: installs the global tracer provider for the given exporter. The OTLP exporter sends the spans over
+// HTTP and is configured with the standard OTEL_EXPORTER_OTLP_* environment variables (it defaults to a
+// collector on localhost:4318). The returned function flushes the pending spans and must be called on shutdown.
+func Setup(ctx context.Context, exporterName string) (shutdown func(context.Context) error, err error) {
+	var exporter sdktrace.SpanExporter
+
+	switch exporterName {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+
+	case ExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+
+	default:
+		return nil, fmt.Errorf("unsupported tracing exporter: %q, expected one of none, stdout or otlp", exporterName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the %s tracing exporter: %w", exporterName, err)
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return tracerProvider.Shutdown, nil
+}
+
+// Start: starts a span, the span must be ended by the caller
+func Start(ctx context.Context, spanName string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, spanName, trace.WithAttributes(attributes...))
+}
+
+// StartBroadcast: starts the span of a fan-out of an event to the members of a room
+func StartBroadcast(ctx context.Context, roomID string, eventType string, recipientCount int) (context.Context, trace.Span) {
+	return Start(ctx, "broadcast "+eventType,
+		AttributeRoomID.String(roomID),
+		AttributeEventType.String(eventType),
+		AttributeRecipientCount.Int(recipientCount),
+	)
+}
+
+// ErrConnectionRefused is recorded on the websocket upgrade span when the client could not join or create a room
+var ErrConnectionRefused = errors.New("connection refused")
+
+// RecordError: marks the span as failed
+func RecordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// TraceID: returns the id of the trace the context belongs to, or an empty string when there is none
+func TraceID(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
+
+// ExtractFromHeader: returns a context carrying the trace propagated by the client in the headers
+// of the request (e.g. the `traceparent` header), if any
+func ExtractFromHeader(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
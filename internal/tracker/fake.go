@@ -0,0 +1,77 @@
+package tracker
+
+import (
+	"context"
+	"sync"
+)
+
+// Fake: an in-memory tracker, for the tests and for trying the integration without a real tracker
+type Fake struct {
+	// generateMissingTickets makes every ticket id valid, with a generated title
+	generateMissingTickets bool
+
+	mutex sync.Mutex
+
+	// Key: TicketID, Value: Ticket
+	tickets map[string]Ticket
+
+	// Key: TicketID, Value: estimate
+	estimates map[string]string
+}
+
+func NewFake(generateMissingTickets bool) *Fake {
+	return &Fake{
+		generateMissingTickets: generateMissingTickets,
+		tickets:                make(map[string]Ticket),
+		estimates:              make(map[string]string),
+	}
+}
+
+// AddTicket: adds or replaces a ticket
+func (f *Fake) AddTicket(ticket Ticket) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.tickets[ticket.ID] = ticket
+}
+
+func (f *Fake) FetchTicket(ctx context.Context, ticketID string) (Ticket, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	ticket, ok := f.tickets[ticketID]
+	if ok {
+		return ticket, nil
+	}
+
+	if !f.generateMissingTickets {
+		return Ticket{}, ErrTicketNotFound
+	}
+
+	return Ticket{
+		ID:          ticketID,
+		Title:       "Fake ticket " + ticketID,
+		Description: "This ticket has been generated by the fake tracker.",
+	}, nil
+}
+
+func (f *Fake) SetEstimate(ctx context.Context, ticketID string, estimate string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, ok := f.tickets[ticketID]; !ok && !f.generateMissingTickets {
+		return ErrTicketNotFound
+	}
+
+	f.estimates[ticketID] = estimate
+	return nil
+}
+
+// Estimate: returns the estimate written for the ticket, if any
+func (f *Fake) Estimate(ticketID string) (string, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	estimate, ok := f.estimates[ticketID]
+	return estimate, ok
+}
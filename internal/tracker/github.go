@@ -0,0 +1,148 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// GitHubConfig: the settings of the GitHub Issues tracker
+type GitHubConfig struct {
+	// APIURL is the URL of the GitHub REST API, it is only different from `https://api.github.com` on GitHub Enterprise Server
+	APIURL string
+
+	// Repository is the repository of the issues, like `owner/repo`
+	Repository string
+
+	Token string
+
+	// EstimateLabelPrefix is the prefix of the label which holds the estimate, like `estimate: ` for the `estimate: 5` label
+	EstimateLabelPrefix string
+}
+
+// GitHub: fetches the issues from the GitHub REST API. GitHub issues do not have a story points field,
+// hence the estimate is written as a label, which replaces the previous estimate label of the issue.
+type GitHub struct {
+	config     GitHubConfig
+	httpClient *http.Client
+}
+
+func NewGitHub(config GitHubConfig) (*GitHub, error) {
+	owner, repo, found := strings.Cut(config.Repository, "/")
+	if !found || owner == "" || repo == "" || config.Token == "" || config.EstimateLabelPrefix == "" {
+		return nil, errors.New("the github tracker requires a repository like owner/repo, a token and an estimate label prefix")
+	}
+	config.APIURL = strings.TrimSuffix(config.APIURL, "/")
+
+	return &GitHub{
+		config:     config,
+		httpClient: &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+// FetchTicket: the ticket id is the number of the issue, with or without a leading `#`
+func (g *GitHub) FetchTicket(ctx context.Context, ticketID string) (Ticket, error) {
+	issueNumber, err := parseIssueNumber(ticketID)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	request, err := g.newRequest(ctx, http.MethodGet, "/issues/"+issueNumber, nil)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	var issue struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		Body    string `json:"body"`
+		HTMLURL string `json:"html_url"`
+	}
+	err = doJSON(g.httpClient, request, &issue)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	return Ticket{
+		ID:          "#" + strconv.Itoa(issue.Number),
+		Title:       issue.Title,
+		Description: issue.Body,
+		URL:         issue.HTMLURL,
+	}, nil
+}
+
+func (g *GitHub) SetEstimate(ctx context.Context, ticketID string, estimate string) error {
+	issueNumber, err := parseIssueNumber(ticketID)
+	if err != nil {
+		return err
+	}
+
+	request, err := g.newRequest(ctx, http.MethodGet, "/issues/"+issueNumber+"/labels?per_page=100", nil)
+	if err != nil {
+		return err
+	}
+
+	var labels []struct {
+		Name string `json:"name"`
+	}
+	err = doJSON(g.httpClient, request, &labels)
+	if err != nil {
+		return err
+	}
+
+	// the previous estimates are removed, so that the issue only carries the final one
+	for _, label := range labels {
+		if !strings.HasPrefix(label.Name, g.config.EstimateLabelPrefix) {
+			continue
+		}
+
+		request, err := g.newRequest(ctx, http.MethodDelete, "/issues/"+issueNumber+"/labels/"+url.PathEscape(label.Name), nil)
+		if err != nil {
+			return err
+		}
+
+		err = doJSON(g.httpClient, request, nil)
+		if err != nil && !errors.Is(err, ErrTicketNotFound) {
+			return err
+		}
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"labels": []string{g.config.EstimateLabelPrefix + estimate},
+	})
+
+	request, err = g.newRequest(ctx, http.MethodPost, "/issues/"+issueNumber+"/labels", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	return doJSON(g.httpClient, request, nil)
+}
+
+func (g *GitHub) newRequest(ctx context.Context, method string, path string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, method, g.config.APIURL+"/repos/"+g.config.Repository+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Authorization", "Bearer "+g.config.Token)
+	request.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	return request, nil
+}
+
+func parseIssueNumber(ticketID string) (string, error) {
+	issueNumber := strings.TrimPrefix(ticketID, "#")
+
+	number, err := strconv.Atoi(issueNumber)
+	if err != nil || number <= 0 {
+		return "", ErrInvalidTicketID
+	}
+	return strconv.Itoa(number), nil
+}
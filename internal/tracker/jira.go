@@ -0,0 +1,120 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// jiraIssueKeyPattern matches the keys of the Jira issues, like `ABC-123`
+var jiraIssueKeyPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*-[0-9]+$`)
+
+// JiraConfig: the settings of the Jira tracker
+type JiraConfig struct {
+	// BaseURL is the URL of the Jira site, like `https://example.atlassian.net`
+	BaseURL string
+
+	// Email and APIToken are used for basic authentication on Jira Cloud. When Email is empty,
+	// APIToken is sent as a bearer token, which is how the personal access tokens of Jira Data Center work.
+	Email    string
+	APIToken string
+
+	// StoryPointsField is the id of the custom field which holds the story points, like `customfield_10016`
+	StoryPointsField string
+}
+
+// Jira: fetches the issues from the Jira REST API, and writes the estimates to the story points field
+type Jira struct {
+	config     JiraConfig
+	httpClient *http.Client
+}
+
+func NewJira(config JiraConfig) (*Jira, error) {
+	if config.BaseURL == "" || config.APIToken == "" || config.StoryPointsField == "" {
+		return nil, errors.New("the jira tracker requires a base URL, an API token and a story points field")
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	return &Jira{
+		config:     config,
+		httpClient: &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+func (j *Jira) FetchTicket(ctx context.Context, ticketID string) (Ticket, error) {
+	if !jiraIssueKeyPattern.MatchString(ticketID) {
+		return Ticket{}, ErrInvalidTicketID
+	}
+
+	request, err := j.newRequest(ctx, http.MethodGet, ticketID, "?fields=summary,description", nil)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	var issue struct {
+		Key    string `json:"key"`
+		Fields struct {
+			Summary     string `json:"summary"`
+			Description string `json:"description"`
+		} `json:"fields"`
+	}
+	err = doJSON(j.httpClient, request, &issue)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	return Ticket{
+		ID:          issue.Key,
+		Title:       issue.Fields.Summary,
+		Description: issue.Fields.Description,
+		URL:         j.config.BaseURL + "/browse/" + url.PathEscape(issue.Key),
+	}, nil
+}
+
+// SetEstimate: the story points field is a number, hence the estimates like `XL` or `?` cannot be written
+func (j *Jira) SetEstimate(ctx context.Context, ticketID string, estimate string) error {
+	if !jiraIssueKeyPattern.MatchString(ticketID) {
+		return ErrInvalidTicketID
+	}
+
+	storyPoints, err := strconv.ParseFloat(estimate, 64)
+	if err != nil {
+		return fmt.Errorf("%w: the story points must be a number", ErrEstimateNotSupported)
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"fields": map[string]any{j.config.StoryPointsField: storyPoints},
+	})
+
+	request, err := j.newRequest(ctx, http.MethodPut, ticketID, "", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	return doJSON(j.httpClient, request, nil)
+}
+
+func (j *Jira) newRequest(ctx context.Context, method string, issueKey string, query string, body io.Reader) (*http.Request, error) {
+	issueURL := j.config.BaseURL + "/rest/api/2/issue/" + url.PathEscape(issueKey) + query
+
+	request, err := http.NewRequestWithContext(ctx, method, issueURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if j.config.Email != "" {
+		request.SetBasicAuth(j.config.Email, j.config.APIToken)
+	} else {
+		request.Header.Set("Authorization", "Bearer "+j.config.APIToken)
+	}
+
+	return request, nil
+}
@@ -0,0 +1,91 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrTicketNotFound       = errors.New("ticket not found in the tracker")
+	ErrInvalidTicketID      = errors.New("ticket id is not valid for the tracker")
+	ErrEstimateNotSupported = errors.New("estimate is not supported by the tracker")
+)
+
+// requestTimeout is the time given to a single request made to a tracker
+const requestTimeout = 10 * time.Second
+
+// Ticket: the details of a ticket fetched from the tracker
+type Ticket struct {
+	ID          string
+	Title       string
+	Description string
+	URL         string
+}
+
+// Tracker: an issue tracker in which the tickets estimated in the rooms live
+type Tracker interface {
+	// FetchTicket: returns the details of the ticket, or ErrTicketNotFound
+	FetchTicket(ctx context.Context, ticketID string) (Ticket, error)
+
+	// SetEstimate: writes the final estimate of the ticket back to the tracker
+	SetEstimate(ctx context.Context, ticketID string, estimate string) error
+}
+
+// Config: selects and configures the tracker, the settings of the trackers which are not selected are ignored
+type Config struct {
+	// Name is one of none, jira, github or fake
+	Name string
+
+	Jira   JiraConfig
+	GitHub GitHubConfig
+}
+
+// New: returns the tracker selected by the config, it returns nil when the tracker integration is disabled
+func New(config Config) (Tracker, error) {
+	switch config.Name {
+	case "", "none":
+		return nil, nil
+	case "jira":
+		return NewJira(config.Jira)
+	case "github":
+		return NewGitHub(config.GitHub)
+	case "fake":
+		return NewFake(true), nil
+	default:
+		return nil, fmt.Errorf("unsupported tracker: %q, expected one of none, jira, github or fake", config.Name)
+	}
+}
+
+// doJSON: sends the request and decodes the JSON response into the target, when the target is not nil
+func doJSON(httpClient *http.Client, request *http.Request, target any) error {
+	request.Header.Set("Accept", "application/json")
+	if request.Body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return ErrTicketNotFound
+	}
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		// the beginning of the body usually explains the error
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("unexpected response status from the tracker: %s: %s", response.Status, body)
+	}
+
+	if target == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(target)
+}
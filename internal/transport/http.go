@@ -0,0 +1,334 @@
+package transport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/skamranahmed/estimatex-server/internal/codec"
+)
+
+const (
+	// outboxSize is the number of messages kept for a client until it reads them, an HTTP client is disconnected once
+	// its outbox is full, so that a client which does not keep up never holds up the room
+	outboxSize = 256
+
+	// inboxSize is the number of events posted by a client which are waiting to be handled by the room
+	inboxSize = 16
+
+	// heartbeatInterval is the time after which a comment is written to an idle stream, so that the proxies do not close it
+	heartbeatInterval = 15 * time.Second
+
+	// closedRetention is the time a closed connection is kept, so that a polling client can read the last messages
+	closedRetention = time.Minute
+)
+
+var (
+	// ErrReaderBusy is returned when the messages of a connection are already being read by another request
+	ErrReaderBusy = errors.New("the messages of the connection are already being read")
+
+	// ErrSlowConsumer is returned when the client has too many messages left to read, the connection is closed
+	ErrSlowConsumer = errors.New("the client does not read its messages fast enough")
+)
+
+// HTTPConnection: a connection made of HTTP requests, for the clients whose proxies do not let websocket upgrades through.
+// The client posts its events, and reads the messages either from a Server-Sent Events stream or by polling for them.
+// The connection is closed when nobody reads its messages for a while.
+type HTTPConnection struct {
+	// ID identifies the connection in the requests of the client, it is only known by the client
+	ID string
+
+	remoteAddr string
+
+	inbox  chan []byte
+	outbox chan []byte
+
+	// reading is held by the request reading the messages, a stream or a poll, so that the messages are read in order
+	reading     sync.Mutex
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+
+	closed      chan struct{}
+	closeOnce   sync.Once
+	closeCode   int
+	closeReason string
+	onClose     func()
+}
+
+// ReadMessage: returns the next event posted by the client
+func (c *HTTPConnection) ReadMessage() ([]byte, error) {
+	select {
+	case payload := <-c.inbox:
+		return payload, nil
+	case <-c.closed:
+		return nil, ErrClosed
+	}
+}
+
+// WriteMessage: queues the message until the client reads it. It never waits for the client: the connection is
+// closed when the outbox is full, the client still reads the messages queued before the close.
+func (c *HTTPConnection) WriteMessage(message []byte) error {
+	select {
+	case <-c.closed:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case c.outbox <- message:
+		return nil
+	default:
+		c.Close(websocket.ClosePolicyViolation, "too many messages left to read")
+		return ErrSlowConsumer
+	}
+}
+
+// Close: the client reads the close code and the reason after the messages which have been queued before
+func (c *HTTPConnection) Close(closeCode int, reason string) error {
+	c.closeOnce.Do(func() {
+		c.closeCode = closeCode
+		c.closeReason = reason
+		close(c.closed)
+		c.idleTimer.Stop()
+		c.onClose()
+	})
+	return nil
+}
+
+func (c *HTTPConnection) RemoteAddr() string {
+	return c.remoteAddr
+}
+
+// SetCodec: the messages are carried as text, hence JSON is the only encoding of the HTTP connections
+func (c *HTTPConnection) SetCodec(eventCodec codec.Codec) error {
+	if eventCodec != codec.JSON {
+		return fmt.Errorf("the %s encoding is only available over websocket", eventCodec.Name())
+	}
+	return nil
+}
+
+// Deliver: hands an event posted by the client to the room, it waits while the room is busy with the previous events
+func (c *HTTPConnection) Deliver(ctx context.Context, payload []byte) error {
+	select {
+	case <-c.closed:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case c.inbox <- payload:
+		return nil
+	case <-c.closed:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ServeStream: writes the messages to a Server-Sent Events stream until the connection is closed or the client goes away.
+// The first event of the stream carries the id of the connection, the last one its close code and reason.
+func (c *HTTPConnection) ServeStream(w http.ResponseWriter, r *http.Request) error {
+	if !c.startReading() {
+		return ErrReaderBusy
+	}
+	defer c.stopReading()
+
+	responseController := http.NewResponseController(w)
+
+	// the stream lasts as long as the connection, whatever the timeouts of the server
+	responseController.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	connectionInfo, _ := json.Marshal(map[string]string{"connection_id": c.ID})
+	writeStreamEvent(w, "connection", connectionInfo)
+	err := responseController.Flush()
+	if err != nil {
+		return err
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case message := <-c.outbox:
+			writeStreamEvent(w, "", message)
+			for _, message := range c.takeMessages() {
+				writeStreamEvent(w, "", message)
+			}
+
+		case <-c.closed:
+			for _, message := range c.takeMessages() {
+				writeStreamEvent(w, "", message)
+			}
+			closeInfo, _ := json.Marshal(map[string]any{"code": c.closeCode, "reason": c.closeReason})
+			writeStreamEvent(w, "close", closeInfo)
+			return responseController.Flush()
+
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+
+		case <-r.Context().Done():
+			return r.Context().Err()
+		}
+
+		err := responseController.Flush()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// PollResult: the messages read by a poll request, Closed is set once the connection has been closed and its last messages read
+type PollResult struct {
+	Messages    []string `json:"messages"`
+	Closed      bool     `json:"closed"`
+	CloseCode   int      `json:"close_code,omitempty"`
+	CloseReason string   `json:"close_reason,omitempty"`
+}
+
+// Poll: waits for messages until the timeout, and returns as soon as there is at least one of them
+func (c *HTTPConnection) Poll(ctx context.Context, timeout time.Duration) (PollResult, error) {
+	if !c.startReading() {
+		return PollResult{}, ErrReaderBusy
+	}
+	defer c.stopReading()
+
+	result := PollResult{Messages: []string{}}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case message := <-c.outbox:
+		result.Messages = append(result.Messages, string(message))
+	case <-c.closed:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	for _, message := range c.takeMessages() {
+		result.Messages = append(result.Messages, string(message))
+	}
+
+	// the close is only reported along with the last messages, since they have been queued before it
+	select {
+	case <-c.closed:
+		if len(c.outbox) == 0 {
+			result.Closed = true
+			result.CloseCode = c.closeCode
+			result.CloseReason = c.closeReason
+		}
+	default:
+	}
+
+	return result, nil
+}
+
+// takeMessages: returns the messages which are already queued, without waiting for more
+func (c *HTTPConnection) takeMessages() [][]byte {
+	var messages [][]byte
+	for {
+		select {
+		case message := <-c.outbox:
+			messages = append(messages, message)
+		default:
+			return messages
+		}
+	}
+}
+
+func (c *HTTPConnection) startReading() bool {
+	if !c.reading.TryLock() {
+		return false
+	}
+	c.idleTimer.Stop()
+	return true
+}
+
+func (c *HTTPConnection) stopReading() {
+	c.idleTimer.Reset(c.idleTimeout)
+	c.reading.Unlock()
+}
+
+// writeStreamEvent: every line of the message is written as a data line, since a message may span several lines
+func writeStreamEvent(w http.ResponseWriter, eventName string, message []byte) {
+	var builder strings.Builder
+	if eventName != "" {
+		builder.WriteString("event: " + eventName + "\n")
+	}
+	for _, line := range strings.Split(string(message), "\n") {
+		builder.WriteString("data: " + line + "\n")
+	}
+	builder.WriteString("\n")
+
+	w.Write([]byte(builder.String()))
+}
+
+// HTTPConnections: the open HTTP connections by id
+type HTTPConnections struct {
+	idleTimeout time.Duration
+
+	mutex       sync.Mutex
+	connections map[string]*HTTPConnection
+}
+
+// NewHTTPConnections: a connection whose messages are not read for the idle timeout is closed
+func NewHTTPConnections(idleTimeout time.Duration) *HTTPConnections {
+	return &HTTPConnections{
+		idleTimeout: idleTimeout,
+		connections: make(map[string]*HTTPConnection),
+	}
+}
+
+// Open: creates a connection with a random id for the client at the remote address, the id is the only credential
+// of the client, hence it cannot be guessed
+func (h *HTTPConnections) Open(remoteAddr string) *HTTPConnection {
+	randomBytes := make([]byte, 18)
+	rand.Read(randomBytes)
+
+	connection := &HTTPConnection{
+		ID:          base64.RawURLEncoding.EncodeToString(randomBytes),
+		remoteAddr:  remoteAddr,
+		inbox:       make(chan []byte, inboxSize),
+		outbox:      make(chan []byte, outboxSize),
+		idleTimeout: h.idleTimeout,
+		closed:      make(chan struct{}),
+	}
+	connection.idleTimer = time.AfterFunc(h.idleTimeout, func() {
+		connection.Close(websocket.CloseGoingAway, "the messages have not been read for too long")
+	})
+	connection.onClose = func() {
+		time.AfterFunc(closedRetention, func() {
+			h.mutex.Lock()
+			defer h.mutex.Unlock()
+			delete(h.connections, connection.ID)
+		})
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.connections[connection.ID] = connection
+
+	return connection
+}
+
+// Find: returns nil when there is no connection with the id
+func (h *HTTPConnections) Find(id string) *HTTPConnection {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.connections[id]
+}
@@ -0,0 +1,57 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestASlowHTTPClientIsDisconnected(t *testing.T) {
+	connection := NewHTTPConnections(time.Minute).Open("10.0.0.1")
+
+	for i := 0; i < outboxSize; i++ {
+		err := connection.WriteMessage([]byte(fmt.Sprintf("message %d", i)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// the client has not read any message, the write does not wait for it
+	written := make(chan error, 1)
+	go func() {
+		written <- connection.WriteMessage([]byte("one message too many"))
+	}()
+
+	select {
+	case err := <-written:
+		if !errors.Is(err, ErrSlowConsumer) {
+			t.Fatalf("got %v, want %v", err, ErrSlowConsumer)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the write waits for the client to read its messages")
+	}
+
+	err := connection.WriteMessage([]byte("after the close"))
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("got %v, want %v", err, ErrClosed)
+	}
+
+	// the client reads the messages queued before the close, then the close
+	result, err := connection.Poll(context.Background(), time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Messages) != outboxSize {
+		t.Fatalf("got %d messages, want %d", len(result.Messages), outboxSize)
+	}
+	if result.Messages[0] != "message 0" {
+		t.Fatalf("got %q first, want the first message", result.Messages[0])
+	}
+	if !result.Closed || result.CloseCode != websocket.ClosePolicyViolation {
+		t.Fatalf("got closed %t with the code %d, want the %d close code", result.Closed, result.CloseCode, websocket.ClosePolicyViolation)
+	}
+}
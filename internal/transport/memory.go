@@ -0,0 +1,125 @@
+package transport
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryConnection: a connection held in memory, it stands for a client when a room is driven without a network,
+// e.g. to exercise the room logic. The server side is the Connection, the client side sends its events with Send and
+// reads the messages written to it with Receive.
+type MemoryConnection struct {
+	remoteAddr string
+
+	inbox  chan []byte
+	outbox chan []byte
+
+	closed      chan struct{}
+	closeOnce   sync.Once
+	closeCode   int
+	closeReason string
+}
+
+// NewMemoryConnection: the connection of a client at the remote address, the writes wait while the client has
+// outboxSize messages left to read
+func NewMemoryConnection(remoteAddr string) *MemoryConnection {
+	return &MemoryConnection{
+		remoteAddr: remoteAddr,
+		inbox:      make(chan []byte, inboxSize),
+		outbox:     make(chan []byte, outboxSize),
+		closed:     make(chan struct{}),
+	}
+}
+
+// ReadMessage: returns the next event sent by the client
+func (c *MemoryConnection) ReadMessage() ([]byte, error) {
+	select {
+	case payload := <-c.inbox:
+		return payload, nil
+	case <-c.closed:
+		return nil, ErrClosed
+	}
+}
+
+// WriteMessage: queues the message until the client receives it
+func (c *MemoryConnection) WriteMessage(message []byte) error {
+	select {
+	case <-c.closed:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case c.outbox <- message:
+		return nil
+	case <-c.closed:
+		return ErrClosed
+	}
+}
+
+// Close: either side closes the connection, the close code and the reason are reported by CloseStatus
+func (c *MemoryConnection) Close(closeCode int, reason string) error {
+	c.closeOnce.Do(func() {
+		c.closeCode = closeCode
+		c.closeReason = reason
+		close(c.closed)
+	})
+	return nil
+}
+
+func (c *MemoryConnection) RemoteAddr() string {
+	return c.remoteAddr
+}
+
+// Send: the client sends an event, it waits while the previous events have not been read
+func (c *MemoryConnection) Send(ctx context.Context, payload []byte) error {
+	select {
+	case <-c.closed:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case c.inbox <- payload:
+		return nil
+	case <-c.closed:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Receive: the client reads the next message written to it, it waits until there is one. The messages written
+// before the connection has been closed are received before ErrClosed is returned.
+func (c *MemoryConnection) Receive(ctx context.Context) ([]byte, error) {
+	select {
+	case message := <-c.outbox:
+		return message, nil
+	default:
+	}
+
+	select {
+	case message := <-c.outbox:
+		return message, nil
+	case <-c.closed:
+		// a message may have been written while the connection was being closed
+		select {
+		case message := <-c.outbox:
+			return message, nil
+		default:
+			return nil, ErrClosed
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// CloseStatus: returns the close code and the reason once the connection has been closed, closed is false until then
+func (c *MemoryConnection) CloseStatus() (closeCode int, reason string, closed bool) {
+	select {
+	case <-c.closed:
+		return c.closeCode, c.closeReason, true
+	default:
+		return 0, "", false
+	}
+}
@@ -0,0 +1,58 @@
+// Package transport carries the events between the clients and the server, over a websocket or over plain HTTP
+// requests for the clients whose proxies do not let websocket upgrades through. The rooms only see a Connection.
+package transport
+
+import (
+	"errors"
+
+	"github.com/gorilla/websocket"
+	"github.com/skamranahmed/estimatex-server/internal/event"
+)
+
+// the close codes of the connections, they are the websocket ones whatever the transport, since every transport
+// reports them to the client
+const (
+	CloseNormalClosure   = websocket.CloseNormalClosure
+	CloseGoingAway       = websocket.CloseGoingAway
+	CloseAbnormalClosure = websocket.CloseAbnormalClosure
+	ClosePolicyViolation = websocket.ClosePolicyViolation
+	CloseServiceRestart  = websocket.CloseServiceRestart
+)
+
+var (
+	// ErrClosed is returned once the connection has been closed, by either side
+	ErrClosed = errors.New("connection closed")
+
+	// ErrInvalidMessage is returned when a message of the client cannot be decoded from its wire encoding
+	ErrInvalidMessage = errors.New("invalid message")
+)
+
+// Connection: the connection of a client, whatever its transport, the rooms never depend on a concrete transport. The messages are the JSON encoding of the events,
+// or plain text messages, each transport converts them from and to what it carries.
+type Connection interface {
+	// ReadMessage: returns the next message sent by the client, it blocks until there is one or the connection is closed
+	ReadMessage() ([]byte, error)
+
+	// WriteMessage: sends a message to the client
+	WriteMessage(message []byte) error
+
+	// Close: closes the connection, the client is given the close code and the reason when the transport allows it.
+	// Closing a connection which is already closed does nothing.
+	Close(closeCode int, reason string) error
+
+	// RemoteAddr: returns the IP address of the client. It is read from the forwarded headers of the trusted proxies,
+	// and is otherwise the source IP address of the connection.
+	RemoteAddr() string
+}
+
+// EventWriter: a connection which writes the events in its own wire encoding, the data shared by the events of
+// several members is converted once for all of them. The other connections are written the JSON encoding of the events.
+type EventWriter interface {
+	// WriteEvent: sends the event with the given data to the client, the data of the envelope is ignored
+	WriteEvent(envelope event.Event, data *event.SharedData) error
+}
+
+// IsCloseError: reports whether the error is the close of the connection by the client with one of the close codes
+func IsCloseError(err error, closeCodes ...int) bool {
+	return websocket.IsCloseError(err, closeCodes...)
+}
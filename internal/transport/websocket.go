@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/skamranahmed/estimatex-server/internal/codec"
+	"github.com/skamranahmed/estimatex-server/internal/event"
+)
+
+const (
+	// closeMessageTimeout is the time given to write the close message when a websocket connection is closed
+	closeMessageTimeout = time.Second
+
+	// writeTimeout is the time given to write a message, the connection is broken when the client does not read it in time
+	writeTimeout = 10 * time.Second
+)
+
+// WebSocket: a websocket connection, the events are written in the wire encoding picked by the client
+type WebSocket struct {
+	conn       *websocket.Conn
+	codec      codec.Codec
+	remoteAddr string
+	closeOnce  sync.Once
+}
+
+// NewWebSocket: the events are written as JSON until another codec is set. The remote address is the IP address
+// of the client of the upgrade request, without its port.
+func NewWebSocket(conn *websocket.Conn, remoteAddr string) *WebSocket {
+	return &WebSocket{
+		conn:       conn,
+		codec:      codec.JSON,
+		remoteAddr: remoteAddr,
+	}
+}
+
+// SetCodec: every codec can be carried by a websocket, the binary encodings are written in binary frames
+func (w *WebSocket) SetCodec(eventCodec codec.Codec) error {
+	w.codec = eventCodec
+	return nil
+}
+
+// ReadMessage: the binary frames are decoded with the codec, the text frames are JSON already. The close message
+// of the client is answered before the error is returned, as the websocket protocol requires.
+func (w *WebSocket) ReadMessage() ([]byte, error) {
+	messageType, payload, err := w.conn.ReadMessage()
+	if err != nil {
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+			w.Close(websocket.CloseNormalClosure, "Server closing connection")
+		}
+		return nil, err
+	}
+
+	if messageType == websocket.BinaryMessage {
+		payload, err = w.codec.Decode(payload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalidMessage, w.codec.Name(), err)
+		}
+	}
+	return payload, nil
+}
+
+func (w *WebSocket) WriteMessage(message []byte) error {
+	encodedMessage, err := w.codec.Encode(message)
+	if err != nil {
+		return err
+	}
+	return w.write(encodedMessage)
+}
+
+// WriteEvent: the data is converted to the encoding of the client once, whatever the number of members it is sent to
+func (w *WebSocket) WriteEvent(envelope event.Event, data *event.SharedData) error {
+	encodedEvent, err := w.codec.EncodeEvent(envelope, data)
+	if err != nil {
+		return err
+	}
+	return w.write(encodedEvent)
+}
+
+// write: a client which does not read its messages cannot hold up the writes for more than the write timeout
+func (w *WebSocket) write(encodedMessage []byte) error {
+	w.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return w.conn.WriteMessage(w.codec.FrameType(), encodedMessage)
+}
+
+func (w *WebSocket) RemoteAddr() string {
+	return w.remoteAddr
+}
+
+// Close: sends the close message to the client before closing the connection
+func (w *WebSocket) Close(closeCode int, reason string) error {
+	var err error
+	w.closeOnce.Do(func() {
+		w.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, reason), time.Now().Add(closeMessageTimeout))
+		err = w.conn.Close()
+	})
+	return err
+}
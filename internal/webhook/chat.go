@@ -0,0 +1,218 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// the colors of the side bar of the chat messages
+const (
+	chatColorRoom    = "#439FE0"
+	chatColorVoting  = "#ECB22E"
+	chatColorResults = "#2EB67D"
+)
+
+// chatEscaper escapes the control characters of the Slack and Mattermost message formatting
+var chatEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// chatMessage: the body of a Slack incoming webhook, Mattermost accepts the same body.
+// The attachments are used instead of the Slack blocks because Mattermost does not support the blocks.
+type chatMessage struct {
+	Text        string           `json:"text"`
+	Attachments []chatAttachment `json:"attachments,omitempty"`
+}
+
+type chatAttachment struct {
+	Fallback  string      `json:"fallback"`
+	Color     string      `json:"color"`
+	Title     string      `json:"title,omitempty"`
+	TitleLink string      `json:"title_link,omitempty"`
+	Text      string      `json:"text,omitempty"`
+	Fields    []chatField `json:"fields,omitempty"`
+}
+
+type chatField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// chatBody: formats the event as a chat message, ok is false for the events which are not posted to the chats
+func chatBody(event Event) (body []byte, ok bool, err error) {
+	var message chatMessage
+
+	switch data := event.Data.(type) {
+	case RoomCreatedData:
+		message = chatRoomCreatedMessage(event.RoomID, data)
+	case VotingStartedData:
+		message = chatVotingStartedMessage(event.RoomID, data)
+	case VotesRevealedData:
+		message = chatVotesRevealedMessage(event.RoomID, data)
+	case EstimateFinalizedData:
+		message = chatEstimateFinalizedMessage(event.RoomID, data)
+	default:
+		return nil, false, nil
+	}
+
+	body, err = json.Marshal(message)
+	if err != nil {
+		return nil, false, err
+	}
+	return body, true, nil
+}
+
+func chatRoomCreatedMessage(roomID string, data RoomCreatedData) chatMessage {
+	text := fmt.Sprintf("🏠 A new estimation room `%s` is open, join it to vote!", roomID)
+
+	fields := []chatField{
+		{Title: "Room ID", Value: roomID, Short: true},
+		{Title: "Capacity", Value: strconv.Itoa(data.MaxCapacity), Short: true},
+	}
+	if data.IsPasswordProtected {
+		fields = append(fields, chatField{Title: "Access", Value: "Password or invite token required", Short: true})
+	}
+
+	attachment := chatAttachment{
+		Fallback: text,
+		Color:    chatColorRoom,
+		Fields:   fields,
+	}
+	if data.JoinURL != "" {
+		attachment.Title = "Join the room"
+		attachment.TitleLink = data.JoinURL
+	}
+
+	return chatMessage{
+		Text:        text,
+		Attachments: []chatAttachment{attachment},
+	}
+}
+
+func chatVotingStartedMessage(roomID string, data VotingStartedData) chatMessage {
+	text := fmt.Sprintf("📝 Voting started for the ticket `%s` in the room `%s`", chatEscape(data.TicketID), roomID)
+
+	return chatMessage{
+		Text: text,
+		Attachments: []chatAttachment{{
+			Fallback:  text,
+			Color:     chatColorVoting,
+			Title:     chatTicketTitle(data.TicketID, data.TicketTitle),
+			TitleLink: data.TicketURL,
+		}},
+	}
+}
+
+func chatVotesRevealedMessage(roomID string, data VotesRevealedData) chatMessage {
+	text := fmt.Sprintf("🎉 The votes for the ticket `%s` have been revealed in the room `%s`", chatEscape(data.TicketID), roomID)
+
+	fields := []chatField{
+		{Title: "Votes", Value: strconv.Itoa(len(data.Votes)), Short: true},
+	}
+	if average, ok := averageVote(data.Votes); ok {
+		fields = append(fields, chatField{Title: "Average", Value: strconv.FormatFloat(average, 'f', -1, 64), Short: true})
+	}
+	fields = append(fields, chatField{Title: "Breakdown", Value: voteBreakdown(data.Votes)})
+
+	return chatMessage{
+		Text: text,
+		Attachments: []chatAttachment{{
+			Fallback: text,
+			Color:    chatColorResults,
+			Title:    chatTicketTitle(data.TicketID, ""),
+			Fields:   fields,
+		}},
+	}
+}
+
+func chatEstimateFinalizedMessage(roomID string, data EstimateFinalizedData) chatMessage {
+	text := fmt.Sprintf("🎯 The final estimate for the ticket `%s` is *%s*", chatEscape(data.TicketID), chatEscape(data.Estimate))
+
+	return chatMessage{
+		Text: text,
+		Attachments: []chatAttachment{{
+			Fallback: text,
+			Color:    chatColorResults,
+			Fields: []chatField{
+				{Title: "Ticket", Value: chatEscape(data.TicketID), Short: true},
+				{Title: "Estimate", Value: chatEscape(data.Estimate), Short: true},
+				{Title: "Room ID", Value: roomID, Short: true},
+			},
+		}},
+	}
+}
+
+func chatTicketTitle(ticketID string, ticketTitle string) string {
+	if ticketTitle == "" {
+		return "Ticket " + chatEscape(ticketID)
+	}
+	return chatEscape(ticketID) + ": " + chatEscape(ticketTitle)
+}
+
+// chatEscape: escapes the text entered by the members, so that it cannot mention a channel like `<!channel>` or hide a link
+func chatEscape(text string) string {
+	return chatEscaper.Replace(text)
+}
+
+// voteBreakdown: one line per vote value, the most common value first, along with the members who chose it
+func voteBreakdown(votes []Vote) string {
+	if len(votes) == 0 {
+		return "Nobody voted"
+	}
+
+	// Key: vote value, Value: names of the members
+	membersByValue := make(map[string][]string)
+	for _, vote := range votes {
+		membersByValue[vote.Value] = append(membersByValue[vote.Value], vote.MemberName)
+	}
+
+	values := make([]string, 0, len(membersByValue))
+	for value := range membersByValue {
+		values = append(values, value)
+	}
+	slices.SortFunc(values, func(a string, b string) int {
+		if countDifference := len(membersByValue[b]) - len(membersByValue[a]); countDifference != 0 {
+			return countDifference
+		}
+		return strings.Compare(a, b)
+	})
+
+	lines := make([]string, 0, len(values))
+	for _, value := range values {
+		members := membersByValue[value]
+		slices.Sort(members)
+
+		voteWord := "votes"
+		if len(members) == 1 {
+			voteWord = "vote"
+		}
+		lines = append(lines, fmt.Sprintf("*%s*: %d %s (%s)", chatEscape(value), len(members), voteWord, chatEscape(strings.Join(members, ", "))))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// averageVote: the average of the numeric votes, the other votes like `?` are ignored
+func averageVote(votes []Vote) (float64, bool) {
+	var sum float64
+	var count int
+
+	for _, vote := range votes {
+		value, err := strconv.ParseFloat(vote.Value, 64)
+		if err != nil || math.IsInf(value, 0) || math.IsNaN(value) {
+			continue
+		}
+		sum += value
+		count++
+	}
+
+	if count == 0 {
+		return 0, false
+	}
+
+	// rounded to 2 decimals, which is precise enough for the story points
+	return math.Round(sum/float64(count)*100) / 100, true
+}
@@ -0,0 +1,65 @@
+package webhook
+
+import "time"
+
+// EventType: the type of a webhook event
+type EventType string
+
+const (
+	EventRoomCreated       EventType = "room.created"
+	EventRoomClosed        EventType = "room.closed"
+	EventVotingStarted     EventType = "voting.started"
+	EventVotesRevealed     EventType = "votes.revealed"
+	EventEstimateFinalized EventType = "estimate.finalized"
+)
+
+// Event: the JSON body of a delivery
+type Event struct {
+	ID         string    `json:"id"`
+	Type       EventType `json:"type"`
+	RoomID     string    `json:"room_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data,omitempty"`
+}
+
+// RoomCreatedData represents data specific to the "room.created" event
+type RoomCreatedData struct {
+	MaxCapacity         int  `json:"max_capacity"`
+	IsPasswordProtected bool `json:"is_password_protected"`
+
+	// JoinURL is the link to join the room from a client, it is only set when the server knows its clients' URL
+	JoinURL string `json:"join_url,omitempty"`
+}
+
+// RoomClosedData represents data specific to the "room.closed" event
+type RoomClosedData struct {
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// VotingStartedData represents data specific to the "voting.started" event
+type VotingStartedData struct {
+	TicketID string `json:"ticket_id"`
+
+	// TicketTitle and TicketURL are only set when the ticket has been found in the issue tracker
+	TicketTitle string `json:"ticket_title,omitempty"`
+	TicketURL   string `json:"ticket_url,omitempty"`
+}
+
+// VotesRevealedData represents data specific to the "votes.revealed" event
+type VotesRevealedData struct {
+	TicketID string `json:"ticket_id"`
+	Votes    []Vote `json:"votes"`
+}
+
+// Vote: the vote of a member, as revealed to the room
+type Vote struct {
+	MemberID   string `json:"member_id"`
+	MemberName string `json:"member_name"`
+	Value      string `json:"value"`
+}
+
+// EstimateFinalizedData represents data specific to the "estimate.finalized" event
+type EstimateFinalizedData struct {
+	TicketID string `json:"ticket_id"`
+	Estimate string `json:"estimate"`
+}
@@ -0,0 +1,372 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skamranahmed/estimatex-server/internal/logger"
+	"github.com/skamranahmed/estimatex-server/internal/metrics"
+)
+
+// The headers sent with every delivery, the signature is computed over `<timestamp>.<body>`
+const (
+	HeaderEvent     = "X-EstimateX-Event"
+	HeaderDelivery  = "X-EstimateX-Delivery"
+	HeaderTimestamp = "X-EstimateX-Timestamp"
+	HeaderSignature = "X-EstimateX-Signature"
+
+	signaturePrefix = "sha256="
+)
+
+const (
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = time.Minute
+	defaultTimeout        = 10 * time.Second
+	defaultQueueSize      = 1000
+	defaultWorkers        = 4
+)
+
+var (
+	ErrInvalidSignature = errors.New("webhook signature is invalid")
+	ErrSignatureExpired = errors.New("webhook timestamp is outside of the tolerance")
+	errQueueFull        = errors.New("the delivery queue is full")
+)
+
+// Format: how the events are sent to a subscription
+type Format string
+
+const (
+	// FormatEvent: the JSON event, signed with the secret of the subscription
+	FormatEvent Format = ""
+
+	// FormatChat: a message for a Slack or Mattermost incoming webhook, it is not signed since
+	// the URL of an incoming webhook is its secret. Only some of the events are posted.
+	FormatChat Format = "chat"
+)
+
+// Subscription: a URL which receives the webhook events, the deliveries are signed with its secret
+type Subscription struct {
+	URL    string
+	Secret string
+	Format Format
+}
+
+// Config: the server wide subscriptions and the delivery settings, the zero values fall back to the defaults
+type Config struct {
+	// Subscriptions receive the events of every room
+	Subscriptions []Subscription
+
+	// MaxAttempts is the number of times a delivery is attempted before it is dead lettered
+	MaxAttempts int
+
+	// InitialBackoff is doubled after every failed attempt, up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Timeout is the time given to a single attempt
+	Timeout time.Duration
+
+	QueueSize int
+	Workers   int
+
+	// DeadLetterFile is the file to which the deliveries which could not be delivered are appended,
+	// as JSON lines. They are only logged when it is empty.
+	DeadLetterFile string
+}
+
+// Dispatcher: delivers the webhook events in the background, with retries and exponential backoff.
+// A nil *Dispatcher drops every event, which is how the webhooks are disabled.
+type Dispatcher struct {
+	config     Config
+	httpClient *http.Client
+	deliveries chan delivery
+	workers    sync.WaitGroup
+
+	// closed is protected by closeMutex, so that an event is never queued once the queue is closed
+	closeMutex sync.RWMutex
+	closed     bool
+
+	deadLetterMutex sync.Mutex
+}
+
+type delivery struct {
+	subscription Subscription
+	event        Event
+	body         []byte
+}
+
+// deadLetter: a line of the dead letter file
+type deadLetter struct {
+	FailedAt time.Time `json:"failed_at"`
+	URL      string    `json:"url"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	Event    Event     `json:"event"`
+}
+
+// NewDispatcher: creates a dispatcher and starts its workers, they are stopped by Close
+func NewDispatcher(config Config) *Dispatcher {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultMaxAttempts
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = defaultInitialBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = defaultMaxBackoff
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+	if config.Workers <= 0 {
+		config.Workers = defaultWorkers
+	}
+
+	d := &Dispatcher{
+		config:     config,
+		httpClient: &http.Client{Timeout: config.Timeout},
+		deliveries: make(chan delivery, config.QueueSize),
+	}
+
+	for i := 0; i < config.Workers; i++ {
+		d.workers.Add(1)
+		go d.work()
+	}
+
+	return d
+}
+
+// Publish: queues the event for the server wide subscriptions and for the given room subscriptions,
+// it never blocks. The id and the time of the event are set here.
+func (d *Dispatcher) Publish(roomSubscriptions []Subscription, event Event) {
+	if d == nil || len(d.config.Subscriptions)+len(roomSubscriptions) == 0 {
+		return
+	}
+
+	event.ID = uuid.New().String()
+	event.OccurredAt = time.Now().UTC()
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Unable to marshal the webhook event", "webhook_event", event.Type, logger.KeyError, err)
+		return
+	}
+
+	chatMessageBody, isChatEvent, err := chatBody(event)
+	if err != nil {
+		slog.Error("Unable to format the webhook event as a chat message", "webhook_event", event.Type, logger.KeyError, err)
+	}
+
+	d.closeMutex.RLock()
+	defer d.closeMutex.RUnlock()
+
+	if d.closed {
+		return
+	}
+
+	for _, subscription := range slices.Concat(d.config.Subscriptions, roomSubscriptions) {
+		queuedDelivery := delivery{subscription: subscription, event: event, body: body}
+		if subscription.Format == FormatChat {
+			if !isChatEvent {
+				continue
+			}
+			queuedDelivery.body = chatMessageBody
+		}
+
+		select {
+		case d.deliveries <- queuedDelivery:
+		default:
+			metrics.WebhookDeliveries.WithLabelValues("dropped").Inc()
+			d.deadLetter(queuedDelivery, 0, errQueueFull)
+		}
+	}
+}
+
+// Close: stops accepting events and waits for the queued ones to be delivered, or for the context to be done
+func (d *Dispatcher) Close(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+
+	d.closeMutex.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.deliveries)
+	}
+	d.closeMutex.Unlock()
+
+	workersDone := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(workersDone)
+	}()
+
+	select {
+	case <-workersDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.workers.Done()
+
+	for queuedDelivery := range d.deliveries {
+		d.deliver(queuedDelivery)
+	}
+}
+
+// deliver: attempts the delivery until it succeeds, fails with an error which is not worth retrying,
+// or runs out of attempts, in which case it is dead lettered
+func (d *Dispatcher) deliver(queuedDelivery delivery) {
+	deliveryLogger := slog.With(logger.KeyRoomID, queuedDelivery.event.RoomID, "webhook_event", queuedDelivery.event.Type, "webhook_url", queuedDelivery.subscription.URL)
+	backoff := d.config.InitialBackoff
+
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		var retryable bool
+		retryable, err = d.post(queuedDelivery)
+		if err == nil {
+			metrics.WebhookDeliveries.WithLabelValues("delivered").Inc()
+			deliveryLogger.Debug("Webhook delivered", "attempt", attempt)
+			return
+		}
+
+		if !retryable || attempt >= d.config.MaxAttempts {
+			break
+		}
+
+		deliveryLogger.Info("Webhook delivery failed, retrying", "attempt", attempt, "backoff", backoff.String(), logger.KeyError, err)
+
+		// the jitter spreads the retries of the deliveries which failed at the same time
+		time.Sleep(backoff + rand.N(backoff/2+1))
+		backoff = min(backoff*2, d.config.MaxBackoff)
+	}
+
+	metrics.WebhookDeliveries.WithLabelValues("failed").Inc()
+	d.deadLetter(queuedDelivery, attempt, err)
+}
+
+// post: sends the delivery once, and reports whether it is worth retrying when it fails
+func (d *Dispatcher) post(queuedDelivery delivery) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, queuedDelivery.subscription.URL, bytes.NewReader(queuedDelivery.body))
+	if err != nil {
+		return false, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "estimatex-webhooks")
+
+	if queuedDelivery.subscription.Format == FormatEvent {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		request.Header.Set(HeaderEvent, string(queuedDelivery.event.Type))
+		request.Header.Set(HeaderDelivery, queuedDelivery.event.ID)
+		request.Header.Set(HeaderTimestamp, timestamp)
+		request.Header.Set(HeaderSignature, Sign(queuedDelivery.subscription.Secret, timestamp, queuedDelivery.body))
+	}
+
+	response, err := d.httpClient.Do(request)
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("unexpected response status: %s", response.Status)
+
+	// the other client errors mean that the receiver will never accept the delivery
+	retryable := response.StatusCode >= 500 || response.StatusCode == http.StatusRequestTimeout || response.StatusCode == http.StatusTooManyRequests
+	return retryable, err
+}
+
+// deadLetter: records a delivery which could not be delivered, so that it can be replayed by hand
+func (d *Dispatcher) deadLetter(failedDelivery delivery, attempts int, err error) {
+	slog.Error("Webhook could not be delivered",
+		logger.KeyRoomID, failedDelivery.event.RoomID,
+		"webhook_event", failedDelivery.event.Type,
+		"webhook_url", failedDelivery.subscription.URL,
+		"webhook_delivery", failedDelivery.event.ID,
+		"attempts", attempts,
+		logger.KeyError, err,
+	)
+
+	if d.config.DeadLetterFile == "" {
+		return
+	}
+
+	line, _ := json.Marshal(deadLetter{
+		FailedAt: time.Now().UTC(),
+		URL:      failedDelivery.subscription.URL,
+		Attempts: attempts,
+		Error:    err.Error(),
+		Event:    failedDelivery.event,
+	})
+
+	d.deadLetterMutex.Lock()
+	defer d.deadLetterMutex.Unlock()
+
+	file, err := os.OpenFile(d.config.DeadLetterFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		slog.Error("Unable to open the webhook dead letter file", "path", d.config.DeadLetterFile, logger.KeyError, err)
+		return
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		slog.Error("Unable to write to the webhook dead letter file", "path", d.config.DeadLetterFile, logger.KeyError, err)
+	}
+}
+
+// Sign: computes the value of the signature header of a delivery
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify: checks the signature of a delivery, and that it has been sent within the tolerance so that
+// a captured delivery cannot be replayed later
+func Verify(secret string, timestamp string, body []byte, signature string, tolerance time.Duration) error {
+	sentAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	age := time.Since(time.Unix(sentAt, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrSignatureExpired
+	}
+
+	return nil
+}
@@ -1,16 +1,17 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"os"
 
 	"github.com/skamranahmed/estimatex-server/cmd"
+	"github.com/skamranahmed/estimatex-server/internal/logger"
 )
 
 func main() {
 	err := cmd.Run()
 	if err != nil {
-		log.Printf("Error during server startup: %+v", err)
+		slog.Error("Error during server startup", logger.KeyError, err)
 		os.Exit(1)
 	}
 }